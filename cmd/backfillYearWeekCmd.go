@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// backfillYearWeekCmd represents the backfill-yearweek command
+var backfillYearWeekCmd = &cobra.Command{
+	Use:   "backfill-yearweek",
+	Short: "Stores the year.week of the prices stored without it",
+	Long: `backfill-yearweek migrates the database and computes the year.week of the prices
+stored before the year_week column existed, so the exporter reads it instead of
+deriving it from the timestamp. It can be run as many times as needed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := sql.Open("sqlite3", dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		if _, err := collector.Migrate(db); err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+
+		updated, err := collector.BackfillYearWeek(db)
+		if err != nil {
+			log.Fatalf("Failed to backfill the year.week: %v", err)
+		}
+
+		log.Printf("Stored the year.week of %d prices in '%s'\n", updated, dbName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backfillYearWeekCmd)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// blacklistCmd groups the commands about the blacklisted symbols.
+var blacklistCmd = &cobra.Command{
+	Use:   "blacklist",
+	Short: "Commands about the symbols blacklisted by the collector",
+}
+
+// blacklistExportCmd writes the blacklist to a file.
+var blacklistExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the blacklist to a JSON or CSV file",
+	Long: `export writes the symbols blacklisted by the collector, read from --db-name,
+to a JSON (an array of objects) or CSV file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		format, _ := cmd.Flags().GetString("format")
+
+		db, err := sql.Open("sqlite3", dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		if _, err := collector.Migrate(db); err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+
+		if err := collector.ExportBlacklist(db, output, format); err != nil {
+			log.Fatalf("Failed to export the blacklist: %v", err)
+		}
+		fmt.Printf("Blacklist exported to '%s'\n", output)
+	},
+}
+
+// blacklistDedupeCmd removes the repeated symbols of the blacklist.
+var blacklistDedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Removes the repeated symbols of the blacklist",
+	Long: `dedupe removes the symbols repeated in the blacklist of --db-name, keeping the
+earliest row of each one. Databases created before the blacklist had a UNIQUE
+constraint can contain them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := sql.Open("sqlite3", dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		removed, err := collector.DedupeBlacklist(db, "")
+		if err != nil {
+			log.Fatalf("Failed to dedupe the blacklist: %v", err)
+		}
+		fmt.Printf("Removed %d duplicated rows from the blacklist\n", removed)
+	},
+}
+
+// blacklistFromFailuresCmd blacklists the symbols that failed too many times.
+var blacklistFromFailuresCmd = &cobra.Command{
+	Use:   "from-failures",
+	Short: "Blacklists the symbols that failed too many times",
+	Long: `from-failures adds to the blacklist of --db-name the symbols whose requests
+failed at least --threshold times, according to the failures recorded by the
+collector. The number of failures is recorded as the reason.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		threshold, _ := cmd.Flags().GetInt("threshold")
+
+		db, err := sql.Open("sqlite3", dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		if _, err := collector.Migrate(db); err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+
+		added, err := collector.BlacklistFromFailures(db, threshold)
+		if err != nil {
+			log.Fatalf("Failed to blacklist the symbols: %v", err)
+		}
+		fmt.Printf("Added %d symbols to the blacklist\n", added)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(blacklistCmd)
+	blacklistCmd.AddCommand(blacklistExportCmd)
+	blacklistCmd.AddCommand(blacklistDedupeCmd)
+	blacklistCmd.AddCommand(blacklistFromFailuresCmd)
+
+	blacklistExportCmd.Flags().StringP("output", "o", "blacklist.json", "Path to the output file")
+	blacklistExportCmd.Flags().String("format", collector.BlacklistFormatJSON, "Format of the output file: 'json' or 'csv'")
+	blacklistFromFailuresCmd.Flags().Int("threshold", 3, "Number of failures that blacklists a symbol")
+}
@@ -4,9 +4,17 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/agviu/investrends/collector"
+	"github.com/agviu/investrends/exporter"
 	"github.com/spf13/cobra"
 )
 
@@ -22,46 +30,288 @@ This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Declare variables that can be altered by the command line interface.
-		var dbName string
 		var apiKeyPath string
 		var production bool
-		var currencyListPath string
+		var currencyListPaths []string
 		var indexFilePath string
 		var clearBlacklist bool
 		var goroutine bool
+		var concurrency int
+		var market string
+		var mode string
+		var maxRuntime time.Duration
+		var maxMissingRatio float64
+		var storeBackend string
+		var storeDir string
+		var refetchInterval time.Duration
+		var apiUrl string
+		var storeBatchSize int
+		var printURL bool
+		var skipComplete bool
+		var apiKeys []string
+		var storeNullForMissing bool
+		var continueOnDbError bool
+		var proxy string
+		var backfillStart string
+		var backfillEnd string
+		var staleBefore string
+		var userAgent string
+		var authMode string
+		var maxErrors int
+		var interval time.Duration
+		var symbolColumn int
+		var exportTo string
+		var summaryJSON string
+		var noIndex bool
+		var skipUnchanged bool
+		var strictComplete bool
+		var forceLock bool
 
-		dbName, _ = cmd.Flags().GetString("db-name")
 		apiKeyPath, _ = cmd.Flags().GetString("api-key-file")
-		currencyListPath, _ = cmd.Flags().GetString("currency-list-file")
+		currencyListPaths, _ = cmd.Flags().GetStringArray("currency-list-file")
 		production, _ = cmd.Flags().GetBool("prod")
 		indexFilePath, _ = cmd.Flags().GetString("index-path")
 		clearBlacklist, _ = cmd.Flags().GetBool("clear-blacklist")
 		goroutine, _ = cmd.Flags().GetBool("goroutine")
+		concurrency, _ = cmd.Flags().GetInt("concurrency")
+		market, _ = cmd.Flags().GetString("market")
+		mode, _ = cmd.Flags().GetString("mode")
+		maxRuntime, _ = cmd.Flags().GetDuration("max-runtime")
+		maxMissingRatio, _ = cmd.Flags().GetFloat64("max-missing-ratio")
+		storeBackend, _ = cmd.Flags().GetString("store-backend")
+		storeDir, _ = cmd.Flags().GetString("store-dir")
+		refetchInterval, _ = cmd.Flags().GetDuration("refetch-interval")
+		apiUrl, _ = cmd.Flags().GetString("api-url")
+		storeBatchSize, _ = cmd.Flags().GetInt("store-batch-size")
+		printURL, _ = cmd.Flags().GetBool("print-url")
+		skipComplete, _ = cmd.Flags().GetBool("skip-complete")
+		apiKeys, _ = cmd.Flags().GetStringArray("api-key")
+		storeNullForMissing, _ = cmd.Flags().GetBool("store-null-for-missing")
+		continueOnDbError, _ = cmd.Flags().GetBool("continue-on-db-error")
+		proxy, _ = cmd.Flags().GetString("proxy")
+		backfillStart, _ = cmd.Flags().GetString("backfill-start")
+		backfillEnd, _ = cmd.Flags().GetString("backfill-end")
+		staleBefore, _ = cmd.Flags().GetString("stale-before")
+		userAgent, _ = cmd.Flags().GetString("user-agent")
+		authMode, _ = cmd.Flags().GetString("auth-mode")
+		maxErrors, _ = cmd.Flags().GetInt("max-errors")
+		interval, _ = cmd.Flags().GetDuration("interval")
+		symbolColumn, _ = cmd.Flags().GetInt("symbol-column")
+		exportTo, _ = cmd.Flags().GetString("export-to")
+		summaryJSON, _ = cmd.Flags().GetString("summary-json")
+		noIndex, _ = cmd.Flags().GetBool("no-index")
+		skipUnchanged, _ = cmd.Flags().GetBool("skip-unchanged")
+		strictComplete, _ = cmd.Flags().GetBool("strict-complete")
+		forceLock, _ = cmd.Flags().GetBool("force-lock")
 
-		// Create a collector with values passed by CLI (or default values)
-		c, err := collector.NewCollector(dbName, apiKeyPath,
-			"https://www.alphavantage.co/query?function=DIGITAL_CURRENCY_WEEKLY&symbol=%s&market=EUR&apikey=%s",
-			currencyListPath, production, indexFilePath)
+		if err := collector.ValidateStoreBackend(storeBackend); err != nil {
+			log.Fatalln(err.Error())
+		}
+		if storeBackend == collector.StoreBackendMemory && exportTo == "" {
+			log.Fatalln("the memory store backend keeps nothing after the run, --export-to is needed")
+		}
+		if err := collector.ValidateAuthMode(authMode); err != nil {
+			log.Fatalln(err.Error())
+		}
+		if authMode == collector.AuthModeHeader && strings.Count(apiUrl, "%s") != 1 {
+			log.Fatalf("--auth-mode header needs an --api-url with a single %%s, for the symbol")
+		}
+
+		// --goroutine is kept as an alias of a concurrency of 5.
+		if goroutine && !cmd.Flags().Changed("concurrency") {
+			concurrency = 5
+		}
+
+		// Create a collector with values passed by CLI (or default values).
+		// The API URL depends on the mode and market, unless it's overridden.
+		c, err := collector.NewCollectorWithOptions(collector.CollectorOptions{
+			DbFilePath:           dbName,
+			ApiKeyFilePath:       apiKeyPath,
+			ApiUrl:               apiUrl,
+			CurrencyListFilePath: currencyListPaths[0],
+			Production:           production,
+			IndexPath:            indexFilePath,
+			NoIndex:              noIndex,
+			Market:               market,
+			Mode:                 mode,
+		})
 		if err != nil {
 			log.Fatalln("unable to create collector object: ", err.Error())
 		}
+		if err := c.AddApiKeys(apiKeys...); err != nil {
+			log.Fatalln("invalid --api-key: ", err.Error())
+		}
+		c.ExtraCurrencyListFilePaths = currencyListPaths[1:]
+		c.Concurrency = concurrency
+		c.MaxMissingRatio = maxMissingRatio
+		c.StoreBackend = storeBackend
+		c.StoreDir = storeDir
+		c.RefetchInterval = refetchInterval
+		c.StoreBatchSize = storeBatchSize
+		c.PrintURL = printURL
+		c.SkipComplete = skipComplete
+		c.SkipUnchanged = skipUnchanged
+		c.StrictComplete = strictComplete
+		c.ForceLock = forceLock
+		c.StoreNullForMissing = storeNullForMissing
+		c.ContinueOnDbError = continueOnDbError
+		c.UserAgent = userAgent
+		c.AuthMode = authMode
+		c.MaxErrors = maxErrors
+		c.SymbolColumn = symbolColumn
+		c.BackfillWindow.Start, err = parseDateFlag(backfillStart)
+		if err != nil {
+			log.Fatalln("invalid --backfill-start: ", err.Error())
+		}
+		c.BackfillWindow.End, err = parseDateFlag(backfillEnd)
+		if err != nil {
+			log.Fatalln("invalid --backfill-end: ", err.Error())
+		}
+		c.StaleBefore, err = parseDateFlag(staleBefore)
+		if err != nil {
+			log.Fatalln("invalid --stale-before: ", err.Error())
+		}
+		if proxy != "" {
+			c.HTTPClient, err = collector.NewProxyClient(proxy)
+			if err != nil {
+				log.Fatalln("invalid --proxy: ", err.Error())
+			}
+		}
+
+		// The memory store backend keeps the prices in an in-memory database until they're exported.
+		if storeBackend == collector.StoreBackendMemory {
+			c.Db, err = collector.OpenMemoryDb()
+			if err != nil {
+				log.Fatalln("unable to open the in-memory database: ", err.Error())
+			}
+			defer c.Db.Close()
+		}
+
+		// SIGHUP reloads the currency list, so symbols can be added without a restart.
+		reload, stopReload := notifyReload(syscall.SIGHUP)
+		defer stopReload()
+		c.Reload = reload
+
+		// Stop the run cleanly once the max runtime is exceeded, if any.
+		ctx := context.Background()
+		if maxRuntime > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, maxRuntime)
+			defer cancel()
+		}
+
+		// Keep collecting every interval until interrupted, if asked to.
+		if interval > 0 {
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			cycles, err := collector.RunEvery(ctx, c, interval, collector.RunOptions{ClearBlacklist: clearBlacklist})
+			if err != nil {
+				log.Fatalln("Unable to run the collection loop: ", err.Error())
+			}
+			log.Println("Collection loop stopped after", cycles, "passes.")
+			if exportTo != "" {
+				if err := exportCollected(c, exportTo); err != nil {
+					log.Fatalln("Unable to export the prices: ", err.Error())
+				}
+			}
+			return
+		}
 
 		// Run the collector procedure.
-		var processed int
-		if goroutine {
-			processed, err = collector.RunGoRoutines(c, 5, clearBlacklist, true)
-		} else {
-			processed, err = collector.Run(c, 5, clearBlacklist)
+		result, err := collector.RunWithOptions(ctx, c, collector.RunOptions{ClearBlacklist: clearBlacklist})
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Println("Max runtime reached, the next run will continue from here.")
+			err = nil
+		}
+		if summaryJSON != "" {
+			if err := collector.WriteRunSummary(summaryJSON, result); err != nil {
+				log.Println("Unable to write the summary of the run:", err.Error())
+			}
 		}
 		if err != nil {
-			log.Fatal("Unfortunately there was an error running the program.", err.Error())
+			log.Println("Unfortunately there was an error running the program.", err.Error())
+			os.Exit(exitCode(err))
+		}
+
+		if exportTo != "" {
+			if err := exportCollected(c, exportTo); err != nil {
+				log.Fatalln("Unable to export the prices: ", err.Error())
+			}
 		}
 
-		log.Println("Processed", processed, "items")
+		log.Printf("Processed %d items, %.0f%% of them with complete data\n", result.Processed, result.CompleteRatio*100)
+		if len(result.Failed) > 0 {
+			log.Println("Unable to request", len(result.Failed), "items:", strings.Join(result.Failed, ", "))
+		}
+		if result.StopReason != nil {
+			log.Println("The run stopped before the end:", result.StopReason.Error())
+			os.Exit(exitCode(result.StopReason))
+		}
 		log.Println("Program ran succesfully.")
 	},
 }
 
+// Exports the prices of the database of the collector to the JSON file in path,
+// overwriting it. With the memory store backend they're read from its in-memory database.
+func exportCollected(c collector.Collector, path string) error {
+	opts := exporter.Options{Force: true}
+	if c.Db != nil {
+		_, err := exporter.ExportDb(context.Background(), c.Db, path, opts)
+		return err
+	}
+	_, err := exporter.Export(c.DbFilePath, path, opts)
+	return err
+}
+
+// Returns a channel that receives a value when the process gets one of the signals,
+// and the function to stop listening to them.
+// Signals received while the previous one is pending are merged into it.
+func notifyReload(signals ...os.Signal) (<-chan struct{}, func()) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+
+	reload := make(chan struct{}, 1)
+	go func() {
+		for range received {
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return reload, func() {
+		signal.Stop(received)
+		close(received)
+	}
+}
+
+// Parses a YYYY-MM-DD date given by flag. An empty value is the zero time.
+func parseDateFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Exit codes of the collector command, so scripts can tell why a run ended.
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitDailyLimit = 2
+)
+
+// Returns the exit code for the error a run ended with: 0 without error, 2 when the
+// daily limit of the API was reached, and 1 for the rest of errors.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, collector.ErrDailyLimitReached):
+		return exitDailyLimit
+	}
+	return exitError
+}
+
 func init() {
 	rootCmd.AddCommand(collectorCmd)
 
@@ -74,11 +324,41 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// collectorCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	collectorCmd.Flags().String("db-name", "./crypto.sqlite", "Path to the sqlite database file, name included")
-	collectorCmd.Flags().String("api-key-file", "apikey.txt", "Path to the text file that contains the API Key")
-	collectorCmd.Flags().String("currency-list-file", "digital_currency_list.csv", "Path to the CSV files that stores the list of currencies")
+	collectorCmd.Flags().String("api-key-file", "apikey.txt", "Path to the text file that contains the API Key, or several of them (one per line) used in turns")
+	collectorCmd.Flags().StringArray("api-key", nil, "More API keys, used in turns when the daily limit is reached. Can be repeated.")
+	collectorCmd.Flags().StringArray("currency-list-file", []string{"digital_currency_list.csv"}, "Path to the CSV files that stores the list of currencies. Repeat it to combine several lists.")
+	collectorCmd.Flags().Int("symbol-column", 0, "Column of the currency lists with the symbol, starting from 0.")
 	collectorCmd.Flags().Bool("prod", false, "Indicates if the program will run in production mode.")
 	collectorCmd.Flags().String("index-path", "index.txt", "Path to the text file where the index is stored.")
+	collectorCmd.Flags().Bool("no-index", false, "Don't read nor write the index file, every run starts from the first symbol.")
+	collectorCmd.Flags().Bool("force-lock", false, "Run even if another collector holds the lock file of the database (<db-name>.lock).")
 	collectorCmd.Flags().Bool("clear-blacklist", false, "Clear the blacklist before starting the collection.")
 	collectorCmd.Flags().Bool("goroutine", false, "Specify if it should use goroutines for processing.")
+	collectorCmd.Flags().MarkDeprecated("goroutine", "use --concurrency instead")
+	collectorCmd.Flags().Int("concurrency", 1, "Number of symbols processed at the same time. 1 means sequential.")
+	collectorCmd.Flags().String("market", collector.DefaultMarket, "Market (physical currency) the prices are converted to.")
+	collectorCmd.Flags().String("mode", collector.DefaultMode, "API function used to retrieve the prices.")
+	collectorCmd.Flags().Float64("max-missing-ratio", 0, "Reject the data of a symbol when more than this ratio of values are missing. 0 disables it.")
+	collectorCmd.Flags().String("store-backend", collector.StoreBackendSqlite, "Where the prices are stored: 'sqlite', 'csv' or 'json' (a file per symbol, the blacklist and the rest of the records are still kept in --db-name), or 'memory' (nothing on disk, see --export-to).")
+	collectorCmd.Flags().String("export-to", "", "Export the prices to this JSON file once the run finishes. Needed by the memory store backend.")
+	collectorCmd.Flags().String("store-dir", "prices", "Directory for the files of the csv and json store backends.")
+	collectorCmd.Flags().Bool("store-null-for-missing", false, "Store the weeks without value as NULL, so gaps can be told apart from data not collected.")
+	collectorCmd.Flags().Int("max-errors", 0, "Abort the run once this many symbols failed for a reason other than the daily limit. 0 means no limit.")
+	collectorCmd.Flags().Bool("continue-on-db-error", false, "Keep processing the symbols when their data can't be stored. By default the run stops.")
+	collectorCmd.Flags().Int("store-batch-size", 0, "Rows stored per database transaction. 0 stores the data of a symbol in a single one.")
+	collectorCmd.Flags().Bool("skip-complete", false, "Skip the symbols that already have the value of the current week.")
+	collectorCmd.Flags().Bool("strict-complete", false, "Blacklist the symbols whose data is incomplete, instead of storing it.")
+	collectorCmd.Flags().Bool("skip-unchanged", false, "Don't store the data of a symbol when the response is identical to the last one stored.")
+	collectorCmd.Flags().Duration("refetch-interval", 0, "Skip the symbols requested within this interval (e.g. 1h). 0 disables it.")
+	collectorCmd.Flags().String("api-url", "", "URL template of the API, with a %s for the symbol and another for the API key. Defaults to Alpha Vantage.")
+	collectorCmd.Flags().String("stale-before", "", "Only request the symbols whose latest value predates this date (YYYY-MM-DD).")
+	collectorCmd.Flags().String("backfill-start", "", "Only store the weeks from this date (YYYY-MM-DD), to backfill a historical range.")
+	collectorCmd.Flags().String("backfill-end", "", "Only store the weeks until this date (YYYY-MM-DD). Defaults to the last one available.")
+	collectorCmd.Flags().String("proxy", "", "Proxy used to request the API, e.g. http://localhost:3128 or socks5://localhost:1080.")
+	collectorCmd.Flags().String("auth-mode", collector.AuthModeQuery, "How the API key is sent: 'query' (in the URL) or 'header' (Authorization: Bearer, with an --api-url without the key).")
+	collectorCmd.Flags().String("user-agent", collector.DefaultUserAgent, "User-Agent header of the requests to the API.")
+	collectorCmd.Flags().String("summary-json", "", "Write the result of the run to this JSON file: counts, symbols by outcome and duration.")
+	collectorCmd.Flags().Bool("print-url", false, "Log the URL requested for every symbol, with the API key redacted.")
+	collectorCmd.Flags().Duration("interval", 0, "Keep running, collecting again this long after every pass (e.g. 24h) until interrupted. 0 runs a single pass.")
+	collectorCmd.Flags().Duration("max-runtime", 0, "Stop the collection after this duration (e.g. 50m). 0 means no limit.")
 }
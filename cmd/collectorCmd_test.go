@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/agviu/investrends/exporter"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/pflag"
+)
+
+// Runs the collector command against a stub server set with --api-url, which
+// answers every request with the given response. The currency list has only BTC.
+// It returns the path to the database and the number of requests received.
+func runStubCollection(t *testing.T, response []byte, args ...string) (string, int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Query().Get("apikey") != "TESTKEY123456789" {
+			t.Errorf("Expected the API key to be sent, got %q", r.URL.RawQuery)
+		}
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "crypto.sqlite")
+	apiKeyPath := filepath.Join(dir, "apikey.txt")
+	currencyListPath := filepath.Join(dir, "currencies.csv")
+	if err := os.WriteFile(apiKeyPath, []byte("TESTKEY123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(currencyListPath, []byte("currency code,currency name\nBTC,Bitcoin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repeated flags append to the values of previous executions.
+	collectorCmd.Flags().Lookup("currency-list-file").Value.(pflag.SliceValue).Replace(nil)
+
+	rootCmd.SetArgs(append([]string{"collector", "--db-name", dbPath,
+		"--api-url", server.URL + "/query?symbol=%s&apikey=%s",
+		"--api-key-file", apiKeyPath,
+		"--currency-list-file", currencyListPath,
+		"--index-path", filepath.Join(dir, "index.txt")}, args...))
+	defer rootCmd.SetArgs(nil)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("Failed to execute the collector command: %v", err)
+	}
+
+	return dbPath, atomic.LoadInt32(&requests)
+}
+
+// Runs a short collection against a stub server set with --api-url.
+func TestCollectorApiUrlFlag(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/sample_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the sample response: %v", err)
+	}
+
+	dbPath, requests := runStubCollection(t, response)
+	if requests != 1 {
+		t.Errorf("Expected 1 request to the stub server, got %d", requests)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'BTC'").Scan(&count); err != nil {
+		t.Fatalf("Failed to count the stored values: %v", err)
+	}
+	if count == 0 {
+		t.Error("Expected the values of BTC to be stored")
+	}
+}
+
+// Verifies end to end that the missing weeks are stored as NULL and exported as null.
+func TestStoreNullForMissing(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/half_missing_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the response: %v", err)
+	}
+
+	dbPath, _ := runStubCollection(t, response, "--store-null-for-missing")
+	defer collectorCmd.Flags().Set("store-null-for-missing", "false")
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var nulls, values int
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'BTC' AND value IS NULL").Scan(&nulls)
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'BTC' AND value IS NOT NULL").Scan(&values)
+	if nulls == 0 || values == 0 || nulls+values != collector.HistoryDepth {
+		t.Fatalf("Expected %d weeks with some NULL values, got %d NULL and %d values", collector.HistoryDepth, nulls, values)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+	if _, err := exporter.Export(dbPath, outputPath, exporter.Options{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(content), `"value": null`); got != nulls {
+		t.Errorf("Expected %d null values in the export, got %d", nulls, got)
+	}
+	if err := exporter.ValidateExport(outputPath); err != nil {
+		t.Errorf("Expected the export with null values to be valid: %v", err)
+	}
+}
+
+// Verifies end to end that the memory store backend collects and exports without a database file.
+func TestStoreBackendMemory(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/sample_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the sample response: %v", err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+	dbPath, _ := runStubCollection(t, response, "--store-backend", "memory", "--export-to", outputPath)
+	defer collectorCmd.Flags().Set("store-backend", collector.StoreBackendSqlite)
+	defer collectorCmd.Flags().Set("export-to", "")
+
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no database file with the memory store backend, got %v", err)
+	}
+	if err := exporter.ValidateExport(outputPath); err != nil {
+		t.Fatalf("Expected a valid export: %v", err)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"code": "BTC"`) {
+		t.Errorf("Expected the prices of BTC in the export, got %s", content)
+	}
+}
+
+// Verifies that with --no-index the run doesn't create the index file.
+func TestCollectorNoIndex(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/sample_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the sample response: %v", err)
+	}
+
+	dbPath, requests := runStubCollection(t, response, "--no-index")
+	defer collectorCmd.Flags().Set("no-index", "false")
+
+	if requests != 1 {
+		t.Errorf("Expected 1 request to the stub server, got %d", requests)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dbPath), "index.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no index file with --no-index, got %v", err)
+	}
+}
+
+// Verifies the exit codes for the ways a run can end.
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, 0},
+		{collector.ErrDailyLimitReached, 2},
+		{fmt.Errorf("stopping: %w", collector.ErrDailyLimitReached), 2},
+		{collector.DbError{Msg: "database disk image is malformed"}, 1},
+	}
+	for _, tc := range cases {
+		if got := exitCode(tc.err); got != tc.code {
+			t.Errorf("Expected exit code %d for %v, got %d", tc.code, tc.err, got)
+		}
+	}
+}
+
+// Verifies that the stop reason of a run that reached the daily limit maps to exit code 2.
+func TestDailyLimitStopReason(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/limit_achieved_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the response: %v", err)
+	}
+	dir := t.TempDir()
+	apiKeyPath := filepath.Join(dir, "apikey.txt")
+	currencyListPath := filepath.Join(dir, "currencies.csv")
+	os.WriteFile(apiKeyPath, []byte("TESTKEY123456789"), 0o644)
+	os.WriteFile(currencyListPath, []byte("currency code,currency name\nBTC,Bitcoin\n"), 0o644)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	c, err := collector.NewCollector(filepath.Join(dir, "crypto.sqlite"), apiKeyPath, server.URL+"/query?symbol=%s&apikey=%s",
+		currencyListPath, false, filepath.Join(dir, "index.txt"), collector.DefaultMarket, collector.DefaultMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := collector.Run(c, 5, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if code := exitCode(result.StopReason); code != 2 {
+		t.Errorf("Expected exit code 2, got %d (%v)", code, result.StopReason)
+	}
+}
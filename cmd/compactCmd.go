@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Releases the free space of the database file",
+	Long: `compact runs VACUUM and PRAGMA optimize on the SQLite database, so the file doesn't
+keep growing with the free pages left behind. It refuses to run while the database is
+in use, e.g. by a collection.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := collector.Compact(dbName)
+		if err != nil {
+			log.Fatalf("Failed to compact the database: %v", err)
+		}
+
+		log.Printf("Database '%s' compacted from %d to %d bytes\n", dbName, result.SizeBefore, result.SizeAfter)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+}
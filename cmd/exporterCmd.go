@@ -1,45 +1,231 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/agviu/investrends/exporter"
 	"github.com/spf13/cobra"
 )
 
 // Define variables to hold the flag values
-var dbName string
 var jsonOutputPath string
+var shape string
+var dryRun bool
+var force bool
+var splitBySymbol bool
+var outDir string
+var profile string
+var chunk int
+var strict bool
+var format string
+var noHeader bool
+var parallel int
+var fileMode string
+var tail int
+var dateFormat string
+var currencyLists []string
+var fill string
+var weights map[string]string
+var watch bool
+var watchInterval time.Duration
+var debounce time.Duration
+var gcsBucket string
+var gcsObject string
+var failOnEmpty bool
+var timezone string
 
 // exporterCmd represents the exporter command
 var exporterCmd = &cobra.Command{
 	Use:   "exporter",
 	Short: "Exports data from a SQLite database to a JSON file",
 	Long: `exporter is a command-line utility that exports data from a specified SQLite database file
-to a JSON file. It requires two arguments: the path to the SQLite file and the path for the output JSON file.`,
+to a JSON file. It requires the path for the output JSON file, the SQLite file is taken from --db-name.
+With --split-by-symbol, a JSON file per symbol is written in --out-dir instead.
+With --gcs-bucket and --gcs-object, the file is uploaded to Google Cloud Storage instead.
+With --watch, it keeps running and exports the data again every time the database changes.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// The output is a single file, or a directory when splitting by symbol.
+		output := jsonOutputPath
+		if splitBySymbol {
+			output = outDir
+		}
+		if gcsBucket != "" || gcsObject != "" {
+			if gcsBucket == "" || gcsObject == "" {
+				log.Fatalf("Both --gcs-bucket and --gcs-object are needed to upload to Google Cloud Storage")
+			}
+			output = "gs://" + gcsBucket + "/" + gcsObject
+		}
+		if output == "" {
+			log.Fatalf("Either --json, --split-by-symbol with --out-dir, or --gcs-bucket with --gcs-object, is required")
+		}
+
+		mode, err := exporter.ParseFileMode(fileMode)
+		if err != nil {
+			log.Fatalf("Invalid --file-mode: %v", err)
+		}
+		indexWeights, err := exporter.ParseWeights(weights)
+		if err != nil {
+			log.Fatalf("Invalid --weights: %v", err)
+		}
+		var location *time.Location
+		if timezone != "" {
+			location, err = time.LoadLocation(timezone)
+			if err != nil {
+				log.Fatalf("Invalid --timezone: %v", err)
+			}
+		}
+
+		// Call the Export function with the provided arguments
+		opts := exporter.Options{Shape: shape, DryRun: dryRun, Force: force, SplitBySymbol: splitBySymbol, OutDir: outDir, Profile: profile, Chunk: chunk, Strict: strict, Format: format, NoHeader: noHeader, Parallel: parallel, FileMode: mode, Filter: exporter.RowFilter{Tail: tail}, DateFormat: dateFormat, CurrencyLists: currencyLists, Fill: fill, Weights: indexWeights, Location: location}
+		if !watch {
+			stats, err := runExport(context.Background(), opts, output)
+			if err != nil {
+				log.Fatalf("Failed to export data: %v", err)
+			}
+			if code := exportExitCode(stats, failOnEmpty); code != exitOK {
+				log.Printf("No symbols were exported from '%s'", dbName)
+				os.Exit(code)
+			}
+			return
+		}
 
-		// Call the ExportToJSON function with the provided arguments
-		err := exporter.ExportToJSON(dbName, jsonOutputPath)
+		// Export again on every change of the database, until interrupted.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = exporter.Watch(ctx, dbName, watchInterval, debounce, func() error {
+			_, err := runExport(ctx, opts, output)
+			// The next exports replace the output of the previous one.
+			opts.Force = true
+			return err
+		})
 		if err != nil {
-			log.Fatalf("Failed to export data: %v", err)
+			log.Fatalf("Failed to watch the database: %v", err)
+		}
+	},
+}
+
+// Exports the data of --db-name with opts, reporting the result along with its stats.
+// The queries to the database are aborted once ctx is done.
+func runExport(ctx context.Context, opts exporter.Options, output string) (exporter.ExportStats, error) {
+	var stats exporter.ExportStats
+	var err error
+	if gcsBucket != "" && !opts.DryRun {
+		stats, err = exportToGCS(ctx, opts)
+	} else {
+		stats, err = exporter.ExportContext(ctx, dbName, jsonOutputPath, opts)
+	}
+	if err != nil {
+		return stats, err
+	}
+	if stats.Skipped > 0 {
+		for _, rowError := range stats.RowErrors {
+			log.Printf("Skipped the %v", rowError)
+		}
+		log.Printf("Skipped %d rows that could not be read, use --strict to fail on them instead", stats.Skipped)
+	}
+
+	if opts.DryRun {
+		fmt.Printf("Dry run: %d symbols and %d entries would be exported from '%s' to '%s'\n", stats.Symbols, stats.Entries, dbName, output)
+		return stats, nil
+	}
+
+	fmt.Printf("Data exported successfully from '%s' to '%s': %d symbols and %d entries\n", dbName, output, stats.Symbols, stats.Entries)
+	return stats, nil
+}
+
+// Exit code of the exporter command when no symbols were exported, with --fail-on-empty.
+const exitEmptyExport = 3
+
+// Returns the exit code for the stats of an export: exitEmptyExport when no
+// symbols were exported and failOnEmpty is set, 0 otherwise.
+func exportExitCode(stats exporter.ExportStats, failOnEmpty bool) int {
+	if failOnEmpty && stats.Symbols == 0 {
+		return exitEmptyExport
+	}
+	return exitOK
+}
+
+// Exports the data of --db-name with opts to the --gcs-object of --gcs-bucket.
+// The object is left as it was if the export fails.
+func exportToGCS(ctx context.Context, opts exporter.Options) (exporter.ExportStats, error) {
+	// Cancelling the context of the writer discards the upload.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := newGCSWriter(ctx, gcsBucket, gcsObject)
+	if err != nil {
+		return exporter.ExportStats{}, fmt.Errorf("unable to open the object of Google Cloud Storage: %w", err)
+	}
+	opts.Writer = w
+	stats, err := exporter.ExportContext(ctx, dbName, "", opts)
+	if err != nil {
+		cancel()
+		w.Close()
+		return stats, err
+	}
+	if err := w.Close(); err != nil {
+		return stats, fmt.Errorf("unable to write the object of Google Cloud Storage: %w", err)
+	}
+	return stats, nil
+}
+
+// exporterValidateCmd checks that an exported JSON file has the expected shape.
+var exporterValidateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Validates an exported JSON file",
+	Long: `validate checks that every symbol of an exported JSON file (objects shape) has a code,
+the expected category and mode, and prices with a valid year.week and a positive value.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exporter.ValidateExport(args[0]); err != nil {
+			log.Fatalf("Invalid export '%s': %v", args[0], err)
 		}
 
-		fmt.Printf("Data exported successfully from '%s' to '%s'\n", dbName, jsonOutputPath)
+		fmt.Printf("'%s' is a valid export\n", args[0])
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(exporterCmd)
+	exporterCmd.AddCommand(exporterValidateCmd)
 
 	// Here you will define your flags and configuration settings.
 
 	// Define the named flags for the exporterCmd
-	exporterCmd.Flags().StringVarP(&dbName, "db-name", "d", "", "Path to the sqlite database file")
-	exporterCmd.Flags().StringVarP(&jsonOutputPath, "json", "j", "", "Path to the output JSON file")
+	exporterCmd.Flags().StringVarP(&jsonOutputPath, "json", "j", "", "Path to the output file (JSON, or the one of --format), required unless --split-by-symbol is used")
+	exporterCmd.Flags().BoolVar(&splitBySymbol, "split-by-symbol", false, "Write a <symbol>.json file per symbol in --out-dir instead of a single file")
+	exporterCmd.Flags().StringVar(&outDir, "out-dir", "", "Directory for the files of --split-by-symbol")
+	exporterCmd.Flags().StringVar(&gcsBucket, "gcs-bucket", "", "Upload the file to this Google Cloud Storage bucket instead of writing it, with the application default credentials")
+	exporterCmd.Flags().StringVar(&gcsObject, "gcs-object", "", "Name of the object of --gcs-bucket, e.g. exports/crypto.json")
+	exporterCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of files of --split-by-symbol written at the same time")
+	exporterCmd.Flags().BoolVar(&watch, "watch", false, "Keep running and export again every time the database changes, overwriting the output")
+	exporterCmd.Flags().DurationVar(&watchInterval, "watch-interval", 2*time.Second, "How often --watch checks if the database changed")
+	exporterCmd.Flags().DurationVar(&debounce, "debounce", time.Second, "How long the database must stay unchanged before --watch exports it")
+	exporterCmd.Flags().BoolVar(&failOnEmpty, "fail-on-empty", false, "Exit with code 3 when no symbols were exported, e.g. because the database is empty")
+	exporterCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print how many symbols and entries would be exported, without writing the file")
+	exporterCmd.Flags().BoolVar(&force, "force", false, "Overwrite the output JSON file if it already exists. Off by default to keep previous exports safe")
+	exporterCmd.Flags().IntVar(&chunk, "chunk", 0, "Split the export in numbered files (out-001.json, out-002.json...) of at most this many symbols. 0 writes a single file")
+	exporterCmd.Flags().StringVar(&format, "format", exporter.FormatJSON, "Format of the output file: 'json', 'csv', 'parquet' or 'sql' (INSERT statements)")
+	exporterCmd.Flags().BoolVar(&noHeader, "no-header", false, "Leave out the header row of the CSV, e.g. to append it to a previous export")
+	exporterCmd.Flags().StringVar(&fileMode, "file-mode", "0644", "Permissions of the exported files, in octal (e.g. 0664 for group-writable files)")
+	exporterCmd.Flags().StringArrayVar(&currencyLists, "currency-list-file", nil, "Export only the symbols of this currency list, leaving out the ones not tracked anymore. Can be repeated")
+	exporterCmd.Flags().IntVar(&tail, "tail", 0, "Export only the N most recent prices of every symbol. 0 exports all of them")
+	exporterCmd.Flags().BoolVar(&strict, "strict", false, "Fail on the first row that can't be read, e.g. with an unparseable timestamp, instead of skipping it")
+	exporterCmd.Flags().StringVar(&dateFormat, "date-format", exporter.DateFormatYearWeek, "Date of the prices: 'year.week' or 'iso-date' (YYYY-MM-DD)")
+	exporterCmd.Flags().StringVar(&timezone, "timezone", "", "Time zone of the weeks of the prices, e.g. America/New_York. The dates are taken as midnight UTC, so west of UTC a Monday counts in the week before")
+	exporterCmd.Flags().StringToStringVar(&weights, "weights", nil, "Export a single index, with the code INDEX, weighting the values of these symbols, e.g. BTC=0.6,ETH=0.4. Only the weeks with a value of all of them are kept")
+	exporterCmd.Flags().StringVar(&fill, "fill", exporter.FillNone, "How to fill the weeks without value: 'none' or 'forward' (carry the previous value)")
+	exporterCmd.Flags().StringVar(&profile, "profile", exporter.ProfileDefault, "Field names of the objects shape: 'default' (e.g. year.week) or 'snake' (e.g. year_week)")
+	exporterCmd.Flags().StringVar(&shape, "shape", exporter.ShapeObjects, "Shape of the JSON: 'objects' (array of symbols) or 'tuples' (symbol to [timestamp, value] pairs)")
 
-	// Mark the flags as required
-	exporterCmd.MarkFlagRequired("db-name")
-	exporterCmd.MarkFlagRequired("json")
+	// --json and --out-dir are mutually exclusive, one of them is checked when running.
+	exporterCmd.MarkFlagsMutuallyExclusive("json", "out-dir")
+	exporterCmd.MarkFlagsMutuallyExclusive("json", "gcs-bucket")
+	exporterCmd.MarkFlagsMutuallyExclusive("split-by-symbol", "gcs-bucket")
 }
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/agviu/investrends/exporter"
+)
+
+// Fake object of Google Cloud Storage, holding what was written once closed.
+type fakeGCSObject struct {
+	bytes.Buffer
+	bucket, object string
+	closed         bool
+}
+
+func (o *fakeGCSObject) Close() error {
+	o.closed = true
+	return nil
+}
+
+// Verifies that with --gcs-bucket and --gcs-object the export is written to the object.
+func TestExportToGCS(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/sample_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the sample response: %v", err)
+	}
+	dbPath, _ := runStubCollection(t, response)
+
+	object := &fakeGCSObject{}
+	defer func(previous func(context.Context, string, string) (io.WriteCloser, error)) { newGCSWriter = previous }(newGCSWriter)
+	newGCSWriter = func(ctx context.Context, bucket, name string) (io.WriteCloser, error) {
+		object.bucket, object.object = bucket, name
+		return object, nil
+	}
+
+	rootCmd.SetArgs([]string{"exporter", "--db-name", dbPath, "--gcs-bucket", "prices", "--gcs-object", "exports/crypto.json"})
+	defer rootCmd.SetArgs(nil)
+	defer exporterCmd.Flags().Set("gcs-bucket", "")
+	defer exporterCmd.Flags().Set("gcs-object", "")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("Failed to execute the exporter command: %v", err)
+	}
+
+	if object.bucket != "prices" || object.object != "exports/crypto.json" || !object.closed {
+		t.Fatalf("Expected the object exports/crypto.json of the bucket prices to be written, got %q of %q", object.object, object.bucket)
+	}
+	if !strings.Contains(object.String(), `"code": "BTC"`) {
+		t.Errorf("Expected the prices of BTC in the object, got %s", object.String())
+	}
+}
+
+// Verifies that with --fail-on-empty only the export of an empty database has a non-zero exit code.
+func TestExportExitCode(t *testing.T) {
+	response, err := os.ReadFile("../collector/datatest/sample_response.json")
+	if err != nil {
+		t.Fatalf("Failed to read the sample response: %v", err)
+	}
+	collectedPath, _ := runStubCollection(t, response)
+
+	emptyPath := filepath.Join(t.TempDir(), "crypto.sqlite")
+	db, err := sql.Open("sqlite3", emptyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collector.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	cases := []struct {
+		dbPath      string
+		failOnEmpty bool
+		code        int
+	}{
+		{collectedPath, true, exitOK},
+		{emptyPath, true, exitEmptyExport},
+		{emptyPath, false, exitOK},
+	}
+	for _, tc := range cases {
+		stats, err := exporter.Export(tc.dbPath, "", exporter.Options{DryRun: true})
+		if err != nil {
+			t.Fatalf("Export of %s failed: %v", tc.dbPath, err)
+		}
+		if code := exportExitCode(stats, tc.failOnEmpty); code != tc.code {
+			t.Errorf("Expected exit code %d for %d symbols with failOnEmpty %v, got %d", tc.code, stats.Symbols, tc.failOnEmpty, code)
+		}
+	}
+}
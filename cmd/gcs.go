@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// Opens a writer to the object of a Google Cloud Storage bucket. The object is
+// only written once the writer is closed. A variable so the tests can replace
+// the cloud storage client.
+var newGCSWriter = func(ctx context.Context, bucket, object string) (io.WriteCloser, error) {
+	// The client uses the application default credentials.
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return gcsWriter{client.Bucket(bucket).Object(object).NewWriter(ctx), client}, nil
+}
+
+// Writer to a GCS object, closing the client along with it.
+type gcsWriter struct {
+	*storage.Writer
+	client *storage.Client
+}
+
+func (w gcsWriter) Close() error {
+	defer w.client.Close()
+	return w.Writer.Close()
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/agviu/investrends/exporter"
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Loads an exported JSON file back into the database",
+	Long: `import stores the prices of a JSON file written by the exporter (objects shape) in the
+SQLite database of --db-name, e.g. to restore a backup. The dates of the prices are kept
+when exported with --date-format iso-date, otherwise every week gets its Sunday.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exporter.ImportFromJSON(args[0], dbName); err != nil {
+			log.Fatalf("Failed to import '%s': %v", args[0], err)
+		}
+
+		log.Printf("Imported '%s' into '%s'\n", args[0], dbName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
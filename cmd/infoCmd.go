@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// infoCmd represents the info command
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Lists the modes, markets and asset classes supported by the collector",
+	Long: `info prints the values the collector accepts for --mode and --market, the asset
+classes it knows about, and the URL template of the API for the given mode and market.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode, _ := cmd.Flags().GetString("mode")
+		market, _ := cmd.Flags().GetString("market")
+
+		return writeInfo(cmd.OutOrStdout(), mode, market)
+	},
+}
+
+// Writes the supported values and the URL template of the mode and market to w.
+func writeInfo(w io.Writer, mode string, market string) error {
+	_, err := fmt.Fprintf(w, "Modes: %s\nMarkets: %s\nAsset classes: %s\nURL template (%s, %s): %s\n",
+		strings.Join(collector.SupportedModes(), ", "),
+		strings.Join(collector.SupportedMarkets(), ", "),
+		strings.Join(collector.SupportedAssetClasses(), ", "),
+		mode, market, collector.ApiUrlTemplate(mode, market))
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+
+	infoCmd.Flags().String("market", collector.DefaultMarket, "Market of the URL template.")
+	infoCmd.Flags().String("mode", collector.DefaultMode, "Mode of the URL template.")
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/agviu/investrends/collector"
+)
+
+// Verifies that the info command lists the supported modes and the URL template.
+func TestInfoCommand(t *testing.T) {
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs([]string{"info", "--market", "USD"})
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetArgs(nil)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("Failed to execute the info command: %v", err)
+	}
+
+	output := out.String()
+	for _, mode := range collector.SupportedModes() {
+		if !strings.Contains(output, mode) {
+			t.Errorf("Expected the output to contain the mode %q, got %q", mode, output)
+		}
+	}
+	if !strings.Contains(output, "crypto") || !strings.Contains(output, collector.ApiUrlTemplate(collector.DefaultMode, "USD")) {
+		t.Errorf("Expected the asset classes and the URL template of USD, got %q", output)
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// metaCmd represents the meta command
+var metaCmd = &cobra.Command{
+	Use:   "meta",
+	Short: "Prints when the API refreshed the data of the symbols",
+	Long: `meta prints when the API refreshed the data of every symbol for the last time,
+as recorded by the collector. This is not the date of the latest price stored.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		symbol, _ := cmd.Flags().GetString("symbol")
+
+		db, err := sql.Open("sqlite3", dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		if _, err := collector.Migrate(db); err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+
+		var metas []collector.SymbolMeta
+		if symbol != "" {
+			meta, err := collector.GetSymbolMeta(db, strings.ToUpper(symbol))
+			if err != nil {
+				log.Fatalf("Failed to get the metadata: %v", err)
+			}
+			metas = append(metas, meta)
+		} else {
+			metas, err = collector.ListSymbolMeta(db)
+			if err != nil {
+				log.Fatalf("Failed to read the metadata: %v", err)
+			}
+		}
+		if len(metas) == 0 {
+			fmt.Println("No metadata recorded yet.")
+			return
+		}
+
+		fmt.Printf("%-10s %-20s  %s\n", "SYMBOL", "LAST REFRESHED", "RECORDED AT")
+		for _, meta := range metas {
+			fmt.Printf("%-10s %-20s  %s\n", meta.Symbol, meta.LastRefreshed, meta.UpdatedAt)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(metaCmd)
+
+	metaCmd.Flags().String("symbol", "", "Symbol of the currency, e.g. BTC. All of them when empty")
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Creates or updates the database schema",
+	Long: `migrate prepares the SQLite database used by the collector, creating the tables
+if they don't exist and applying any pending schema change. It can be run as many
+times as needed, the schema version is tracked in the database itself.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := collector.OpenDb(dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		version, err := collector.Migrate(db)
+		if err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+
+		log.Printf("Database '%s' is at schema version %d\n", dbName, version)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// previewCmd represents the preview command
+var previewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Prints how many values the API has for a symbol, without storing them",
+	Long: `preview requests the data of a single symbol and prints how many values its time
+series has, to estimate the coverage before a run. Nothing is stored, but the request
+counts for the daily limit of the API.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		symbol, _ := cmd.Flags().GetString("symbol")
+		apiKeyPath, _ := cmd.Flags().GetString("api-key-file")
+		market, _ := cmd.Flags().GetString("market")
+		mode, _ := cmd.Flags().GetString("mode")
+		apiUrl, _ := cmd.Flags().GetString("api-url")
+
+		c, err := collector.NewCollectorWithOptions(collector.CollectorOptions{
+			DbFilePath:     dbName,
+			ApiKeyFilePath: apiKeyPath,
+			ApiUrl:         apiUrl,
+			Market:         market,
+			Mode:           mode,
+		})
+		if err != nil {
+			log.Fatalln("unable to create collector object: ", err.Error())
+		}
+
+		count, err := collector.PreviewSymbol(c, strings.ToUpper(symbol))
+		if err != nil {
+			log.Fatalf("Failed to preview the symbol: %v", err)
+		}
+		fmt.Printf("%s has %d values in its time series, the collector stores the last %d weeks\n", strings.ToUpper(symbol), count, collector.HistoryDepth)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(previewCmd)
+
+	previewCmd.Flags().String("symbol", "", "Symbol of the currency, e.g. BTC")
+	previewCmd.MarkFlagRequired("symbol")
+	previewCmd.Flags().String("api-key-file", "apikey.txt", "Path to the text file that contains the API Key")
+	previewCmd.Flags().String("market", collector.DefaultMarket, "Market (physical currency) the prices are converted to.")
+	previewCmd.Flags().String("mode", collector.DefaultMode, "API function used to retrieve the prices.")
+	previewCmd.Flags().String("api-url", "", "URL template of the API, with a %s for the symbol and another for the API key. Defaults to Alpha Vantage.")
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// priceCmd represents the price command
+var priceCmd = &cobra.Command{
+	Use:   "price",
+	Short: "Prints the latest price stored for a symbol",
+	Long: `price prints the most recent value stored in the database for a symbol,
+along with its date.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		symbol, _ := cmd.Flags().GetString("symbol")
+
+		db, err := sql.Open("sqlite3", dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		date, value, err := collector.LatestPrice(db, strings.ToUpper(symbol))
+		if err != nil {
+			log.Fatalf("Failed to get the price: %v", err)
+		}
+		fmt.Printf("%s %s %v\n", strings.ToUpper(symbol), date, value)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(priceCmd)
+
+	priceCmd.Flags().String("symbol", "", "Symbol of the currency, e.g. BTC")
+	priceCmd.MarkFlagRequired("symbol")
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// probeCmd represents the probe command
+var probeCmd = &cobra.Command{
+	Use:   "probe",
+	Short: "Finds the rate limits of the API key",
+	Long: `probe requests the data of a symbol as fast as possible until the API throttles the
+requests, to find how many of them the API key can send per minute and per day, instead
+of assuming 5 and 100. It stops after --max-requests, which count for the daily limit.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		symbol, _ := cmd.Flags().GetString("symbol")
+		apiKeyPath, _ := cmd.Flags().GetString("api-key-file")
+		apiUrl, _ := cmd.Flags().GetString("api-url")
+		maxRequests, _ := cmd.Flags().GetInt("max-requests")
+
+		c, err := collector.NewCollectorWithOptions(collector.CollectorOptions{
+			DbFilePath:     dbName,
+			ApiKeyFilePath: apiKeyPath,
+			ApiUrl:         apiUrl,
+		})
+		if err != nil {
+			log.Fatalln("unable to create collector object: ", err.Error())
+		}
+
+		result, err := collector.Probe(context.Background(), c, strings.ToUpper(symbol), maxRequests)
+		if err != nil {
+			log.Fatalf("Failed to probe the API: %v", err)
+		}
+		printProbeResult(result)
+	},
+}
+
+// Prints the limits found by the probe, or the lower bound of the ones not reached.
+func printProbeResult(result collector.ProbeResult) {
+	fmt.Printf("Sent %d requests\n", result.Requests)
+	if result.PerMinute > 0 {
+		fmt.Printf("Requests per minute: %d\n", result.PerMinute)
+	} else {
+		fmt.Println("Requests per minute: not throttled")
+	}
+	if result.PerDay > 0 {
+		fmt.Printf("Requests per day: %d\n", result.PerDay)
+	} else {
+		fmt.Println("Requests per day: not reached, raise --max-requests to find it")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(probeCmd)
+
+	probeCmd.Flags().String("symbol", "BTC", "Symbol of the currency requested, e.g. BTC")
+	probeCmd.Flags().Int("max-requests", collector.DefaultProbeRequests, "Most requests sent, so the probe doesn't use up the daily limit of the API")
+	probeCmd.Flags().String("api-key-file", "apikey.txt", "Path to the text file that contains the API Key")
+	probeCmd.Flags().String("api-url", "", "URL template of the API, with a %s for the symbol and another for the API key. Defaults to Alpha Vantage.")
+}
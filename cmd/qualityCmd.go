@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// qualityCmd represents the quality command
+var qualityCmd = &cobra.Command{
+	Use:   "quality",
+	Short: "Lists the symbols that return incomplete data",
+	Long: `quality prints the symbols whose responses were incomplete (fewer values than
+requested) the most times, as recorded by the collector in the database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		db, err := collector.OpenDb(dbName)
+		if err != nil {
+			log.Fatalf("Failed to open the database: %v", err)
+		}
+		defer db.Close()
+
+		if _, err := collector.Migrate(db); err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+
+		records, err := collector.WorstQuality(db, limit)
+		if err != nil {
+			log.Fatalf("Failed to read the quality records: %v", err)
+		}
+		if len(records) == 0 {
+			fmt.Println("No symbol returned incomplete data.")
+			return
+		}
+
+		fmt.Printf("%-10s %10s %16s %10s  %s\n", "SYMBOL", "RUNS", "INCOMPLETE RUNS", "LAST", "LAST SEEN")
+		for _, r := range records {
+			last := fmt.Sprintf("%d/%d", r.Extracted, r.Expected)
+			fmt.Printf("%-10s %10d %16d %10s  %s\n", r.Symbol, r.Runs, r.IncompleteRuns, last, r.LastSeen)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(qualityCmd)
+
+	qualityCmd.Flags().Int("limit", 10, "Maximum number of symbols to list")
+}
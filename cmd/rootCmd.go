@@ -6,9 +6,13 @@ package cmd
 import (
 	"os"
 
+	"github.com/agviu/investrends/collector"
 	"github.com/spf13/cobra"
 )
 
+// dbName holds the path to the sqlite database file, shared by all the subcommands.
+var dbName string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "investrends",
@@ -22,6 +26,27 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+
+	// Set up the log level before running any subcommand, and load the API keys
+	// of the subcommands that use them, so a wrong key fails before doing anything.
+	// The keys are kept by the collector package, and reused by the subcommand.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		level, _ := cmd.Flags().GetString("log-level")
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			level = "debug"
+		}
+		if err := collector.SetUpLogging(os.Stderr, level); err != nil {
+			return err
+		}
+
+		if cmd.Flags().Lookup("api-key-file") != nil {
+			apiKeyPath, _ := cmd.Flags().GetString("api-key-file")
+			if _, err := collector.LoadApiKeys(apiKeyPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -39,6 +64,9 @@ func init() {
 	// will be global for your application.
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.investrends.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&dbName, "db-name", "d", "./crypto.sqlite", "Path to the sqlite database file, name included")
+	rootCmd.PersistentFlags().String("log-level", "info", "Minimum level of the logs: debug, info, warn or error")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Show debug logs, same as --log-level debug")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Verifies that every subcommand inherits the persistent --db-name flag.
+func TestDbNamePersistentFlag(t *testing.T) {
+	for _, subcommand := range rootCmd.Commands() {
+		if subcommand.InheritedFlags().Lookup("db-name") == nil {
+			t.Errorf("Expected the subcommand %q to inherit the db-name flag", subcommand.Name())
+		}
+		if subcommand.LocalNonPersistentFlags().Lookup("db-name") != nil {
+			t.Errorf("The subcommand %q should not define its own db-name flag", subcommand.Name())
+		}
+	}
+}
+
+// Verifies that the --db-name flag passed after a subcommand reaches it.
+func TestDbNameFlagAfterSubcommand(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "crypto.sqlite")
+
+	rootCmd.SetArgs([]string{"migrate", "--db-name", dbPath})
+	defer rootCmd.SetArgs(nil)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("Failed to execute the migrate command: %v", err)
+	}
+
+	if dbName != dbPath {
+		t.Errorf("Expected db-name to be %q, got %q", dbPath, dbName)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("Expected the database to be created at %q: %v", dbPath, err)
+	}
+}
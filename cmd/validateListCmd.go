@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+	"github.com/spf13/cobra"
+)
+
+// validateListCmd represents the validate-list command
+var validateListCmd = &cobra.Command{
+	Use:   "validate-list",
+	Short: "Writes a copy of the currency list without the symbols the API doesn't know",
+	Long: `validate-list requests every symbol of the currency list once, respecting the
+rate limit, and writes a cleaned CSV without the symbols that don't return data.
+The symbols that could not be probed (e.g. once the daily limit is reached) are kept.
+It's a maintenance tool, meant to be run once in a while.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		apiKeyPath, _ := cmd.Flags().GetString("api-key-file")
+		currencyListPath, _ := cmd.Flags().GetString("currency-list-file")
+		outputPath, _ := cmd.Flags().GetString("output")
+		market, _ := cmd.Flags().GetString("market")
+		mode, _ := cmd.Flags().GetString("mode")
+		apiUrl, _ := cmd.Flags().GetString("api-url")
+
+		if apiUrl == "" {
+			apiUrl = collector.ApiUrlTemplate(mode, market)
+		}
+		c, err := collector.NewCollector(dbName, apiKeyPath, apiUrl, currencyListPath, false, "", market, mode)
+		if err != nil {
+			log.Fatalln("unable to create collector object: ", err.Error())
+		}
+
+		validation, err := collector.ValidateCurrencyList(context.Background(), c, 5, outputPath)
+		if err != nil {
+			log.Fatalf("Failed to validate the currency list: %v", err)
+		}
+
+		fmt.Printf("%d valid, %d dead and %d unchecked symbols. Cleaned list written to '%s'\n",
+			len(validation.Valid), len(validation.Dead), len(validation.Unchecked), outputPath)
+		if len(validation.Dead) > 0 {
+			fmt.Println("Dead symbols:", strings.Join(validation.Dead, ", "))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateListCmd)
+
+	validateListCmd.Flags().String("api-key-file", "apikey.txt", "Path to the text file that contains the API Key")
+	validateListCmd.Flags().String("currency-list-file", "digital_currency_list.csv", "Path to the CSV file that stores the list of currencies")
+	validateListCmd.Flags().String("output", "digital_currency_list.clean.csv", "Path to the cleaned CSV file")
+	validateListCmd.Flags().String("market", collector.DefaultMarket, "Market (physical currency) the prices are converted to.")
+	validateListCmd.Flags().String("mode", collector.DefaultMode, "API function used to retrieve the prices.")
+	validateListCmd.Flags().String("api-url", "", "URL template of the API, with a %s for the symbol and another for the API key. Defaults to Alpha Vantage.")
+}
@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set of API keys used in turns: when the current one reaches the daily limit,
+// the next one not exhausted is used. It is safe to use from several goroutines.
+type apiKeyPool struct {
+	mu        sync.Mutex
+	keys      []string
+	current   int
+	exhausted map[string]bool
+}
+
+// Creates a pool with the given keys, starting from the first one.
+func newApiKeyPool(keys []string) *apiKeyPool {
+	return &apiKeyPool{keys: keys, exhausted: make(map[string]bool)}
+}
+
+// Returns the key in use.
+func (p *apiKeyPool) key() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keys[p.current]
+}
+
+// Marks the key as exhausted and moves to the next key that is not. It returns
+// false when all of them are exhausted, in which case the pool starts over
+// from the first key, as the caller either waits for the limit to reset or stops.
+func (p *apiKeyPool) exhaust(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.exhausted[key] = true
+	for i := range p.keys {
+		if !p.exhausted[p.keys[i]] {
+			p.current = i
+			return true
+		}
+	}
+
+	p.exhausted = make(map[string]bool)
+	p.current = 0
+	return false
+}
+
+// Appends keys to the pool, ignoring the ones already in it.
+func (p *apiKeyPool) add(keys ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, key := range keys {
+		if !containsString(p.keys, key) {
+			p.keys = append(p.keys, key)
+		}
+	}
+}
+
+// Tells if s is among the values.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// API keys already loaded, by the path of their file, so every command and
+// collector of the process reads and validates the file once, until it changes.
+var (
+	apiKeysMu    sync.Mutex
+	apiKeysCache = make(map[string]cachedApiKeys)
+)
+
+// API keys loaded from a file, along with the state of the file when it was read.
+type cachedApiKeys struct {
+	modTime time.Time
+	size    int64
+	keys    []string
+}
+
+// Reads a file, replaced in the tests to count the reads.
+var readFile = os.ReadFile
+
+// Gets the API keys from a file in filePath, one per line. The file is read and
+// validated the first time only, the keys are reused afterwards while the
+// modification time and the size of the file don't change.
+func LoadApiKeys(filePath string) ([]string, error) {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		delete(apiKeysCache, filePath)
+		return nil, FileSystemError{Msg: "Error reading the apiKey file. Is it missing?"}
+	}
+	if cached, ok := apiKeysCache[filePath]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.keys, nil
+	}
+	keys, err := readApiKeys(filePath)
+	if err != nil {
+		delete(apiKeysCache, filePath)
+		return nil, err
+	}
+	apiKeysCache[filePath] = cachedApiKeys{modTime: info.ModTime(), size: info.Size(), keys: keys}
+	return keys, nil
+}
+
+// Reads and validates the API keys from a file in filePath, one per line.
+func readApiKeys(filePath string) ([]string, error) {
+	data, err := readFile(filePath)
+	if err != nil {
+		return nil, FileSystemError{Msg: "Error reading the apiKey file. Is it missing?"}
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" {
+			continue
+		}
+		if err := validateApiKey(key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, DataError{Msg: "The apiKey does not have the proper format."}
+	}
+	return keys, nil
+}
+
+// Checks that the API key has the format of the Alpha Vantage keys.
+func validateApiKey(key string) error {
+	if len(key) != 16 {
+		return DataError{Msg: "The apiKey does not have the proper format."}
+	}
+	return nil
+}
+
+// Adds more API keys to the ones used in turns by the collector.
+func (c *Collector) AddApiKeys(keys ...string) error {
+	for _, key := range keys {
+		if err := validateApiKey(key); err != nil {
+			return err
+		}
+	}
+
+	if c.apiKeys == nil {
+		var initial []string
+		if c.ApiKey != "" {
+			initial = append(initial, c.ApiKey)
+		}
+		c.apiKeys = newApiKeyPool(initial)
+	}
+	c.apiKeys.add(keys...)
+	if c.ApiKey == "" && len(keys) > 0 {
+		c.ApiKey = keys[0]
+	}
+	return nil
+}
+
+// Returns the API key in use.
+func (c Collector) currentApiKey() string {
+	if c.apiKeys == nil {
+		return c.ApiKey
+	}
+	return c.apiKeys.key()
+}
+
+// Marks the API key as exhausted for today. It returns true when there is
+// another key to continue with.
+func (c Collector) exhaustApiKey(key string) bool {
+	if c.apiKeys == nil {
+		return false
+	}
+	return c.apiKeys.exhaust(key)
+}
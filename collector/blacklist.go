@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Formats supported by ExportBlacklist.
+const (
+	BlacklistFormatJSON = "json"
+	BlacklistFormatCSV  = "csv"
+)
+
+// A symbol of the blacklist.
+type BlacklistEntry struct {
+	Symbol string `json:"symbol"`
+}
+
+// Returns the blacklisted symbols, sorted alphabetically.
+func ListBlacklist(db *sql.DB) ([]BlacklistEntry, error) {
+	rows, err := db.Query("SELECT symbol FROM blacklist ORDER BY symbol")
+	if err != nil {
+		return nil, DbError{Msg: "Failed to read the blacklist: " + err.Error()}
+	}
+	defer rows.Close()
+
+	var entries []BlacklistEntry
+	for rows.Next() {
+		var entry BlacklistEntry
+		if err := rows.Scan(&entry.Symbol); err != nil {
+			return nil, DbError{Msg: "Failed to read the blacklist: " + err.Error()}
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DbError{Msg: "Failed to read the blacklist: " + err.Error()}
+	}
+	return entries, nil
+}
+
+// Writes the blacklist to the file in path, in JSON (an array of entries) or CSV format.
+func ExportBlacklist(db *sql.DB, path string, format string) error {
+	if format != BlacklistFormatJSON && format != BlacklistFormatCSV {
+		return DataError{Msg: fmt.Sprintf("invalid format %q, valid options are: %s, %s", format, BlacklistFormatJSON, BlacklistFormatCSV)}
+	}
+
+	entries, err := ListBlacklist(db)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return FileSystemError{Msg: "Error creating the blacklist file " + path}
+	}
+	defer file.Close()
+
+	if format == BlacklistFormatJSON {
+		// An empty blacklist is exported as an empty array, not null.
+		if entries == nil {
+			entries = []BlacklistEntry{}
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(entries)
+	} else {
+		writer := csv.NewWriter(file)
+		writer.Write([]string{"symbol"})
+		for _, entry := range entries {
+			writer.Write([]string{entry.Symbol})
+		}
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		return FileSystemError{Msg: "Error writing the blacklist file " + path + ": " + err.Error()}
+	}
+	return file.Close()
+}
+
+// Blacklists a symbol telling why, e.g. "incomplete". The reason of a symbol
+// already blacklisted is replaced.
+func BlacklistWithReason(db *sql.DB, symbol string, reason string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO blacklist (symbol, reason) VALUES (?, ?)", symbol, reason)
+	if err != nil {
+		return DbError{Msg: "Failed to blacklist " + symbol, Err: err}
+	}
+	return nil
+}
+
+// Removes the repeated symbols of the blacklist, keeping the earliest row of each one.
+// Tables created before the UNIQUE constraint can have them. The default table
+// is used when table is empty. It returns the number of rows removed.
+func DedupeBlacklist(db *sql.DB, table string) (int64, error) {
+	if table == "" {
+		table = "blacklist"
+	}
+
+	result, err := db.Exec(fmt.Sprintf("DELETE FROM %[1]s WHERE rowid NOT IN (SELECT MIN(rowid) FROM %[1]s GROUP BY symbol)", table))
+	if err != nil {
+		return 0, DbError{Msg: "Failed to remove the duplicates of the blacklist: " + err.Error()}
+	}
+	return result.RowsAffected()
+}
+
+// Blacklists the symbols whose requests failed at least threshold times, according to
+// the failures recorded during the runs, with the number of failures as the reason.
+// The symbols already in the blacklist are left as they are. It returns the number
+// of symbols added.
+func BlacklistFromFailures(db *sql.DB, threshold int) (int64, error) {
+	if threshold < 1 {
+		return 0, DataError{Msg: fmt.Sprintf("invalid threshold %d, it must be at least 1", threshold)}
+	}
+
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO blacklist (symbol, reason)
+		SELECT symbol, printf('failed %d times', COUNT(*)) FROM fetch_failures
+		GROUP BY symbol HAVING COUNT(*) >= ?`, threshold)
+	if err != nil {
+		return 0, DbError{Msg: "Failed to blacklist the symbols from the failures: " + err.Error()}
+	}
+	return result.RowsAffected()
+}
@@ -1,6 +1,8 @@
 package collector
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
@@ -8,25 +10,50 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// Number of weekly values requested for every symbol.
+const HistoryDepth = 25
+
+// Outcome of requesting a symbol to the API. It's an alias of int, so the
+// callbacks receiving it can be declared outside the package.
+type ApiStatus = int
+
 // These are possible values returned by the API.
 const (
-	allGood = iota
-	limitReached
-	missingDate
-	missingSymbol
-	jsonBroken
+	StatusAllGood ApiStatus = iota
+	StatusLimitReached
+	StatusMissingDate
+	StatusMissingSymbol
+	StatusJsonBroken
+	// The body of the response is empty, e.g. the connection was cut. Worth retrying.
+	StatusEmptyResponse
+	// The request failed before getting a response.
+	StatusRequestFailed
+	// The response is the one of the demo API key for the symbols it doesn't give access to.
+	StatusDemoKey
+	// The API answered with the message of the limit of requests per minute, not the daily one.
+	StatusThrottled
 )
 
+// API key of the Alpha Vantage examples, which only gives access to a few symbols.
+const DemoApiKey = "demo"
+
+// Explains why most of the symbols fail with the demo API key.
+const demoKeyHelp = "the demo API key only gives access to the symbols of the Alpha Vantage examples, claim a free API key at https://www.alphavantage.co/support/#api-key"
+
 type CollectorInterface interface {
 	ReadCurrencyList() ([][]string, error)
 	setUpDb(sqlStmt string) (*sql.DB, error)
@@ -36,6 +63,21 @@ type CollectorInterface interface {
 	GetURLFromSymbol(symbol string) string
 	isProduction() bool
 	getIndexPath() string
+	getConcurrency() int
+	getRefetchInterval() time.Duration
+	skipComplete() bool
+	skipUnchanged() bool
+	strictComplete() bool
+	staleBefore() time.Time
+	currentApiKey() string
+	exhaustApiKey(key string) bool
+	continueOnDbError() bool
+	maxErrors() int
+	reloadSignal() <-chan struct{}
+	progressFunc() func(symbol string, index, total int, status ApiStatus)
+	sharedDb() *sql.DB
+	lockPath() string
+	forceLock() bool
 }
 
 // The data as it comes from the API is stored here.
@@ -43,9 +85,75 @@ type CryptoDataRaw struct {
 	MetaData struct {
 		LastRefreshed string `json:"6. Last Refreshed"`
 	} `json:"Meta Data"`
-	TimeSeries map[string]struct {
-		Close string `json:"4a. close (EUR)"`
-	} `json:"Time Series (Digital Currency Weekly)"`
+	TimeSeries map[string]timeSeriesEntry `json:"Time Series (Digital Currency Weekly)"`
+}
+
+// A single value of the time series.
+type timeSeriesEntry = struct {
+	Close string `json:"4a. close (EUR)"`
+}
+
+// Decodes the API's response. The name of the time series and of the close
+// value depend on the mode and the market requested, so they are looked up
+// in the response instead of relying on the struct tags.
+func (cdr *CryptoDataRaw) UnmarshalJSON(data []byte) error {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+
+	var metaData map[string]string
+	if raw, ok := response["Meta Data"]; ok {
+		if err := json.Unmarshal(raw, &metaData); err != nil {
+			return err
+		}
+	}
+	cdr.MetaData.LastRefreshed = metaData["6. Last Refreshed"]
+
+	market := metaData["4. Market Code"]
+	for key, raw := range response {
+		if !strings.HasPrefix(key, "Time Series") {
+			continue
+		}
+
+		var series map[string]map[string]string
+		if err := json.Unmarshal(raw, &series); err != nil {
+			return err
+		}
+		cdr.TimeSeries = make(map[string]timeSeriesEntry, len(series))
+		for date, values := range series {
+			cdr.TimeSeries[date] = timeSeriesEntry{Close: values[findCloseKey(values, market)]}
+		}
+	}
+
+	return nil
+}
+
+// Returns the key of the close value among the values of an entry of the time
+// series, e.g. "4a. close (EUR)", as the names change with the market and the mode.
+// It's the first key, in alphabetical order, with "close" in it (in any case),
+// preferring the ones of the market. Empty when there is none.
+func findCloseKey(values map[string]string, market string) string {
+	var closeKeys []string
+	for key := range values {
+		if strings.Contains(strings.ToLower(key), "close") {
+			closeKeys = append(closeKeys, key)
+		}
+	}
+	sort.Strings(closeKeys)
+
+	if market != "" {
+		suffix := strings.ToLower("(" + market + ")")
+		for _, key := range closeKeys {
+			if strings.HasSuffix(strings.ToLower(key), suffix) {
+				return key
+			}
+		}
+	}
+	if len(closeKeys) > 0 {
+		return closeKeys[0]
+	}
+	return ""
 }
 
 // The data that can be processed is stored here.
@@ -53,11 +161,27 @@ type CryptoDataCurated struct {
 	symbol string
 	date   string
 	value  float64
+	// The API has no value for the date, it's stored as NULL.
+	missing bool
+}
+
+// Creates the curated data of a symbol for a date ("YYYY-MM-DD"), e.g. to store
+// prices that don't come from the API. Missing values are stored as NULL.
+func NewCryptoDataCurated(symbol string, date string, value float64, missing bool) CryptoDataCurated {
+	return CryptoDataCurated{symbol: symbol, date: date, value: value, missing: missing}
+}
+
+// Returns the value to store in the database, nil for the missing ones.
+func (c CryptoDataCurated) nullableValue() interface{} {
+	if c.missing {
+		return nil
+	}
+	return c.value
 }
 
 // Defines some function types
 type ExtractDataFromValuesFunc func(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDataCurated, int, error)
-type StoreDataFunc func(db *sql.DB, data []CryptoDataCurated, tableName string) error
+type StoreDataFunc func(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) error
 type GetDataFunc func(resource string) ([]byte, error)
 
 // Collector struct defines fields for storing configuration options.
@@ -67,76 +191,448 @@ type Collector struct {
 	ApiKeyFilePath       string
 	ApiUrl               string
 	CurrencyListFilePath string
+	Market               string
+	Mode                 string
 	production           bool
 	indexPath            string
+	// Number of symbols processed at the same time. 1 (or less) means sequential.
+	Concurrency int
+	// Maximum ratio of missing values before the data of a symbol is rejected. 0 disables it.
+	MaxMissingRatio float64
+	// Where the curated data is stored (see the StoreBackend constants), and the
+	// directory for the file based ones.
+	StoreBackend string
+	StoreDir     string
+	// Symbols fetched within this interval are not requested again. 0 disables it.
+	RefetchInterval time.Duration
+	// Number of rows StoreData inserts per transaction. 0 stores all of them in one.
+	StoreBatchSize int
+	// More currency lists, appended in order to the one in CurrencyListFilePath.
+	ExtraCurrencyListFilePaths []string
+	// Logs the URL requested for every symbol, with the API key redacted.
+	PrintURL bool
+	// Skips the symbols that already have a value for the current week.
+	SkipComplete bool
+	// Stores the missing weekly values as NULL, instead of leaving a gap.
+	StoreNullForMissing bool
+	// Keeps processing symbols when the data can't be stored, instead of stopping the run.
+	ContinueOnDbError bool
+	// Client used to request the API, e.g. one from NewProxyClient. The default one when nil.
+	HTTPClient *http.Client
+	// Weeks extracted from the responses. The last HistoryDepth ones when it has no bounds.
+	BackfillWindow ExtractWindow
+	// Only the symbols whose latest value predates this date are requested. Zero disables it.
+	StaleBefore time.Time
+	// Every value received reloads the currency list during the run, queueing the new symbols.
+	Reload <-chan struct{}
+	// User-Agent header of the requests to the API. DefaultUserAgent when empty.
+	UserAgent string
+	// Called after processing every symbol of the currency list, with its position
+	// in the list (from 1), the number of symbols in it and the outcome of the request,
+	// one of the Status constants. The symbols retried at the end of the run are reported again.
+	OnProgress func(symbol string, index, total int, status ApiStatus)
+	// How the API key is sent, AuthModeQuery when empty. With AuthModeHeader the
+	// URL template only has the placeholder of the symbol.
+	AuthMode string
+	// Aborts the run with ErrTooManyErrors once this many symbols failed for a reason
+	// other than the daily limit, e.g. the network is down. 0 means no limit.
+	MaxErrors int
+	// Column of the currency lists with the symbol, the first one (0) by default.
+	SymbolColumn int
+	// Keys used in turns when there are several of them, nil otherwise.
+	apiKeys *apiKeyPool
+	// Database used instead of the one in DbFilePath, e.g. an in-memory one from
+	// OpenMemoryDb. It's left open after the run, so the data can be read afterwards.
+	Db *sql.DB
+	// Asset class of the symbols, DefaultAssetClass when empty. Along with the mode,
+	// it chooses how the responses are extracted, see RegisterExtractor.
+	AssetClass string
+	// Skips extracting and storing the data of a symbol when the response is
+	// identical to the last one stored, see LastContentHash.
+	SkipUnchanged bool
+	// Blacklists the symbols whose data is incomplete, with fewer than HistoryDepth
+	// values, instead of storing it.
+	StrictComplete bool
+	// Runs even if the lock file of the database is held by another running process.
+	// Without it, the run fails with ErrLocked.
+	ForceLock bool
+}
+
+// Default paths of the files used by the collector, relative to the working directory.
+const (
+	DefaultDbFilePath           = "crypto.sqlite"
+	DefaultApiKeyFilePath       = "apikey.txt"
+	DefaultCurrencyListFilePath = "digital_currency_list.csv"
+	DefaultIndexPath            = "index.txt"
+)
+
+// Options of NewCollectorWithOptions. The fields left empty take their default value.
+type CollectorOptions struct {
+	// DefaultDbFilePath, DefaultApiKeyFilePath, DefaultCurrencyListFilePath and DefaultIndexPath when empty.
+	DbFilePath           string
+	ApiKeyFilePath       string
+	CurrencyListFilePath string
+	IndexPath            string
+	// DefaultMarket and DefaultMode when empty.
+	Market string
+	Mode   string
+	// The Alpha Vantage URL of the mode and the market when empty, see ApiUrlTemplate.
+	ApiUrl     string
+	Production bool
+	// The index is neither read nor written, so every run starts from the first
+	// symbol. IndexPath is ignored.
+	NoIndex bool
 }
 
 // Creates a new Collector struct.
-func NewCollector(dbFilePath string, apiKeyFilePath string, apiUrl string, currencyListFilePath string, production bool, indexPath string) (Collector, error) {
-	// Read the apiKey from the file where it is stored.
-	apiKey, err := getApiKey(apiKeyFilePath)
+// The market and the mode must be among the ones supported by the collector.
+func NewCollector(dbFilePath string, apiKeyFilePath string, apiUrl string, currencyListFilePath string, production bool, indexPath string, market string, mode string) (Collector, error) {
+	return NewCollectorWithOptions(CollectorOptions{
+		DbFilePath:           dbFilePath,
+		ApiKeyFilePath:       apiKeyFilePath,
+		ApiUrl:               apiUrl,
+		CurrencyListFilePath: currencyListFilePath,
+		Production:           production,
+		IndexPath:            indexPath,
+		Market:               market,
+		Mode:                 mode,
+	})
+}
+
+// Creates a new Collector struct from the options, applying the defaults to the empty ones.
+// The market and the mode must be among the ones supported by the collector.
+func NewCollectorWithOptions(opts CollectorOptions) (Collector, error) {
+	opts = opts.withDefaults()
+	if opts.NoIndex {
+		opts.IndexPath = ""
+	}
+	if err := validateMarketAndMode(opts.Market, opts.Mode); err != nil {
+		var c Collector
+		return c, err
+	}
+
+	if err := checkDbFilePath(opts.DbFilePath); err != nil {
+		var c Collector
+		return c, err
+	}
+
+	// Read the apiKeys from the file where they are stored, one per line.
+	apiKeys, err := LoadApiKeys(opts.ApiKeyFilePath)
 	if err != nil {
 		var c Collector
 		return c, err
 	}
 	c := Collector{
-		DbFilePath:           dbFilePath,
-		ApiKey:               apiKey,
-		CurrencyListFilePath: currencyListFilePath,
-		ApiUrl:               apiUrl,
-		ApiKeyFilePath:       apiKeyFilePath,
-		production:           production,
-		indexPath:            indexPath,
+		DbFilePath:           opts.DbFilePath,
+		ApiKey:               apiKeys[0],
+		CurrencyListFilePath: opts.CurrencyListFilePath,
+		ApiUrl:               opts.ApiUrl,
+		ApiKeyFilePath:       opts.ApiKeyFilePath,
+		Market:               opts.Market,
+		Mode:                 opts.Mode,
+		production:           opts.Production,
+		indexPath:            opts.IndexPath,
+		apiKeys:              newApiKeyPool(apiKeys),
 	}
 
 	return c, nil
 }
 
+// Returns a copy of the options with the defaults in place of the empty fields.
+func (opts CollectorOptions) withDefaults() CollectorOptions {
+	defaults := []struct {
+		field *string
+		value string
+	}{
+		{&opts.DbFilePath, DefaultDbFilePath},
+		{&opts.ApiKeyFilePath, DefaultApiKeyFilePath},
+		{&opts.CurrencyListFilePath, DefaultCurrencyListFilePath},
+		{&opts.IndexPath, DefaultIndexPath},
+		{&opts.Market, DefaultMarket},
+		{&opts.Mode, DefaultMode},
+	}
+	for _, d := range defaults {
+		if *d.field == "" {
+			*d.field = d.value
+		}
+	}
+	if opts.ApiUrl == "" {
+		opts.ApiUrl = ApiUrlTemplate(opts.Mode, opts.Market)
+	}
+	return opts
+}
+
 // wrapper around the real function, needed for tests.
+// It depends on the store backend of the collector.
 func (c Collector) GetStoreDataFunc() StoreDataFunc {
-	return StoreData
+	switch c.StoreBackend {
+	case StoreBackendCSV, StoreBackendJSON:
+		return NewFileStoreDataFunc(c.StoreDir, c.StoreBackend)
+	}
+	if c.StoreBatchSize > 0 {
+		return func(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) error {
+			_, err := storeDataBatched(ctx, db, data, tableName, c.StoreBatchSize)
+			return err
+		}
+	}
+	return StoreDataContext
 }
 
 func (c Collector) getIndexPath() string {
 	return c.indexPath
 }
 
+// Returns how long a symbol is not requested again after being fetched.
+func (c Collector) getRefetchInterval() time.Duration {
+	return c.RefetchInterval
+}
+
+// Tells if the run goes on when the data of a symbol can't be stored.
+func (c Collector) continueOnDbError() bool {
+	return c.ContinueOnDbError
+}
+
+// Returns the number of errors that aborts a run, 0 when there's no limit.
+func (c Collector) maxErrors() int {
+	return c.MaxErrors
+}
+
+// Returns the date the latest value of a symbol must predate to request it, zero to request all of them.
+func (c Collector) staleBefore() time.Time {
+	return c.StaleBefore
+}
+
+// Returns the channel that asks to reload the currency list, nil when it can't be reloaded.
+func (c Collector) reloadSignal() <-chan struct{} {
+	return c.Reload
+}
+
+// Returns the callback reporting the progress of the run, nil when there's none.
+func (c Collector) progressFunc() func(symbol string, index, total int, status ApiStatus) {
+	return c.OnProgress
+}
+
+// Returns the database given in Db, nil when the run opens its own one.
+func (c Collector) sharedDb() *sql.DB {
+	return c.Db
+}
+
+// Tells if the symbols with a value for the current week are skipped.
+func (c Collector) skipComplete() bool {
+	return c.SkipComplete
+}
+
+// Tells if the responses identical to the last one stored are skipped.
+func (c Collector) skipUnchanged() bool {
+	return c.SkipUnchanged
+}
+
+// Returns the path of the lock file of the database, "<db>.lock", empty for the
+// in-memory databases, which can't be shared by several runs.
+func (c Collector) lockPath() string {
+	if c.Db != nil || c.StoreBackend == StoreBackendMemory || isMemoryDSN(c.DbFilePath) {
+		return ""
+	}
+	return c.DbFilePath + ".lock"
+}
+
+// Tells if the lock of the database is taken over even if another run holds it.
+func (c Collector) forceLock() bool {
+	return c.ForceLock
+}
+
+// Tells if the symbols with incomplete data are blacklisted.
+func (c Collector) strictComplete() bool {
+	return c.StrictComplete
+}
+
+// Returns how many symbols can be processed at the same time, at least 1.
+func (c Collector) getConcurrency() int {
+	if c.Concurrency < 1 {
+		return 1
+	}
+	return c.Concurrency
+}
+
 // wrapper around the real function, needed for tests.
+// It's the extractor registered for the mode and the asset class, see RegisterExtractor.
 func (c Collector) GetExtractDataFromValuesFunc() ExtractDataFromValuesFunc {
-	return ExtractDataFromValues
+	return c.extractor()
+}
+
+// Client used to request the API. Requests taking longer than the timeout fail.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// User-Agent sent to the API by default, as some providers block the one of Go.
+const DefaultUserAgent = "investrends-collector/1.0"
+
+// Ways to send the API key to the API.
+const (
+	AuthModeQuery  = "query"  // In the URL, through the placeholder of the template. The default.
+	AuthModeHeader = "header" // In an "Authorization: Bearer" header, out of the URL.
+)
+
+// Checks that the auth mode is one of the supported ones.
+func ValidateAuthMode(mode string) error {
+	switch mode {
+	case "", AuthModeQuery, AuthModeHeader:
+		return nil
+	}
+	return DataError{Msg: fmt.Sprintf("invalid auth mode %q, valid options are: %s, %s", mode, AuthModeQuery, AuthModeHeader)}
+}
+
+// Creates a client to request the API through a proxy, given as a URL with
+// the http, https or socks5 scheme (e.g. socks5://localhost:1080).
+func NewProxyClient(proxy string) (*http.Client, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, DataError{Msg: fmt.Sprintf("invalid proxy URL %q", proxy)}
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, DataError{Msg: fmt.Sprintf("unsupported proxy scheme %q, valid options are: http, https, socks5", proxyURL.Scheme)}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Timeout: httpClient.Timeout, Transport: transport}, nil
 }
 
 // Get data from a resource.
 // In this case, it gets the data from a HTTP server.
 func getData(resource string) ([]byte, error) {
+	return getDataWith(httpClient, http.Header{"User-Agent": {DefaultUserAgent}}, resource)
+}
+
+// Same as getData, using the given client and headers.
+func getDataWith(client *http.Client, header http.Header, resource string) ([]byte, error) {
 	var response []byte
-	resp, err := http.Get(resource)
+	req, err := http.NewRequest(http.MethodGet, resource, nil)
+	if err != nil {
+		return response, ConnectionError{Msg: "Failed to build the request to the API:" + err.Error()}
+	}
+	req.Header = header
+
+	resp, err := client.Do(req)
 	if err != nil {
-		return response, ConnectionError{Msg: "Failed to fetch data from API:" + err.Error()}
+		return response, ConnectionError{Msg: "Failed to fetch data from API:" + err.Error(), Kind: classifyConnectionError(err)}
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), now())
+		return response, ThrottledError{Msg: "The API throttled the request: " + resp.Status, RetryAfter: retryAfter}
+	}
 	return io.ReadAll(resp.Body)
 }
 
+// Longest wait honored from the Retry-After header of a throttled request.
+const MaxRetryAfter = 5 * time.Minute
+
+// Times a throttled request is sent again before counting it as failed.
+const throttleRetries = 3
+
+// Time to wait before requesting again a throttled request, given the one
+// of its Retry-After header.
+func throttleWait(retryAfter time.Duration) time.Duration {
+	switch {
+	case retryAfter == 0:
+		return time.Minute
+	case retryAfter > MaxRetryAfter:
+		return MaxRetryAfter
+	}
+	return retryAfter
+}
+
+// Parses the value of a Retry-After header, either a number of seconds or an
+// HTTP date, into the time to wait from t. Dates in the past wait 0.
+func parseRetryAfter(value string, t time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(t); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
 // Tries to get raw values from an API's response.
-func GetRawValuesFromResponse(response []byte) (CryptoDataRaw, int) {
+// The errors of the API are told apart by the top-level keys of the response,
+// so the same phrases inside the data don't count.
+func GetRawValuesFromResponse(response []byte) (CryptoDataRaw, ApiStatus) {
 	var cryptoData CryptoDataRaw
 
-	if strings.Contains(string(response), "Invalid API call.") {
-		return cryptoData, missingSymbol
+	if len(bytes.TrimSpace(response)) == 0 {
+		return cryptoData, StatusEmptyResponse
+	}
+
+	// Valid JSON that is not an object, like null, is not a response either.
+	var topLevel map[string]json.RawMessage
+	if err := json.Unmarshal(response, &topLevel); err != nil || topLevel == nil {
+		return cryptoData, StatusJsonBroken
 	}
 
-	if strings.Contains(string(response), "You have reached the 100 requests/day limit") {
-		return cryptoData, limitReached
+	if strings.Contains(topLevelString(topLevel, "Error Message"), "Invalid API call.") {
+		return cryptoData, StatusMissingSymbol
+	}
+
+	for _, key := range []string{"Information", "Note"} {
+		message := topLevelString(topLevel, key)
+		if strings.Contains(message, "You have reached the 100 requests/day limit") {
+			return cryptoData, StatusLimitReached
+		}
+		if isThrottleMessage(message) {
+			return cryptoData, StatusThrottled
+		}
+	}
+
+	// The word demo is in bold, e.g. "The **demo** API key is for demo purposes only".
+	if strings.Contains(strings.ReplaceAll(topLevelString(topLevel, "Information"), "*", ""), "demo API key") {
+		return cryptoData, StatusDemoKey
 	}
 
 	err := json.Unmarshal(response, &cryptoData)
 	if err != nil {
-		return cryptoData, jsonBroken
+		return cryptoData, StatusJsonBroken
+	}
+	// Without a time series there's no data to extract, the response is not one of prices.
+	if cryptoData.TimeSeries == nil {
+		return cryptoData, StatusJsonBroken
+	}
+
+	return cryptoData, StatusAllGood
+}
+
+// Tells if the message of a response is the one of the limit of requests per minute,
+// e.g. "Our standard API call frequency is 5 calls per minute and 100 calls per day".
+func isThrottleMessage(message string) bool {
+	for _, text := range []string{"call frequency", "per minute", "more sparingly"} {
+		if strings.Contains(message, text) {
+			return true
+		}
 	}
+	return false
+}
 
-	return cryptoData, allGood
+// Returns the string value of a top-level key of a response, empty when it's missing or not a string.
+func topLevelString(topLevel map[string]json.RawMessage, key string) string {
+	var value string
+	if raw, ok := topLevel[key]; ok {
+		json.Unmarshal(raw, &value)
+	}
+	return value
 }
 
 // Main function that runs functionality and returns error if something went wrong.
@@ -146,18 +642,151 @@ func GetRawValuesFromResponse(response []byte) (CryptoDataRaw, int) {
 //     This is for respect the API limit (5 requests per minute max).
 //   - Process the data, storing it in the database.
 //   - If the daily limit is reached (100 requests per day), it sleeps or finish, depends on configuration.
-func Run(c CollectorInterface, n int, clear bool) (int, error) {
+//
+// When the collector has a concurrency greater than 1, the symbols are processed by a
+// pool of workers instead, all of them sharing the same rate limit.
+func Run(c CollectorInterface, n int, clear bool) (RunResult, error) {
+	return RunContext(context.Background(), c, n, clear)
+}
+
+// Number of symbols requested per batch when RunOptions has no BatchSize,
+// the requests per minute allowed by the API.
+const DefaultBatchSize = 5
+
+// Options of RunWithOptions and RunGoRoutinesWithOptions, so new ones don't change
+// the signature of the functions.
+type RunOptions struct {
+	// Number of symbols requested before waiting for the rate limit. DefaultBatchSize when 0.
+	BatchSize int
+	// Empties the blacklist before starting.
+	ClearBlacklist bool
+	// Waits a minute between batches. Only used by RunGoRoutinesWithOptions.
+	Sleep bool
+}
+
+// Returns the batch size of the options, DefaultBatchSize when it's not set.
+func (opts RunOptions) batchSize() int {
+	if opts.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return opts.BatchSize
+}
+
+// Same as RunContext, configured by opts.
+func RunWithOptions(ctx context.Context, c CollectorInterface, opts RunOptions) (RunResult, error) {
+	return RunContext(ctx, c, opts.batchSize(), opts.ClearBlacklist)
+}
+
+// Runs the collection in passes until ctx is done, waiting interval between the end of
+// a pass and the start of the next one, instead of relying on cron. The summary of every
+// pass is logged, and a pass ending with an error doesn't stop the next ones.
+// The blacklist is only cleared before the first pass. It returns the number of passes
+// completed, and no error when it stops because ctx is done.
+func RunEvery(ctx context.Context, c CollectorInterface, interval time.Duration, opts RunOptions) (int, error) {
+	if interval <= 0 {
+		return 0, DataError{Msg: fmt.Sprintf("invalid interval %v, it must be positive", interval)}
+	}
+
+	for cycle := 1; ; cycle++ {
+		result, err := RunWithOptions(ctx, c, opts)
+		if ctx.Err() != nil {
+			slog.Info("The collection loop was stopped", "cycle", cycle)
+			return cycle - 1, nil
+		}
+		opts.ClearBlacklist = false
+
+		args := []interface{}{"cycle", cycle, "processed", result.Processed, "complete_ratio", result.CompleteRatio, "failed", len(result.Failed), "next", interval}
+		switch {
+		case err != nil:
+			slog.Error("The collection pass ended with an error", append(args, "err", err.Error())...)
+		case result.StopReason != nil:
+			slog.Warn("The collection pass stopped before the end", append(args, "reason", result.StopReason.Error())...)
+		default:
+			slog.Info("The collection pass finished", args...)
+		}
+
+		if err := sleepContext(ctx, interval); err != nil {
+			slog.Info("The collection loop was stopped", "cycle", cycle)
+			return cycle, nil
+		}
+	}
+}
+
+// Same as RunGoRoutines, configured by opts.
+func RunGoRoutinesWithOptions(c CollectorInterface, opts RunOptions) (int, error) {
+	return RunGoRoutines(c, opts.batchSize(), opts.ClearBlacklist, opts.Sleep)
+}
+
+// Summary of a run.
+type RunResult struct {
+	// Number of symbols requested to the API.
+	Processed int
+	// Symbols that could not be requested to the API, even after retrying them.
+	Failed []string
+	// Ratio of the processed symbols whose full HistoryDepth was stored.
+	CompleteRatio float64
+	// Why the run stopped before the end of the list without an error:
+	// ErrDailyLimitReached, or nil when it wasn't stopped.
+	StopReason error
+	// Number of times a symbol failed for a reason other than the daily limit, retries included.
+	Errors int
+	// Symbols whose data was stored, the Incomplete ones included.
+	Succeeded []string
+	// Symbols blacklisted during the run, because the API had no valid data for them.
+	Blacklisted []string
+	// Symbols whose data was stored with less values than HistoryDepth.
+	Incomplete []string
+	// Number of requests sent to the API, retries included.
+	Requests int
+	// How long the run took.
+	Duration time.Duration
+	// Symbols of Failed whose error is not worth retrying.
+	notRetryable map[string]bool
+	// Position in the currency list of the symbols processed, and the number of
+	// symbols in it, to report the progress of the retries.
+	positions map[string]int
+	total     int
+	// Number of processed symbols whose full HistoryDepth was stored.
+	complete int
+}
+
+// Same as Run, but the run stops once ctx is done, keeping the index of the
+// first symbol not processed so the next run resumes from there.
+// In that case the error returned is the one from the context.
+func RunContext(ctx context.Context, c CollectorInterface, n int, clear bool) (RunResult, error) {
+	start := time.Now()
+	summary, err := runContext(ctx, c, n, clear)
+	summary.Duration = time.Since(start)
+	if summary.Processed > 0 {
+		summary.CompleteRatio = float64(summary.complete) / float64(summary.Processed)
+	}
+	slog.Info("Run finished", "processed", summary.Processed, "failed", len(summary.Failed), "complete_ratio", summary.CompleteRatio)
+	return summary, err
+}
+
+// Does the work of RunContext.
+func runContext(ctx context.Context, c CollectorInterface, n int, clear bool) (RunResult, error) {
+	var summary RunResult
+
+	release, err := acquireLock(c.lockPath(), c.forceLock())
+	if err != nil {
+		return summary, err
+	}
+	defer release()
 
 	records, err := c.ReadCurrencyList()
 	if err != nil {
-		return 0, err
+		return summary, err
+	}
+	if c.currentApiKey() == DemoApiKey {
+		slog.Warn("Running with the demo API key, most of the symbols will fail: " + demoKeyHelp)
 	}
 
-	db, err := c.setUpDb("")
+	db, err := setUpRunDb(c)
 	if err != nil {
-		return 0, DbError{Msg: "Error setting up the database"}
+		return summary, err
 	}
-	defer db.Close()
+	defer closeDb(c, db)
 	if clear {
 		slog.Info("Clearing the blacklist table")
 		db.Exec("DELETE FROM blacklist")
@@ -170,170 +799,871 @@ func Run(c CollectorInterface, n int, clear bool) (int, error) {
 		index = 0
 	}
 
-	processed := 0
-	for i := index; i < len(records); i++ {
+	limiter := newRateLimiter(n, time.Minute)
+
+	var finished bool
+	if workers := c.getConcurrency(); workers > 1 {
+		finished, err = runPool(ctx, c, db, records, index, limiter, workers, &summary)
+	} else {
+		finished, err = runSequential(ctx, c, db, records, index, limiter, &summary)
+	}
+	if err != nil || finished {
+		return summary, err
+	}
+
+	// The symbols that failed to be requested get a second chance.
+	if len(summary.Failed) > 0 {
+		finished, err = retrySymbols(ctx, c, db, limiter, &summary)
+		if err != nil || finished {
+			return summary, err
+		}
+	}
+
+	// Once finished, restart the index.
+	err = writeIndexToFile(0, c.getIndexPath())
+	return summary, err
+}
+
+// Processes the records from index onwards, one after the other.
+// It returns true when the run finished before the end of the list.
+func runSequential(ctx context.Context, c CollectorInterface, db *sql.DB, records [][]string, index int, limiter *rateLimiter, summary *RunResult) (bool, error) {
+	for i := index; i < len(records); i++ {
+		records = reloadRecords(c, records)
+
+		err := writeIndexToFile(i, c.getIndexPath())
+		if err != nil {
+			slog.Error("Failed to write index to file: ", "err", err.Error())
+			return true, err
+		}
+
+		if ctx.Err() != nil {
+			slog.Info("The run was stopped", "index", i, "reason", ctx.Err().Error())
+			return true, ctx.Err()
+		}
+
+		if i == 0 {
+			// First row is a header, not useful
+			continue
+		}
+
+		symbol := string(records[i][0])
+
+		if skipSymbol(ctx, c, db, symbol) {
+			continue
+		}
+
+		// Pause every n requests to comply with rate limit
+		if err := limiter.wait(ctx); err != nil {
+			slog.Info("The run was stopped", "index", i, "reason", err.Error())
+			return true, err
+		}
+
+		slog.Info(symbol + " is processing")
+		summary.Processed++
+		result := fetchSymbol(ctx, c, db, symbol)
+		finished, err := storeSymbolResult(ctx, c, db, result, summary)
+		reportProgress(c, result, i, len(records)-1, summary)
+		if err != nil || finished {
+			return true, err
+		}
+	}
+
+	return false, nil
+}
+
+// Calls the progress callback of the collector, if any, with the outcome of a symbol,
+// keeping its position in the summary for the retries.
+func reportProgress(c CollectorInterface, result symbolResult, index, total int, summary *RunResult) {
+	if summary.positions == nil {
+		summary.positions = make(map[string]int)
+	}
+	summary.positions[result.symbol] = index
+	summary.total = total
+	if onProgress := c.progressFunc(); onProgress != nil {
+		onProgress(result.symbol, index, total, result.status)
+	}
+}
+
+// Reads the currency list again if it was asked to, appending the new symbols to
+// the records. The records are returned as they are otherwise, or if it fails.
+func reloadRecords(c CollectorInterface, records [][]string) [][]string {
+	select {
+	case <-c.reloadSignal():
+	default:
+		return records
+	}
+
+	reloaded, err := c.ReadCurrencyList()
+	if err != nil {
+		slog.Error("Unable to reload the currency list", "err", err.Error())
+		return records
+	}
+
+	known := make(map[string]bool, len(records))
+	for _, record := range records {
+		known[record[0]] = true
+	}
+	added := 0
+	for _, record := range reloaded[1:] {
+		if !known[record[0]] {
+			known[record[0]] = true
+			records = append(records, record)
+			added++
+		}
+	}
+	slog.Info("Reloaded the currency list", "new_symbols", added)
+	return records
+}
+
+// Requests once more the symbols that failed during the run, respecting the rate
+// limit. The ones failing again, or whose error is not retryable, remain in the summary.
+// It returns true when the run has to finish before retrying all of them.
+func retrySymbols(ctx context.Context, c CollectorInterface, db *sql.DB, limiter *rateLimiter, summary *RunResult) (bool, error) {
+	failed := summary.Failed
+	summary.Failed = nil
+	slog.Info("Retrying the symbols that failed", "count", len(failed))
+
+	for i, symbol := range failed {
+		if summary.notRetryable[symbol] {
+			slog.Info(symbol + " failed with an error not worth retrying")
+			summary.Failed = append(summary.Failed, symbol)
+			continue
+		}
+
+		if err := limiter.wait(ctx); err != nil {
+			summary.Failed = append(summary.Failed, failed[i:]...)
+			return true, err
+		}
+
+		slog.Info(symbol + " is being retried")
+		result := fetchSymbol(ctx, c, db, symbol)
+		finished, err := storeSymbolResult(ctx, c, db, result, summary)
+		reportProgress(c, result, summary.positions[symbol], summary.total, summary)
+		if err != nil || finished {
+			summary.Failed = append(summary.Failed, failed[i+1:]...)
+			return true, err
+		}
+	}
+
+	if len(summary.Failed) > 0 {
+		slog.Warn("Some symbols could not be requested", "symbols", summary.Failed)
+	}
+	return false, nil
+}
+
+// Limits the amount of requests done to the API: after every n requests it
+// waits for the given window. It is safe to use from several goroutines.
+type rateLimiter struct {
+	mu       sync.Mutex
+	n        int
+	window   time.Duration
+	requests int
+}
+
+// Creates a rate limiter allowing n requests per window.
+func newRateLimiter(n int, window time.Duration) *rateLimiter {
+	return &rateLimiter{n: n, window: window}
+}
+
+// Blocks until a new request can be done, or ctx is done. While one caller is
+// sleeping, the rest of them wait as well.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.requests > 0 && r.requests%r.n == 0 {
+		slog.Info("Sleeping a minute", "processed", r.requests)
+		if err := sleepContext(ctx, r.window); err != nil {
+			return err
+		}
+	}
+	r.requests++
+	return nil
+}
+
+// Sleeps for the given duration, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Tells if a symbol must not be requested in this run, because it's blacklisted,
+// it was fetched recently or its data is up to date.
+func skipSymbol(ctx context.Context, c CollectorInterface, db *sql.DB, symbol string) bool {
+	if IsBlacklistedContext(ctx, db, symbol, "") {
+		slog.Debug(symbol + " is blacklisted. Skipping...")
+		return true
+	}
+
+	if interval := c.getRefetchInterval(); interval > 0 && FetchedWithin(db, symbol, interval) {
+		slog.Debug(symbol+" was fetched recently. Skipping...", "interval", interval)
+		return true
+	}
+
+	if c.skipComplete() && HasCurrentWeek(db, symbol) {
+		slog.Debug(symbol + " already has the value of this week. Skipping...")
+		return true
+	}
+
+	if date := c.staleBefore(); !date.IsZero() && HasDataSince(db, symbol, date) {
+		slog.Debug(symbol+" is not stale. Skipping...", "staleBefore", date.Format("2006-01-02"))
+		return true
+	}
+
+	return false
+}
+
+// Returns the current time, replaced in the tests.
+var now = time.Now
+
+// Tells if the latest value stored for the symbol is the one of the current
+// week, which starts on the last Sunday (or today, if it's Sunday).
+func HasCurrentWeek(db *sql.DB, symbol string) bool {
+	today := now()
+	return HasDataSince(db, symbol, today.AddDate(0, 0, -int(today.Weekday())))
+}
+
+// Tells if the latest value stored for the symbol is at or after the date.
+// Symbols without values don't have it, and the NULL values of the missing
+// weeks (see Collector.StoreNullForMissing) don't count.
+func HasDataSince(db *sql.DB, symbol string, date time.Time) bool {
+	var latest sql.NullString
+	err := db.QueryRow("SELECT MAX(timestamp) FROM crypto_prices WHERE symbol = ? AND value IS NOT NULL", symbol).Scan(&latest)
+	if err != nil || !latest.Valid {
+		return false
+	}
+
+	return latest.String >= date.Format("2006-01-02")
+}
+
+// Outcome of requesting and extracting the data of a single symbol.
+type symbolResult struct {
+	symbol      string
+	curatedData []CryptoDataCurated
+	extracted   int
+	status      ApiStatus
+	// Error requesting the data to the API.
+	fetchErr error
+	// Error extracting the values from the response.
+	extractErr error
+	// API key used for the request.
+	apiKey string
+	// When the API refreshed the data, from the metadata of the response.
+	lastRefreshed string
+	// Hash of the body of the response, for the valid ones.
+	contentHash string
+	// The response is identical to the last one stored, nothing was extracted.
+	unchanged bool
+}
+
+// Requests the data of a symbol to the API and extracts the curated values from it.
+// When the API throttles the request, it waits as long as the Retry-After header
+// tells (up to MaxRetryAfter, a minute if it doesn't) and requests it again.
+// With skipUnchanged, a response identical to the last one stored in db is not extracted.
+func fetchSymbol(ctx context.Context, c CollectorInterface, db *sql.DB, symbol string) symbolResult {
+	result := symbolResult{symbol: symbol, apiKey: c.currentApiKey()}
+
+	url := c.GetURLFromSymbol(symbol)
+	response, err := c.GetGetDataFunc()(url)
+	var throttled ThrottledError
+	for attempt := 0; attempt < throttleRetries && errors.As(err, &throttled); attempt++ {
+		wait := throttleWait(throttled.RetryAfter)
+		slog.Warn(symbol+" was throttled by the API, waiting", "wait", wait)
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			break
+		}
+		response, err = c.GetGetDataFunc()(url)
+	}
+	if err != nil {
+		slog.Error("There was an error trying to get a response", "url", url)
+		result.fetchErr = err
+		result.status = StatusRequestFailed
+		return result
+	}
+
+	raw, status := GetRawValuesFromResponse(response)
+	result.status = status
+	if status != StatusAllGood {
+		return result
+	}
+
+	result.lastRefreshed = raw.MetaData.LastRefreshed
+	result.contentHash = contentHash(response)
+	if c.skipUnchanged() && LastContentHash(db, symbol) == result.contentHash {
+		result.unchanged = true
+		return result
+	}
+	result.curatedData, result.extracted, result.extractErr = c.GetExtractDataFromValuesFunc()(raw, HistoryDepth, symbol)
+	return result
+}
+
+// Counts an error of the run, recording the failure of the symbol with its reason.
+// It returns ErrTooManyErrors once the run has as many errors as the collector allows.
+func countRunError(c CollectorInterface, db *sql.DB, symbol string, reason string, summary *RunResult) error {
+	if err := RecordFailure(db, symbol, reason, time.Now()); err != nil {
+		slog.Error("unable to record the failure", "symbol", symbol, "err", err.Error())
+	}
+	summary.Errors++
+	if limit := c.maxErrors(); limit > 0 && summary.Errors >= limit {
+		slog.Error("Too many errors, aborting the run", "errors", summary.Errors)
+		return fmt.Errorf("%w: %d symbols failed", ErrTooManyErrors, summary.Errors)
+	}
+	return nil
+}
+
+// Records that the symbol was fetched now, for the refetch interval. Only the symbols
+// whose data was stored, or that the API doesn't have, count as fetched.
+func recordFetch(db *sql.DB, symbol string) {
+	if err := RecordFetch(db, symbol, time.Now()); err != nil {
+		slog.Error("unable to record the fetch", "symbol", symbol, "err", err.Error())
+	}
+}
+
+// Acts on the result of fetchSymbol: blacklists invalid symbols, stores the data
+// and keeps the summary of the run up to date.
+// It returns true when the run has to finish, along with the error that caused it (if any).
+func storeSymbolResult(ctx context.Context, c CollectorInterface, db *sql.DB, result symbolResult, summary *RunResult) (bool, error) {
+	symbol := result.symbol
+	summary.Requests++
+	if result.fetchErr != nil {
+		slog.Warn(symbol+" could not be requested", "err", result.fetchErr.Error())
+		summary.Failed = append(summary.Failed, symbol)
+		var connErr ConnectionError
+		if errors.As(result.fetchErr, &connErr) && !connErr.Retryable() {
+			if summary.notRetryable == nil {
+				summary.notRetryable = make(map[string]bool)
+			}
+			summary.notRetryable[symbol] = true
+		}
+		if err := countRunError(c, db, symbol, result.fetchErr.Error(), summary); err != nil {
+			return true, err
+		}
+		return false, nil
+	}
+
+	switch result.status {
+	case StatusAllGood:
+	case StatusMissingSymbol:
+		// The data is unreadable, but the loop can continue.
+		// Somehow the API returns Data error for certain symbols.
+		slog.Warn(symbol + "'s data was not valid. Blacklisting it...")
+		AddToBlacklist(db, symbol, "")
+		recordFetch(db, symbol)
+		summary.Blacklisted = append(summary.Blacklisted, symbol)
+		return false, nil
+	case StatusLimitReached:
+		slog.Info("Reached the limit for today.")
+		if c.exhaustApiKey(result.apiKey) {
+			// The symbol is requested again with the next key at the end of the run.
+			slog.Info("Switching to the next API key")
+			summary.Failed = append(summary.Failed, symbol)
+			return false, nil
+		}
+		if c.isProduction() {
+			slog.Info("We will continue in 24 hours")
+			if err := sleepContext(ctx, 24*time.Hour); err != nil {
+				return true, err
+			}
+			return false, nil
+		}
+		slog.Info("Finishing...")
+		summary.StopReason = ErrDailyLimitReached
+		return true, nil
+	case StatusDemoKey:
+		// Requesting it again won't help, but the symbol is not blacklisted
+		// as it works with a real API key.
+		slog.Warn(symbol + " is not available with the demo API key, " + demoKeyHelp)
+		if err := countRunError(c, db, symbol, "not available with the demo API key", summary); err != nil {
+			return true, err
+		}
+		return false, nil
+	case StatusEmptyResponse:
+		// The symbol is requested again at the end of the run.
+		slog.Warn(symbol + " returned an empty response")
+		summary.Failed = append(summary.Failed, symbol)
+		if err := countRunError(c, db, symbol, "empty response", summary); err != nil {
+			return true, err
+		}
+		return false, nil
+	default:
+		slog.Error("Failed to fetch data from API", "symbol", symbol, "status", result.status)
+		if err := countRunError(c, db, symbol, fmt.Sprintf("unexpected status %d", result.status), summary); err != nil {
+			return true, err
+		}
+		return false, nil
+	}
+
+	if result.unchanged {
+		slog.Info(symbol + " has not changed since the last time it was stored, skipping it")
+		recordFetch(db, symbol)
+		return false, nil
+	}
+	if result.extractErr != nil {
+		slog.Warn("Unable to extract data from raw response", "err", result.extractErr.Error())
+		if err := countRunError(c, db, symbol, result.extractErr.Error(), summary); err != nil {
+			return true, err
+		}
+		return false, nil
+	}
+	if result.extracted != HistoryDepth {
+		slog.Warn(symbol+" Response was incomplete", "extracted", result.extracted)
+		if c.strictComplete() {
+			slog.Warn(symbol + " is blacklisted for being incomplete")
+			if err := BlacklistWithReason(db, symbol, "incomplete"); err != nil {
+				slog.Error("unable to blacklist the symbol", "symbol", symbol, "err", err.Error())
+			}
+			summary.Blacklisted = append(summary.Blacklisted, symbol)
+			return false, nil
+		}
+	}
+	if err := RecordQuality(db, symbol, HistoryDepth, result.extracted); err != nil {
+		slog.Error("unable to record the quality of the data", "symbol", symbol, "err", err.Error())
+	}
+	if err := RecordLastRefreshed(db, symbol, result.lastRefreshed); err != nil {
+		slog.Error("unable to record the last refreshed time", "symbol", symbol, "err", err.Error())
+	}
+
+	err := c.GetStoreDataFunc()(ctx, db, result.curatedData, "crypto_prices")
+	if err != nil {
+		slog.Error("unable to store data in the database: ", "err", err.Error())
+		if c.continueOnDbError() {
+			return false, nil
+		}
+		// The rest of the symbols would likely fail too, so the run stops here.
+		return true, err
+	}
+	recordFetch(db, symbol)
+	if err := RecordContentHash(db, symbol, result.contentHash); err != nil {
+		slog.Error("unable to record the content hash", "symbol", symbol, "err", err.Error())
+	}
+	summary.Succeeded = append(summary.Succeeded, symbol)
+	if result.extracted == HistoryDepth {
+		summary.complete++
+	} else {
+		summary.Incomplete = append(summary.Incomplete, symbol)
+	}
+
+	slog.Info(symbol + " DONE.")
+	return false, nil
+}
+
+// Position in the currency list of the first symbol whose result is not stored yet,
+// the one the next run resumes from, while the workers process the symbols out of
+// order. It is safe to use from several goroutines.
+type poolProgress struct {
+	mu sync.Mutex
+	// Position of the symbol being handed to the workers.
+	next int
+	// Positions of the symbols handed to the workers and not done yet.
+	pending map[int]bool
+}
+
+// Creates the progress of a run starting from index.
+func newPoolProgress(index int) *poolProgress {
+	return &poolProgress{next: index, pending: make(map[int]bool)}
+}
+
+// Records that the symbol in position i is the next one to hand to the workers.
+func (p *poolProgress) at(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next = i
+}
+
+// Records that the symbol in position i was handed to the workers.
+func (p *poolProgress) handed(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[i] = true
+}
+
+// Records that the result of the symbol in position i was stored, or that it's done otherwise.
+func (p *poolProgress) done(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, i)
+}
 
-		err = writeIndexToFile(i, c.getIndexPath())
-		if err != nil {
-			slog.Error("Failed to write index to file: ", "err", err.Error())
-			return processed, err
+// Returns the position of the first symbol not done yet.
+func (p *poolProgress) index() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	index := p.next
+	for i := range p.pending {
+		if i < index {
+			index = i
 		}
+	}
+	return index
+}
 
-		if i == 0 {
-			// First row is a header, not useful
-			continue
-		}
+// Processes the records from index onwards with a pool of workers. The symbols
+// are handed to the workers at the pace of the rate limiter, the workers request
+// the data to the API and the results are stored in the database from the
+// calling goroutine.
+// It returns true when the run finished before the end of the list.
+func runPool(ctx context.Context, c CollectorInterface, db *sql.DB, records [][]string, index int, limiter *rateLimiter, workers int, summary *RunResult) (bool, error) {
+	type job struct {
+		i      int
+		total  int
+		symbol string
+	}
+	type jobResult struct {
+		symbolResult
+		i, total int
+	}
 
-		symbol := string(records[i][0])
+	jobs := make(chan job)
+	results := make(chan jobResult)
+	// Stops feeding the workers, even while waiting for the rate limiter.
+	feedCtx, stop := context.WithCancel(ctx)
+	defer stop()
 
-		if IsBlacklisted(db, symbol, "") {
-			slog.Debug(symbol + " is blacklisted. Skipping...")
-			continue
-		}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				slog.Info(j.symbol + " is processing")
+				results <- jobResult{fetchSymbol(ctx, c, db, j.symbol), j.i, j.total}
+			}
+		}()
+	}
+
+	// Feed the workers with the symbols. The index is written as the results are
+	// stored, as the symbols handed to the workers are not done yet.
+	progress := newPoolProgress(index)
+	var ctxErr error
+	go func() {
+		defer close(jobs)
+		for i := index; i < len(records); i++ {
+			records = reloadRecords(c, records)
+			progress.at(i)
+
+			if i == 0 {
+				// First row is a header, not useful
+				continue
+			}
+
+			symbol := string(records[i][0])
+			if skipSymbol(ctx, c, db, symbol) {
+				continue
+			}
 
-		if processed > 0 && processed%n == 0 {
 			// Pause every n requests to comply with rate limit
-			slog.Info("Sleeping a minute", "processed", processed)
-			time.Sleep(time.Minute)
-		}
+			if err := limiter.wait(feedCtx); err != nil {
+				if ctx.Err() != nil {
+					slog.Info("The run was stopped", "index", i, "reason", err.Error())
+					ctxErr = ctx.Err()
+				}
+				return
+			}
 
-		slog.Info(symbol + " is processing")
-		processed++
-		url := c.GetURLFromSymbol(symbol)
-		response, err := c.GetGetDataFunc()(url)
-		if err != nil {
-			slog.Error("There was an error trying to get a response", "url", url)
-			return processed, err
-		}
-		raw, status := GetRawValuesFromResponse(response)
-		if status != allGood {
-			switch status {
-			case missingSymbol:
-				// The data is unreadable, but the loop can continue.
-				// Somehow the API returns Data error for certain symbols.
-				slog.Warn(symbol + "'s data was not valid. Blacklisting it...")
-				AddToBlacklist(db, symbol, "")
-			case limitReached:
-				slog.Info("Reached the limit for today.")
-				if c.isProduction() {
-					slog.Info("We will continue in 24 hours")
-					time.Sleep(24 * time.Hour)
-				} else {
-					slog.Info("Finishing...")
-					return processed, nil
+			// Before handing it, as its result may be stored right away.
+			progress.handed(i)
+			select {
+			case jobs <- job{i: i, total: len(records) - 1, symbol: symbol}:
+			case <-feedCtx.Done():
+				if ctx.Err() != nil {
+					slog.Info("The run was stopped", "index", i, "reason", ctx.Err().Error())
+					ctxErr = ctx.Err()
 				}
-			default:
-				slog.Error("Failed to fetch data from API", "err", err.Error())
+				return
 			}
-			continue
 		}
-
-		curatedData, extracted, err := c.GetExtractDataFromValuesFunc()(raw, 25, symbol)
-		if err != nil {
-			slog.Warn("Unable to extract data from raw response", "err", err.Error())
+		progress.at(len(records))
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	finished := false
+	var runErr error
+	for result := range results {
+		summary.Processed++
+		if finished {
+			// Drain the results of the workers still running. They are not stored,
+			// so the next run requests them again.
 			continue
 		}
-		if extracted != 25 {
-			slog.Warn(symbol+" Response was incomplete", "extracted", extracted)
+		finished, runErr = storeSymbolResult(ctx, c, db, result.symbolResult, summary)
+		reportProgress(c, result.symbolResult, result.i, result.total, summary)
+		if !finished {
+			// The symbol that finished the run, e.g. reaching the daily limit, is the
+			// first one of the next run.
+			progress.done(result.i)
+			if err := writeIndexToFile(progress.index(), c.getIndexPath()); err != nil {
+				slog.Error("Failed to write index to file: ", "err", err.Error())
+				finished, runErr = true, err
+			}
 		}
-
-		err = c.GetStoreDataFunc()(db, curatedData, "crypto_prices")
-		if err != nil {
-			slog.Error("unable to store data in the database: ", "err", err.Error())
-			continue
+		if finished {
+			stop()
 		}
-
-		slog.Info(symbol + " DONE.")
 	}
 
-	// Once finished, restart the index.
-	err = writeIndexToFile(0, c.getIndexPath())
-	return processed, err
+	if err := writeIndexToFile(progress.index(), c.getIndexPath()); err != nil && runErr == nil {
+		slog.Error("Failed to write index to file: ", "err", err.Error())
+		return true, err
+	}
+	if runErr == nil && ctxErr != nil {
+		return true, ctxErr
+	}
+	return finished, runErr
 }
 
 // Returns the URL replacing the symbol in the placeholders.
+// The template has a placeholder for the symbol followed by another for the key,
+// except with AuthModeHeader, where the key is never part of the URL.
 func (c Collector) GetURLFromSymbol(symbol string) string {
-	return fmt.Sprintf(c.ApiUrl, symbol, c.ApiKey)
+	if c.PrintURL {
+		slog.Info("Requesting "+symbol, "url", c.redactedURLFromSymbol(symbol))
+	}
+	if c.AuthMode == AuthModeHeader {
+		return fmt.Sprintf(c.ApiUrl, symbol)
+	}
+	return fmt.Sprintf(c.ApiUrl, symbol, c.currentApiKey())
+}
+
+// Same as GetURLFromSymbol, with the API key replaced by "***" so it can be logged.
+func (c Collector) redactedURLFromSymbol(symbol string) string {
+	if c.AuthMode == AuthModeHeader {
+		return fmt.Sprintf(c.ApiUrl, symbol)
+	}
+	return fmt.Sprintf(c.ApiUrl, symbol, "***")
 }
 
 // Gets the API key, from a file in filePath
+// When the file has several keys, the first one is returned.
 func getApiKey(filePath string) (string, error) {
-	var apiKey string
-	data, err := os.ReadFile(filePath)
+	keys, err := LoadApiKeys(filePath)
+	if err != nil {
+		return "", err
+	}
+	return keys[0], nil
+}
+
+// Reads the list of currencies from the file in CurrencyListFilePath, followed
+// by the ones in ExtraCurrencyListFilePaths. Only the header of the first file is
+// kept, and a symbol repeated across files only appears the first time.
+// Only the column of the symbol (SymbolColumn) is used, so rows can have any number
+// of columns as long as that one is not empty. It's moved to the first column of the
+// rows returned. A list without symbols is an error.
+func (c Collector) ReadCurrencyList() ([][]string, error) {
+	records, err := readCurrencyListFile(c.CurrencyListFilePath, c.SymbolColumn)
 	if err != nil {
-		return apiKey, FileSystemError{Msg: "Error reading the apiKey file. Is it missing?"}
+		return records, err
+	}
+
+	if len(c.ExtraCurrencyListFilePaths) > 0 {
+		seen := make(map[string]bool, len(records))
+		for _, row := range records[min(1, len(records)):] {
+			seen[row[0]] = true
+		}
+		for _, path := range c.ExtraCurrencyListFilePaths {
+			extra, err := readCurrencyListFile(path, c.SymbolColumn)
+			if err != nil {
+				return records, err
+			}
+			for _, row := range extra[min(1, len(extra)):] {
+				if !seen[row[0]] {
+					seen[row[0]] = true
+					records = append(records, row)
+				}
+			}
+		}
 	}
-	apiKey = string(data)
-	t := len(apiKey)
-	if t != 16 {
-		return apiKey, DataError{Msg: "The apiKey does not have the proper format."}
+
+	// The first row is the header, a list without more rows is a misconfiguration.
+	if len(records) < 2 {
+		return records, DataError{Msg: "The currency list file has no symbols"}
 	}
 
-	return apiKey, nil
+	return records, nil
 }
 
-// Reads the list of currencies from a file in filePath.
-func (c Collector) ReadCurrencyList() ([][]string, error) {
+// Reads the rows of a currency list file, header included, with the symbol of the
+// given column moved to the first one.
+func readCurrencyListFile(path string, column int) ([][]string, error) {
 	var records [][]string
+	if column < 0 {
+		return records, DataError{Msg: fmt.Sprintf("Invalid symbol column %d, the first one is 0", column)}
+	}
 
 	// Read CSV file
-	file, err := os.Open(c.CurrencyListFilePath)
+	file, err := os.Open(path)
 	if err != nil {
 		return records, FileSystemError{Msg: "Error while reading the currency list file"}
 	}
 	defer file.Close()
 
 	csvReader := csv.NewReader(file)
+	// Alpha Vantage's list occasionally adds columns, allow rows of any length.
+	csvReader.FieldsPerRecord = -1
 	records, err = csvReader.ReadAll()
 	if err != nil {
 		return records, DataError{Msg: "Error while processing the currency list file"}
 	}
 
+	for i, row := range records {
+		if len(row) <= column {
+			return records, DataError{Msg: fmt.Sprintf("The row %d of the currency list file %s has no column %d for the symbol, only %d columns", i+1, path, column, len(row))}
+		}
+		if strings.TrimSpace(row[column]) == "" {
+			return records, DataError{Msg: fmt.Sprintf("The row %d of the currency list file %s has no symbol", i+1, path)}
+		}
+		if column > 0 {
+			records[i] = append([]string{row[column]}, append(row[:column:column], row[column+1:]...)...)
+		}
+	}
+
 	return records, nil
 }
 
 // Set's up database, creating the table if not done before.
+// Without a statement, the schema is brought up to date with Migrate.
 func (c Collector) setUpDb(sqlStmt string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", c.DbFilePath)
+	if c.Db != nil {
+		return c.Db, prepareDb(c.Db, sqlStmt)
+	}
+	if c.StoreBackend == StoreBackendMemory {
+		return nil, DataError{Msg: "The memory store backend needs a database from OpenMemoryDb"}
+	}
+
+	db, err := OpenDb(c.DbFilePath)
+	if err != nil {
+		return db, err
+	}
+
+	return db, prepareDb(db, sqlStmt)
+}
+
+// Opens the SQLite database in path, checking first that the path can be used as
+// a database file, see checkDbFilePath.
+func OpenDb(path string) (*sql.DB, error) {
+	// sql.Open is lazy, so a wrong path would only fail later with a confusing error.
+	if err := checkDbFilePath(path); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return db, FileSystemError{Msg: "Error reading the database file. Is it missing?"}
 	}
+	// Every connection to an in-memory database gets its own one, so a single connection is kept.
+	if isMemoryDSN(path) {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// Sets up the database of a run. The errors of the path of the database are returned
+// as they are, so they tell what's wrong with it, and the rest of them as a DbError.
+func setUpRunDb(c CollectorInterface) (*sql.DB, error) {
+	db, err := c.setUpDb("")
+	if err != nil {
+		var fsErr FileSystemError
+		if errors.As(err, &fsErr) {
+			return nil, err
+		}
+		return nil, DbError{Msg: "Error setting up the database"}
+	}
+	return db, nil
+}
 
+// Creates the tables of the database with the statement, or brings the schema up to
+// date with Migrate without one.
+func prepareDb(db *sql.DB, sqlStmt string) error {
 	if sqlStmt == "" {
-		sqlStmt = `
-		CREATE TABLE IF NOT EXISTS crypto_prices (
-			id INTEGER PRIMARY KEY,
-    		symbol TEXT,
-    		timestamp TEXT,
-    		value REAL,
-    		UNIQUE(symbol, timestamp)
-		);
-		CREATE TABLE IF NOT EXISTS blacklist (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			symbol VARCHAR(255) UNIQUE NOT NULL
-		);
-		`
-	}
-
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return db, DbError{Msg: "Failed to create tables: " + err.Error()}
+		_, err := Migrate(db)
+		return err
+	}
+
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		return DbError{Msg: "Failed to create tables: " + err.Error()}
 		// log.Fatalf("Failed to create table: %v", err)
 	}
 
-	return db, nil
+	return nil
+}
+
+// Closes the database of a run, unless it's the one given in Collector.Db.
+func closeDb(c CollectorInterface, db *sql.DB) {
+	if db != c.sharedDb() {
+		db.Close()
+	}
+}
+
+// Tells if the DSN is the one of an in-memory database, e.g. ":memory:" or ":memory:?_busy_timeout=0".
+func isMemoryDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")
+}
+
+// Checks that the database path can be used as a SQLite file: it must not be a
+// directory, and it must be writable if it already exists.
+func checkDbFilePath(path string) error {
+	// In-memory databases and URIs are left to the driver.
+	if path == "" || isMemoryDSN(path) || strings.HasPrefix(path, "file:") {
+		return nil
+	}
+	// The parameters of the driver, if any, are not part of the path.
+	path, _, _ = strings.Cut(path, "?")
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return FileSystemError{Msg: fmt.Sprintf("Unable to access the database file %s: %s", path, err.Error())}
+	}
+	if info.IsDir() {
+		return FileSystemError{Msg: fmt.Sprintf("The database path %s is a directory, it must be a file (e.g. %s)", path, filepath.Join(path, "crypto.sqlite"))}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return FileSystemError{Msg: fmt.Sprintf("The database file %s is not writable: %s", path, err.Error())}
+	}
+	return file.Close()
+}
+
+// Range of dates to extract from the raw data, both of them included.
+// A zero End means the last refreshed date, and a zero Start the n weeks before End.
+type ExtractWindow struct {
+	Start time.Time
+	End   time.Time
 }
 
 // This function retrieve the useful data from the raw data.
-func ExtractDataFromValues(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDataCurated, int, error) {
+// If more than maxMissingRatio of the n values requested are missing, the data is
+// considered low quality and a DataError is returned. A ratio of 0 disables the check.
+func ExtractDataFromValues(cdr CryptoDataRaw, n int, symbol string, maxMissingRatio float64, nullForMissing bool) ([]CryptoDataCurated, int, error) {
+	return ExtractDataInWindow(cdr, n, symbol, ExtractWindow{}, maxMissingRatio, nullForMissing)
+}
+
+// Same as ExtractDataFromValues, but only the weeks within the window are extracted,
+// e.g. to backfill a historical range. n is only used when the window has no Start.
+func ExtractDataInWindow(cdr CryptoDataRaw, n int, symbol string, window ExtractWindow, maxMissingRatio float64, nullForMissing bool) ([]CryptoDataCurated, int, error) {
 	var curatedData []CryptoDataCurated
 
 	// Retrieve which is the last value generated. It's stored
 	// in the metadata section of cdr.
 	lastRaw := cdr.MetaData.LastRefreshed
 
-	date, _, ok := strings.Cut(lastRaw, " ")
-	if !ok {
+	// Usually "date time", but some responses give only the date.
+	date, _, _ := strings.Cut(lastRaw, " ")
+	if date == "" {
 		return curatedData, 0, errors.New("unable to get last refreshed date from raw data")
 	}
 	const layout = "2006-01-02"
@@ -346,13 +1676,31 @@ func ExtractDataFromValues(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDat
 	// Substracts the number of days until last sunday to start from there.
 	t = t.AddDate(0, 0, -int(t.Weekday()))
 
+	// The window moves the first week back, and fixes how many weeks there are.
+	if !window.End.IsZero() && window.End.Before(t) {
+		t = window.End.AddDate(0, 0, -int(window.End.Weekday()))
+	}
+	if !window.Start.IsZero() {
+		n = 0
+		if !t.Before(window.Start) {
+			n = int(t.Sub(window.Start).Hours()/(24*7)) + 1
+		}
+	}
+	if n <= 0 {
+		return curatedData, 0, nil
+	}
+
 	i := 1
 	missing := 0
 	for i <= n {
 		value, ok := cdr.TimeSeries[t.Format(layout)]
 		if !ok {
+			if nullForMissing {
+				curatedData = append(curatedData, CryptoDataCurated{symbol: symbol, date: t.Format(layout), missing: true})
+			}
 			missing++
 			i++
+			t = t.AddDate(0, 0, -7)
 			continue
 		}
 
@@ -360,7 +1708,10 @@ func ExtractDataFromValues(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDat
 		var curatedValue CryptoDataCurated
 		curatedValue.value, err = strconv.ParseFloat(value.Close, 64)
 		if err != nil {
-			return curatedData, n - missing, errors.New("unable to get the float value from the string")
+			return curatedData, n - missing, DataError{
+				Msg: fmt.Sprintf("unable to get the float value of %s on %s from the string %q", symbol, t.Format(layout), value.Close),
+				Err: err,
+			}
 		}
 		curatedValue.date = t.Format(layout)
 		curatedValue.symbol = symbol
@@ -370,44 +1721,270 @@ func ExtractDataFromValues(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDat
 		t = t.AddDate(0, 0, -7)
 	}
 
+	if maxMissingRatio > 0 && float64(missing)/float64(n) > maxMissingRatio {
+		return curatedData, n - missing, DataError{Msg: fmt.Sprintf("%s has low quality data: %d of %d values are missing", symbol, missing, n)}
+	}
+
 	return curatedData, n - missing, nil
 }
 
+// Returns the data without duplicated (symbol, date) pairs, keeping the last value
+// of each pair in the position of the first one.
+func dedupeCuratedData(data []CryptoDataCurated) []CryptoDataCurated {
+	type key struct{ symbol, date string }
+	positions := make(map[key]int, len(data))
+	deduped := make([]CryptoDataCurated, 0, len(data))
+	for _, curated := range data {
+		k := key{curated.symbol, curated.date}
+		if i, ok := positions[k]; ok {
+			deduped[i] = curated
+			continue
+		}
+		positions[k] = len(deduped)
+		deduped = append(deduped, curated)
+	}
+	return deduped
+}
+
+// Number of attempts StoreData does while the database is locked, and how long
+// it waits before the first retry. The wait is doubled after every attempt.
+var (
+	storeAttempts   = 5
+	storeRetryDelay = 100 * time.Millisecond
+)
+
 // Stores the data in the database.
+// If the database is locked by another connection, the transaction is retried
+// with an exponential backoff before giving up.
 func StoreData(db *sql.DB, data []CryptoDataCurated, tableName string) error {
+	return StoreDataContext(context.Background(), db, data, tableName)
+}
+
+// Checks that every entry has a symbol, a date in the format YYYY-MM-DD and a
+// finite value, so a bug extracting the data is not stored.
+// The error names the first invalid entry.
+func validateCurated(data []CryptoDataCurated) error {
+	for i, entry := range data {
+		var problem string
+		if entry.symbol == "" {
+			problem = "has no symbol"
+		} else if _, err := time.Parse("2006-01-02", entry.date); err != nil {
+			problem = fmt.Sprintf("has an invalid date %q", entry.date)
+		} else if math.IsNaN(entry.value) || math.IsInf(entry.value, 0) {
+			problem = fmt.Sprintf("has a non-finite value %v", entry.value)
+		}
+		if problem != "" {
+			return DataError{Msg: fmt.Sprintf("curated entry %d (%s on %s) %s", i, entry.symbol, entry.date, problem)}
+		}
+	}
+	return nil
+}
+
+// Works like StoreData, aborting the queries in flight once ctx is done, with an
+// error wrapping the one of ctx.
+func StoreDataContext(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) error {
+	_, err := storeDataBatched(ctx, db, data, tableName, 0)
+	return err
+}
+
+// Number of rows of a store, by what happened to them.
+type StoreCount struct {
+	// Rows to store, once the repeated ones are left out.
+	Attempted int
+	// Rows that were not in the database.
+	Inserted int
+	// Rows stored before as missing, whose value was filled.
+	Filled int
+}
+
+// Returns the number of rows already in the database, left as they were.
+func (c StoreCount) Duplicates() int {
+	return c.Attempted - c.Inserted - c.Filled
+}
+
+// Works like StoreDataContext, returning how many rows were new and how many were
+// already stored, e.g. to tell apart a symbol without new data in a second run
+// from one whose extraction failed.
+func StoreDataCounted(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) (StoreCount, error) {
+	return storeDataBatched(ctx, db, data, tableName, 0)
+}
+
+// Works like StoreData, committing a transaction every batchSize rows so the
+// locks are not held for the whole data. A batchSize of 0 (or less) means a
+// single transaction. The batches already committed are kept if a later one fails.
+func StoreDataBatched(db *sql.DB, data []CryptoDataCurated, tableName string, batchSize int) error {
+	_, err := storeDataBatched(context.Background(), db, data, tableName, batchSize)
+	return err
+}
+
+// Does the work of StoreDataBatched, within ctx. The rows stored of every symbol
+// are logged at debug level, e.g. "BTC: 0 new (25 duplicates)".
+func storeDataBatched(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string, batchSize int) (StoreCount, error) {
+	if err := validateCurated(data); err != nil {
+		return StoreCount{}, err
+	}
+	data = dedupeCuratedData(data)
 	if tableName == "" {
 		tableName = "crypto_prices"
 	}
+	if batchSize < 1 || batchSize > len(data) {
+		batchSize = len(data)
+	}
+
+	var total StoreCount
+	counts := make(map[string]StoreCount)
+	for start := 0; ; start += batchSize {
+		end := min(start+batchSize, len(data))
+		batchCounts, err := storeBatch(ctx, db, data[start:end], tableName)
+		for symbol, count := range batchCounts {
+			counts[symbol] = StoreCount{
+				Attempted: counts[symbol].Attempted + count.Attempted,
+				Inserted:  counts[symbol].Inserted + count.Inserted,
+				Filled:    counts[symbol].Filled + count.Filled,
+			}
+			total.Attempted += count.Attempted
+			total.Inserted += count.Inserted
+			total.Filled += count.Filled
+		}
+		if err != nil {
+			return total, err
+		}
+		if end == len(data) {
+			break
+		}
+	}
+
+	symbols := make([]string, 0, len(counts))
+	for symbol := range counts {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		count := counts[symbol]
+		slog.Debug(fmt.Sprintf("%s: %d new (%d duplicates)", symbol, count.Inserted+count.Filled, count.Duplicates()))
+	}
+	return total, nil
+}
+
+// Stores a batch of data within a transaction, retrying while the database is locked.
+// It returns the rows stored of every symbol.
+func storeBatch(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) (map[string]StoreCount, error) {
+	delay := storeRetryDelay
+	var counts map[string]StoreCount
+	var err error
+	for attempt := 1; attempt <= storeAttempts; attempt++ {
+		counts, err = storeDataTx(ctx, db, data, tableName)
+		if err == nil || !isDatabaseLocked(err) || attempt == storeAttempts {
+			break
+		}
+		slog.Warn("The database is locked, retrying", "attempt", attempt, "wait", delay)
+		if err = sleepContext(ctx, delay); err != nil {
+			break
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return nil, DbError{Msg: "Failed to store data", Err: err}
+	}
+	return counts, nil
+}
 
+// Stores the data in the database within a single transaction.
+// It returns the rows stored of every symbol, once committed.
+func storeDataTx(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) (map[string]StoreCount, error) {
 	// Store data in SQLite database
+	// Only the statements take ctx: database/sql discards the connection of a cancelled
+	// transaction, which is the whole database when it's an in-memory one.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		slog.Error("Failed to begin transaction", "err", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The year.week is only stored in the tables migrated to have it.
+	columns, err := TableColumns(ctx, tx, tableName)
+	if err != nil {
+		slog.Error("Failed to read the columns of the table", "err", err.Error())
+		return nil, err
 	}
+	withYearWeek := columns["year_week"]
+
 	insertQuery := "INSERT OR IGNORE INTO " + tableName + "(symbol, timestamp, value) values(?, ?, ?)"
-	stmt, err := tx.Prepare(insertQuery)
+	if withYearWeek {
+		insertQuery = "INSERT OR IGNORE INTO " + tableName + "(symbol, timestamp, value, year_week) values(?, ?, ?, ?)"
+	}
+	stmt, err := tx.PrepareContext(ctx, insertQuery)
 	if err != nil {
 		slog.Error("Failed to prepare statement", "err", err.Error())
+		return nil, err
 	}
 	defer stmt.Close()
 
+	// A value stored as NULL (missing) is filled once the API has it.
+	fillQuery := "UPDATE " + tableName + " SET value = ? WHERE symbol = ? AND timestamp = ? AND value IS NULL"
+	fillStmt, err := tx.PrepareContext(ctx, fillQuery)
+	if err != nil {
+		slog.Error("Failed to prepare statement", "err", err.Error())
+		return nil, err
+	}
+	defer fillStmt.Close()
+
+	counts := make(map[string]StoreCount)
 	for _, curated := range data {
-		_, err = stmt.Exec(curated.symbol, curated.date, curated.value)
+		count := counts[curated.symbol]
+		count.Attempted++
+		if !curated.missing {
+			result, err := fillStmt.ExecContext(ctx, curated.value, curated.symbol, curated.date)
+			if err != nil {
+				slog.Error("Failed to fill missing data in table", "err", err.Error())
+				return nil, err
+			}
+			if filled, _ := result.RowsAffected(); filled > 0 {
+				count.Filled++
+				counts[curated.symbol] = count
+				continue
+			}
+		}
+		args := []interface{}{curated.symbol, curated.date, curated.nullableValue()}
+		if withYearWeek {
+			args = append(args, nullableYearWeek(curated.date))
+		}
+		result, err := stmt.ExecContext(ctx, args...)
 		if err != nil {
 			slog.Error("Failed to insert data into table", "err", err.Error())
-			return err
+			return nil, err
+		}
+		if inserted, _ := result.RowsAffected(); inserted > 0 {
+			count.Inserted++
 		}
+		counts[curated.symbol] = count
 	}
 
 	if err := tx.Commit(); err != nil {
 		slog.Error("Failed to commit transaction", "err", err.Error())
-		return err
+		return nil, err
 	}
-	return nil
+	return counts, nil
 }
 
-// Updates the index file
+// Tells if the error returned by SQLite means that the database is locked.
+func isDatabaseLocked(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// Updates the index file. Without a path the index is not persisted.
 func writeIndexToFile(i int, path string) error {
+	if path == "" {
+		return nil
+	}
 	file, err := os.Create(path)
 	if err != nil {
 		return err
@@ -422,8 +1999,11 @@ func writeIndexToFile(i int, path string) error {
 	return nil
 }
 
-// Reads the value from the index
+// Reads the value from the index. Without a path it's always 0, the first symbol.
 func readIndexFromFile(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return 0, err
@@ -444,8 +2024,26 @@ func readIndexFromFile(path string) (int, error) {
 }
 
 // Wrapper around getData, useful for Mocking in tests
+// It uses the HTTP client of the collector, if any.
 func (c Collector) GetGetDataFunc() GetDataFunc {
-	return getData
+	if c.HTTPClient == nil && c.UserAgent == "" && c.AuthMode != AuthModeHeader {
+		return getData
+	}
+	client, userAgent := c.HTTPClient, c.UserAgent
+	if client == nil {
+		client = httpClient
+	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return func(resource string) ([]byte, error) {
+		header := http.Header{"User-Agent": {userAgent}}
+		if c.AuthMode == AuthModeHeader {
+			// The key in use when requesting, as it changes once exhausted.
+			header.Set("Authorization", "Bearer "+c.currentApiKey())
+		}
+		return getDataWith(client, header, resource)
+	}
 }
 
 // Wrapper around getData, useful for Mocking in tests
@@ -469,11 +2067,16 @@ func AddToBlacklist(db *sql.DB, symbol string, table string) error {
 }
 
 func IsBlacklisted(db *sql.DB, symbol string, table string) bool {
+	return IsBlacklistedContext(context.Background(), db, symbol, table)
+}
+
+// Works like IsBlacklisted, aborting the query once ctx is done.
+func IsBlacklistedContext(ctx context.Context, db *sql.DB, symbol string, table string) bool {
 	if table == "" {
 		table = "blacklist"
 	}
 	var count int
-	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE symbol = ?", table), symbol).Scan(&count)
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE symbol = ?", table), symbol).Scan(&count)
 	if err != nil {
 		return false
 	}
@@ -481,137 +2084,100 @@ func IsBlacklisted(db *sql.DB, symbol string, table string) bool {
 }
 
 // Same functionality that Run function, but with goroutines
+// The index is the position in the currency list of the first symbol of the batch
+// being processed, the same as in Run. The symbols skipped by Run (blacklisted,
+// fetched recently or up to date) are left out when building the batches, so
+// they don't shift it.
 func RunGoRoutines(c CollectorInterface, n int, clear bool, sleep bool) (int, error) {
+	return RunGoRoutinesContext(context.Background(), c, n, clear, sleep)
+}
+
+// Same as RunGoRoutines, but the run stops once ctx is done, between batches or
+// during the pause after one of them, keeping the index of the first symbol of the
+// next batch so the next run resumes from there.
+// In that case the error returned is the one from the context.
+func RunGoRoutinesContext(ctx context.Context, c CollectorInterface, n int, clear bool, sleep bool) (int, error) {
+	release, err := acquireLock(c.lockPath(), c.forceLock())
+	if err != nil {
+		return 0, err
+	}
+	defer release()
 
 	records, err := c.ReadCurrencyList()
 	if err != nil {
 		return 0, err
 	}
-	records = records[1:]
 
-	db, err := c.setUpDb("")
+	db, err := setUpRunDb(c)
 	if err != nil {
-		return 0, DbError{Msg: "Error setting up the database"}
+		return 0, err
 	}
-	defer db.Close()
+	defer closeDb(c, db)
 
 	if clear {
 		slog.Info("Clearing the blacklist table")
 		db.Exec("DELETE FROM blacklist")
 	}
 
-	// Filter the records list with only the useful ones.
-	var filtered []string
-	for i := 0; i < len(records); i++ {
-		if !IsBlacklisted(db, records[i][0], "") {
-			filtered = append(filtered, records[i][0])
-		}
-	}
-
 	index, err := readIndexFromFile(c.getIndexPath())
 	if err != nil {
 		// If the file doesn't exist yet, start from the beginning.
 		slog.Info("No index found, start from the beggining")
 		index = 0
 	}
+	if index < 1 {
+		// First row is a header, not useful
+		index = 1
+	}
 
 	processed := 0
-
-	var wg sync.WaitGroup
-	type returnData struct {
-		curatedData  []CryptoDataCurated
-		err          error
-		symbol       string
-		limitReached bool
+	if n < 1 {
+		// Batches without symbols would never get to the end of the list.
+		n = DefaultBatchSize
 	}
 
-	// Create a slice of up to n elements from the filtered
-	for i := index; i < len(filtered); i += n {
-		var end int
-		if i+n <= len(filtered) {
-			end = i + n
-		} else {
-			end = len(filtered)
-		}
+	// The symbols are fetched by the goroutines and their results stored from here,
+	// the same as in Run.
+	var summary RunResult
+	var wg sync.WaitGroup
+	// Symbols requested in the last batch, which count for the rate limit of the retries.
+	lastBatch := 0
 
+	// Create batches of up to n symbols that are not skipped.
+	for i := index; i < len(records); {
 		err = writeIndexToFile(i, c.getIndexPath())
 		if err != nil {
 			slog.Error("Failed to write index to file", "err", err.Error())
 			return processed, err
 		}
 
-		goroutines := filtered[i:end]
-		returnCh := make(chan returnData, len(goroutines))
+		if ctx.Err() != nil {
+			slog.Info("The run was stopped", "index", i, "reason", ctx.Err().Error())
+			return processed, ctx.Err()
+		}
+
+		// Position in the currency list of every symbol of the batch.
+		positions := make(map[string]int, n)
+		var goroutines []string
+		end := i
+		for ; end < len(records) && len(goroutines) < n; end++ {
+			symbol := records[end][0]
+			if !skipSymbol(ctx, c, db, symbol) {
+				positions[symbol] = end
+				goroutines = append(goroutines, symbol)
+			}
+		}
+
+		lastBatch = len(goroutines)
+		returnCh := make(chan symbolResult, len(goroutines))
 
 		for _, symbol := range goroutines {
 			wg.Add(1)
 			processed++
 			go func(symbol string) {
 				defer wg.Done()
-				var curatedData []CryptoDataCurated
 				slog.Info(symbol + " processing...")
-				url := c.GetURLFromSymbol(symbol)
-				response, err := c.GetGetDataFunc()(url)
-				if err != nil {
-					slog.Error("There was an error trying to get a response from ", "url", url)
-					returnCh <- returnData{
-						curatedData: curatedData,
-						err:         err,
-						symbol:      symbol,
-					}
-					return
-				}
-				slog.Debug(symbol + " getting response...")
-				raw, status := GetRawValuesFromResponse(response)
-				if status != allGood {
-					switch status {
-					case missingSymbol:
-						// The data is unreadable, but the loop can continue.
-						// Somehow the API returns Data error for certain symbols.
-						slog.Warn(symbol + "'s data was not valid. Blacklisting it...")
-						AddToBlacklist(db, symbol, "")
-					case limitReached:
-						slog.Info("Reached the limit for today.")
-						if c.isProduction() {
-							slog.Info("We will continue in 24 hours")
-							time.Sleep(24 * time.Hour)
-						} else {
-							slog.Info(symbol + " Finishing...")
-							returnCh <- returnData{
-								curatedData:  curatedData,
-								err:          err,
-								limitReached: true,
-								symbol:       symbol,
-							}
-							return
-						}
-					default:
-						slog.Error("Failed to fetch data from API", "err", err.Error())
-					}
-					return
-				}
-
-				slog.Debug(symbol + " extracting response...")
-				curatedData, extracted, err := c.GetExtractDataFromValuesFunc()(raw, 25, symbol)
-				if err != nil {
-					slog.Error("Unable to extract data from raw response", "err", err.Error())
-					returnCh <- returnData{
-						curatedData: curatedData,
-						err:         err,
-						symbol:      symbol,
-					}
-					return
-				}
-				if extracted != 25 {
-					slog.Warn(symbol+" Response was incomplete", "extracted", extracted)
-				}
-				slog.Debug(symbol + " returning response to main goroutine...")
-				returnCh <- returnData{
-					curatedData: curatedData,
-					err:         nil,
-					symbol:      symbol,
-				}
-				slog.Info(symbol + " DONE.")
+				returnCh <- fetchSymbol(ctx, c, db, symbol)
 			}(symbol)
 		}
 		slog.Debug("Waiting return from all goroutines...")
@@ -621,31 +2187,58 @@ func RunGoRoutines(c CollectorInterface, n int, clear bool, sleep bool) (int, er
 			close(returnCh)
 		}()
 
-		for value := range returnCh {
-			slog.Debug(value.symbol + " value arrived to the channel")
-			if value.err != nil {
-				slog.Error(" returned by the goroutine", "err", value.err.Error())
-			}
-			if value.limitReached {
-				return processed, nil
-			}
-			slog.Debug(value.symbol + " storing data in the database...")
-			err = c.GetStoreDataFunc()(db, value.curatedData, "crypto_prices")
+		// The results of the whole batch are stored even if the limit was reached.
+		// The next run resumes from the first symbol that reached it.
+		limitIndex := -1
+		for result := range returnCh {
+			slog.Debug(result.symbol + " value arrived to the channel")
+			summary.Processed++
+			finished, err := storeSymbolResult(ctx, c, db, result, &summary)
+			reportProgress(c, result, positions[result.symbol], len(records)-1, &summary)
 			if err != nil {
-				slog.Error(value.symbol+" unable to store data in the database", "err", err.Error())
-				continue
+				return processed, err
+			}
+			if finished && (limitIndex < 0 || positions[result.symbol] < limitIndex) {
+				limitIndex = positions[result.symbol]
 			}
 		}
 		slog.Debug("All goroutines processed.")
 
-		if len(goroutines) < n {
+		if limitIndex >= 0 {
+			return processed, writeIndexToFile(limitIndex, c.getIndexPath())
+		}
+
+		i = end
+		if i >= len(records) {
 			// Finish!
 			break
 		}
 
 		if sleep {
 			slog.Info("Now we sleep for a minute...")
-			time.Sleep(time.Minute)
+			if err := sleepContext(ctx, time.Minute); err != nil {
+				slog.Info("The run was stopped", "index", i, "reason", err.Error())
+				if err := writeIndexToFile(i, c.getIndexPath()); err != nil {
+					slog.Error("Failed to write index to file", "err", err.Error())
+				}
+				return processed, err
+			}
+		}
+	}
+
+	// The symbols that failed to be requested, e.g. the ones that reached the limit
+	// of an API key before switching to the next one, get a second chance.
+	if len(summary.Failed) > 0 {
+		window := time.Duration(0)
+		if sleep {
+			window = time.Minute
+		}
+		// The last batch didn't sleep, so its requests are the first ones of the limiter.
+		limiter := newRateLimiter(n, window)
+		limiter.requests = lastBatch
+		finished, err := retrySymbols(ctx, c, db, limiter, &summary)
+		if err != nil || finished {
+			return processed, err
 		}
 	}
 
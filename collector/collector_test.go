@@ -1,11 +1,26 @@
 package collector
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // The MockCollector is a wrapper around Collector
@@ -37,7 +52,7 @@ func NewMockCollector(dbFilePath string, apiKeyFilePath string, apiUrl string, c
 
 // Init a collector with default values useful for our tests.
 func initCollector() (Collector, error) {
-	return NewCollector("../crypto.sqlite", "../apikey.txt", "https://www.alphavantage.co/query?function=DIGITAL_CURRENCY_WEEKLY&symbol=%s&market=EUR&apikey=%s", "../digital_currency_list.csv", false, "index_test.txt")
+	return NewCollector("../crypto.sqlite", "../apikey.txt", "https://www.alphavantage.co/query?function=DIGITAL_CURRENCY_WEEKLY&symbol=%s&market=EUR&apikey=%s", "../digital_currency_list.csv", false, "index_test.txt", DefaultMarket, DefaultMode)
 }
 
 // Tests that we can extract the raw values from a request, for several symbols.
@@ -74,17 +89,17 @@ func TestGetRawValuesFromSymbolAPI(t *testing.T) {
 		_, status := GetRawValuesFromResponse(response)
 
 		switch status {
-		case missingSymbol:
+		case StatusMissingSymbol:
 			if symbol != "NO-SYMBOL" {
 				t.Logf("Received missing symbol without expecting.")
 				t.Fail()
 			}
-		case limitReached:
+		case StatusLimitReached:
 			if symbol != "LIMIT" {
 				t.Logf("Received limit reached without being expected")
 				t.Fail()
 			}
-		case allGood:
+		case StatusAllGood:
 			if symbol != "ALL-GOOD" {
 				t.Logf("Received all-good without being expected")
 				t.Fail()
@@ -152,13 +167,140 @@ func TestReadCurrencyList(t *testing.T) {
 	}
 
 	for i, row := range records {
-		if len(row) != 2 {
-			t.Log("The row", i, "does not have exactly 2 values")
+		if len(row) < 1 || row[0] == "" {
+			t.Log("The row", i, "does not have a symbol")
 			t.Fail()
 		}
 	}
 }
 
+// Tests that rows with more than two columns are accepted, using the first one as symbol.
+func TestReadCurrencyListExtraColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "currencies.csv")
+	content := "currency code,currency name\nBTC,Bitcoin,crypto\nETH,Ethereum,crypto,extra\nADA,Cardano\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("unable to write the currency list", err.Error())
+	}
+
+	c := Collector{CurrencyListFilePath: path}
+	records, err := c.ReadCurrencyList()
+	if err != nil {
+		t.Fatal("Rows with extra columns should be accepted:", err.Error())
+	}
+
+	expected := []string{"currency code", "BTC", "ETH", "ADA"}
+	if len(records) != len(expected) {
+		t.Fatal("Expected", len(expected), "records, got", len(records))
+	}
+	for i, symbol := range expected {
+		if records[i][0] != symbol {
+			t.Log("Expected symbol", symbol, "in row", i, "got", records[i][0])
+			t.Fail()
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("currency code,currency name\n,Nameless\n"), 0644); err != nil {
+		t.Fatal("unable to write the currency list", err.Error())
+	}
+	if _, err := c.ReadCurrencyList(); err == nil {
+		t.Log("A row without symbol should return an error")
+		t.Fail()
+	}
+}
+
+// Tests that several currency lists are combined, keeping the first header and
+// the first appearance of every symbol.
+func TestReadCurrencyListMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	crypto := filepath.Join(dir, "crypto.csv")
+	stablecoins := filepath.Join(dir, "stablecoins.csv")
+	if err := os.WriteFile(crypto, []byte("currency code,currency name\nBTC,Bitcoin\nUSDT,Tether\nETH,Ethereum\n"), 0644); err != nil {
+		t.Fatal("unable to write the currency list", err.Error())
+	}
+	if err := os.WriteFile(stablecoins, []byte("code,name\nUSDC,USD Coin\nUSDT,Tether\nDAI,Dai\n"), 0644); err != nil {
+		t.Fatal("unable to write the currency list", err.Error())
+	}
+
+	c := Collector{CurrencyListFilePath: crypto, ExtraCurrencyListFilePaths: []string{stablecoins}}
+	records, err := c.ReadCurrencyList()
+	if err != nil {
+		t.Fatal("unable to read the currency lists", err.Error())
+	}
+
+	expected := []string{"currency code", "BTC", "USDT", "ETH", "USDC", "DAI"}
+	if len(records) != len(expected) {
+		t.Fatal("Expected", len(expected), "records, got", len(records))
+	}
+	for i, symbol := range expected {
+		if records[i][0] != symbol {
+			t.Log("Expected symbol", symbol, "in row", i, "got", records[i][0])
+			t.Fail()
+		}
+	}
+
+	c.ExtraCurrencyListFilePaths = []string{filepath.Join(dir, "missing.csv")}
+	if _, err := c.ReadCurrencyList(); err == nil {
+		t.Log("A missing extra list should return an error")
+		t.Fail()
+	}
+}
+
+// Tests that the symbols are read from the configured column of the currency list.
+func TestReadCurrencyListSymbolColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "currencies.csv")
+	if err := os.WriteFile(path, []byte("name,code\nBitcoin,BTC\nEthereum,ETH\n"), 0644); err != nil {
+		t.Fatal("unable to write the currency list", err.Error())
+	}
+
+	c := Collector{CurrencyListFilePath: path, SymbolColumn: 1}
+	records, err := c.ReadCurrencyList()
+	if err != nil {
+		t.Fatal("unable to read the currency list", err.Error())
+	}
+	expected := [][]string{{"code", "name"}, {"BTC", "Bitcoin"}, {"ETH", "Ethereum"}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected the records %v, got %v", expected, records)
+	}
+
+	for _, column := range []int{2, -1} {
+		c.SymbolColumn = column
+		var dataErr DataError
+		if _, err := c.ReadCurrencyList(); !errors.As(err, &dataErr) {
+			t.Errorf("Expected a DataError for the column %d, got %v", column, err)
+		}
+	}
+}
+
+// Tests that a currency list without symbols returns a DataError.
+func TestReadCurrencyListEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "currencies.csv")
+	c := Collector{CurrencyListFilePath: path}
+
+	for _, content := range []string{"currency code,currency name\n", ""} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("unable to write the currency list", err.Error())
+		}
+		_, err := c.ReadCurrencyList()
+		if _, ok := err.(DataError); !ok {
+			t.Logf("Expected a DataError for the list %q, got %v", content, err)
+			t.Fail()
+		}
+	}
+}
+
+// Returns a new in-memory database with the schema of the collector, closed
+// when the test finishes. Every call returns a different database.
+func newTestDb(t *testing.T) *sql.DB {
+	t.Helper()
+	c := Collector{DbFilePath: ":memory:"}
+	db, err := c.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the in-memory db", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 // Tests that the database can be created.
 func TestSetupDb(t *testing.T) {
 	c, err := initCollector()
@@ -242,7 +384,7 @@ func TestExtractDataFromCompleteValues(t *testing.T) {
 		t.Fail()
 	}
 
-	values, _, err := ExtractDataFromValues(result, 30, "BTC")
+	values, _, err := ExtractDataFromValues(result, 30, "BTC", 0, false)
 	if err != nil {
 		t.Log("It was not possible to extract the data. Error:", err)
 		t.Fail()
@@ -313,7 +455,7 @@ func TestExtractDataFromIncompleteValues(t *testing.T) {
 		t.Fail()
 	}
 
-	_, extracted, err := ExtractDataFromValues(result, 30, "BTC")
+	_, extracted, err := ExtractDataFromValues(result, 30, "BTC", 0, false)
 	if err != nil {
 		t.Log("It was not possible to extract the data. Error:", err)
 		t.Fail()
@@ -326,30 +468,7 @@ func TestExtractDataFromIncompleteValues(t *testing.T) {
 
 // Test that we can store data in the database.
 func TestStoreData(t *testing.T) {
-	c, err := initCollector()
-	if err != nil {
-		t.Log("unable to create  collector")
-		t.Fail()
-	}
-
-	sqlStmt := `
-	CREATE TABLE IF NOT EXISTS crypto_prices_test (
-		symbol TEXT NOT NULL,
-		timestamp TEXT NOT NULL,
-		value REAL NOT NULL
-	);
-	`
-
-	db, err := c.setUpDb(sqlStmt)
-	if err != nil {
-		t.Log("The create table statement returned an unexpected error")
-		t.Fail()
-	}
-	defer db.Close()
-	defer func() {
-		t.Log("Deleting the table created for the test.")
-		db.Exec("DROP TABLE IF EXISTS crypto_prices_test")
-	}()
+	db := newTestDb(t)
 
 	data := []CryptoDataCurated{
 		{
@@ -368,11 +487,54 @@ func TestStoreData(t *testing.T) {
 			value:  1.00,
 		},
 	}
-	err = StoreData(db, data, "crypto_prices_test")
+	err := StoreData(db, data, "")
 	if err != nil {
 		t.Log("It was not possible to store data:", err)
 		t.Fail()
 	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices").Scan(&count)
+	if count != len(data) {
+		t.Log("Expected", len(data), "stored rows, got", count)
+		t.Fail()
+	}
+}
+
+// Tests that storing many rows in small batches keeps all of them.
+func TestStoreDataBatched(t *testing.T) {
+	c := Collector{DbFilePath: filepath.Join(t.TempDir(), "crypto.sqlite")}
+	db, err := c.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	var data []CryptoDataCurated
+	start := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 1003; i++ {
+		data = append(data, CryptoDataCurated{symbol: "BTC", date: start.AddDate(0, 0, 7*i).Format("2006-01-02"), value: float64(i + 1)})
+	}
+
+	c.StoreBatchSize = 10
+	if err := c.GetStoreDataFunc()(context.Background(), db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'BTC'").Scan(&count); err != nil {
+		t.Fatal("unable to count the rows", err.Error())
+	}
+	if count != len(data) {
+		t.Log("Expected", len(data), "rows, got", count)
+		t.Fail()
+	}
+
+	// Storing nothing is not an error.
+	if err := StoreDataBatched(db, nil, "", 10); err != nil {
+		t.Log("Storing no data should not fail:", err)
+		t.Fail()
+	}
 }
 
 // Mock of ReadCurrencyList, where we provide a very short list of currencies for the tests.
@@ -428,7 +590,7 @@ func (mc MockCollector) GetExtractDataFromValuesFunc() ExtractDataFromValuesFunc
 }
 
 // Mock for StoreData. Return nil error, so everything went fine (it "stored" the data properly)
-func MockStoreData(db *sql.DB, data []CryptoDataCurated, tableName string) error {
+func MockStoreData(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) error {
 	return nil
 }
 
@@ -474,56 +636,188 @@ func (mc MockCollector) GetURLFromSymbol(symbol string) string {
 	return "datatest/sample_response.json"
 }
 
-func TestBlacklist(t *testing.T) {
-	var symbols = []string{"symbol1", "symbol2", "symbol3"}
-	c, err := initCollector()
-	if err != nil {
-		t.Log("Error creating the collector", err.Error())
-		t.Fatal()
+// testCollector is a MockCollector for the tests of the runs, with its database and
+// index in a temporary directory, see newTestCollector. The symbol itself is the
+// resource requested, answered as the fields tell.
+type testCollector struct {
+	MockCollector
+	// File of datatest answering every symbol, the sample response for the rest.
+	responses map[string]string
+	// Symbols, and API keys, whose requests reach the daily limit.
+	limited map[string]bool
+	// Number of times the requests of every symbol fail, with a connection error
+	// of the kind, or with an empty response when empty is set.
+	failures map[string]int
+	kind     ConnectionErrorKind
+	empty    bool
+	// How long every request takes.
+	delay time.Duration
+	// Extracts and stores the data for real, instead of with the mocks.
+	realData bool
+	// Error returned when storing the data, if any.
+	storeErr error
+	// When reload is set, the currency list is read from its file, which is replaced
+	// by reloadedList after the first read, asking to reload it.
+	reload       chan struct{}
+	reloadedList string
+	// Requests received, shared by the copies of the collector.
+	calls *testCalls
+}
+
+// Requests received by a testCollector.
+type testCalls struct {
+	mu sync.Mutex
+	// Requests per symbol and per API key.
+	symbols map[string]int
+	keys    map[string]int
+	// Times every symbol was answered, the failures and the daily limits left out.
+	fetched map[string]int
+	// Times the currency list was read.
+	reads int
+}
+
+// Returns the number of requests received.
+func (calls *testCalls) requests() int {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	total := 0
+	for _, n := range calls.symbols {
+		total += n
 	}
+	return total
+}
 
-	sqlStmt := `
-	CREATE TABLE IF NOT EXISTS blacklist_test (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		symbol VARCHAR(255) UNIQUE NOT NULL
-	);
-	`
-	db, err := c.setUpDb(sqlStmt)
+// Creates a testCollector answering every symbol with the sample response, using
+// the mocks to extract and store the data.
+func newTestCollector(t *testing.T) testCollector {
+	t.Helper()
+	dir := t.TempDir()
+	mc, err := NewMockCollector(filepath.Join(dir, "crypto.sqlite"), "../apikey.txt", "", "../digital_currency_list.csv", filepath.Join(dir, "index.txt"))
 	if err != nil {
-		t.Log("unable to setup the db", err.Error())
-		t.Fatal()
+		t.Fatal("unable to create collector", err.Error())
+	}
+	return testCollector{MockCollector: mc, calls: &testCalls{symbols: map[string]int{}, keys: map[string]int{}, fetched: map[string]int{}}}
+}
+
+// The symbol itself is the resource requested, so the responses can be told apart.
+func (tc testCollector) GetURLFromSymbol(symbol string) string {
+	return symbol
+}
+
+// Answers the symbol with its failures first, then the daily limit or its response.
+func (tc testCollector) GetGetDataFunc() GetDataFunc {
+	return func(symbol string) ([]byte, error) {
+		time.Sleep(tc.delay)
+		key := tc.currentApiKey()
+
+		tc.calls.mu.Lock()
+		defer tc.calls.mu.Unlock()
+		tc.calls.symbols[symbol]++
+		tc.calls.keys[key]++
+		if tc.failures[symbol] > 0 {
+			tc.failures[symbol]--
+			if tc.empty {
+				return os.ReadFile("datatest/empty_response.json")
+			}
+			return nil, ConnectionError{Msg: "connection reset by peer", Kind: tc.kind}
+		}
+		if tc.limited[symbol] || tc.limited[key] {
+			return os.ReadFile("datatest/limit_achieved_response.json")
+		}
+		tc.calls.fetched[symbol]++
+		if response, ok := tc.responses[symbol]; ok {
+			return os.ReadFile(response)
+		}
+		return os.ReadFile("datatest/sample_response.json")
+	}
+}
+
+// Uses the real extraction with realData, the mocked one otherwise.
+func (tc testCollector) GetExtractDataFromValuesFunc() ExtractDataFromValuesFunc {
+	if tc.realData {
+		return tc.Collector.GetExtractDataFromValuesFunc()
+	}
+	return tc.MockCollector.GetExtractDataFromValuesFunc()
+}
+
+// Fails with storeErr, if any, and uses the real storage with realData.
+func (tc testCollector) GetStoreDataFunc() StoreDataFunc {
+	switch {
+	case tc.storeErr != nil:
+		return func(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) error {
+			return tc.storeErr
+		}
+	case tc.realData:
+		return tc.Collector.GetStoreDataFunc()
+	}
+	return tc.MockCollector.GetStoreDataFunc()
+}
+
+// Reads the currency list from its file when reloading it, the mocked one otherwise.
+func (tc testCollector) ReadCurrencyList() ([][]string, error) {
+	if tc.reload == nil {
+		return tc.MockCollector.ReadCurrencyList()
 	}
+	records, err := tc.Collector.ReadCurrencyList()
+	tc.calls.mu.Lock()
+	tc.calls.reads++
+	first := tc.calls.reads == 1
+	tc.calls.mu.Unlock()
+	if first {
+		os.WriteFile(tc.CurrencyListFilePath, []byte(tc.reloadedList), 0644)
+		tc.reload <- struct{}{}
+	}
+	return records, err
+}
+
+// The currency list is reloaded with every value sent to reload.
+func (tc testCollector) reloadSignal() <-chan struct{} {
+	return tc.reload
+}
+
+func TestBlacklist(t *testing.T) {
+	var symbols = []string{"symbol1", "symbol2", "symbol3"}
+	db := newTestDb(t)
 
 	for _, symbol := range symbols {
-		err = AddToBlacklist(db, symbol, "blacklist_test")
+		err := AddToBlacklist(db, symbol, "")
 		if err != nil {
 			t.Log("unable to black list the symbol", symbol, err.Error())
 			t.Fail()
 		}
 	}
 
-	err = AddToBlacklist(db, symbols[0], "blacklist_test")
+	err := AddToBlacklist(db, symbols[0], "")
 	if err != nil {
 		t.Log("unable to blacklist one symbol more than once", err.Error())
 		t.Fail()
 	}
 
 	for _, symbol := range symbols {
-		if !IsBlacklisted(db, symbol, "blacklist_test") {
+		if !IsBlacklisted(db, symbol, "") {
 			t.Log("Symbol", symbol, "should have been blacklisted")
 			t.Fail()
 		}
 	}
 
-	if IsBlacklisted(db, "NON-EXISTING", "blacklist_test") {
+	if IsBlacklisted(db, "NON-EXISTING", "") {
 		t.Log("A non existing symbol was blacklisted, but it should not")
 		t.Fail()
 	}
+}
 
-	defer func() {
-		t.Log("Deleting the table created for the test.")
-		db.Exec("DROP TABLE IF EXISTS blacklist_test")
-	}()
+// Tests that every database of newTestDb is a new one.
+func TestNewTestDbIsolation(t *testing.T) {
+	first := newTestDb(t)
+	second := newTestDb(t)
+
+	if err := AddToBlacklist(first, "BTC", ""); err != nil {
+		t.Fatal("unable to blacklist the symbol", err.Error())
+	}
+	if !IsBlacklisted(first, "BTC", "") || IsBlacklisted(second, "BTC", "") {
+		t.Log("The symbol should only be blacklisted in the first database")
+		t.Fail()
+	}
 }
 
 func TestRunGoRoutine(t *testing.T) {
@@ -539,3 +833,2390 @@ func TestRunGoRoutine(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Tests that Run processes every symbol once, both sequentially and with a pool of workers.
+func TestRunConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		tc := newTestCollector(t)
+		tc.Concurrency = concurrency
+
+		result, err := Run(tc, 10, false)
+		if err != nil {
+			t.Log("there was a problem running Run with concurrency", concurrency, err.Error())
+			t.Fail()
+		}
+		processed := result.Processed
+		if processed != 7 {
+			t.Log("Expected 7 processed symbols with concurrency", concurrency, "got", processed)
+			t.Fail()
+		}
+		if tc.calls.requests() != processed {
+			t.Log("Expected one request per processed symbol with concurrency", concurrency, "got", tc.calls.requests())
+			t.Fail()
+		}
+
+		index, err := readIndexFromFile(tc.getIndexPath())
+		if err != nil || index != 0 {
+			t.Log("The index should have been restarted after the run with concurrency", concurrency)
+			t.Fail()
+		}
+	}
+}
+
+// Tests that a run with a pool of workers resumes from the first symbol not stored
+// once the daily limit is reached, so the symbols in flight are not skipped.
+func TestRunPoolResume(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.Concurrency = 3
+	tc.delay = 20 * time.Millisecond
+	tc.realData = true
+	tc.limited = map[string]bool{"ETH": true}
+
+	result, err := Run(tc, 10, false)
+	if err != nil || result.StopReason != ErrDailyLimitReached {
+		t.Fatalf("Expected the run to stop at the daily limit, got %v and %v", err, result.StopReason)
+	}
+	index, err := readIndexFromFile(tc.getIndexPath())
+	if err != nil || index < 1 || index > 4 {
+		t.Fatalf("Expected to resume from ETH (4) at the latest, got %d (%v)", index, err)
+	}
+
+	tc.limited = nil
+	if _, err := Run(tc, 10, false); err != nil {
+		t.Fatal("there was a problem running Run again", err.Error())
+	}
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+	for _, symbol := range []string{"BTC", "ADA", "AIR", "ETH", "SLR", "BAND", "BRD"} {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = ?", symbol).Scan(&count)
+		if count == 0 {
+			t.Errorf("Expected %s to be stored after resuming", symbol)
+		}
+	}
+}
+
+// Tests that a pool of workers stopped by the daily limit doesn't wait for the rate
+// limiter to hand the next symbol before returning.
+func TestRunPoolStopsWaiting(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.Concurrency = 3
+	tc.limited = map[string]bool{"BTC": true}
+
+	// After BTC, the next symbol waits a minute for the rate limit.
+	start := time.Now()
+	result, err := Run(tc, 1, false)
+	if err != nil || result.StopReason != ErrDailyLimitReached {
+		t.Fatalf("Expected the run to stop at the daily limit, got %v and %v", err, result.StopReason)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("The run should stop without waiting for the rate limit, it took %v", elapsed)
+	}
+}
+
+// Tests that the rate limiter is shared across goroutines, sleeping once every n requests.
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, 50*time.Millisecond)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.wait(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	// 5 requests with 2 per window need to wait for 2 windows.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Log("The limiter did not wait enough, elapsed", elapsed)
+		t.Fail()
+	}
+}
+
+// Tests that StoreData waits for a locked database and stores the data once it is released.
+func TestStoreDataRetriesLockedDatabase(t *testing.T) {
+	// Fail right away when the database is locked instead of waiting inside SQLite.
+	dsn := filepath.Join(t.TempDir(), "crypto.sqlite") + "?_busy_timeout=0"
+	c := Collector{DbFilePath: dsn}
+
+	db, err := c.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	// Hold a write transaction from another connection.
+	other, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal("unable to open a second connection", err.Error())
+	}
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal("unable to begin the locking transaction", err.Error())
+	}
+	_, err = tx.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES (?, ?, ?)", "ETH", "2023-03-09", 3000)
+	if err != nil {
+		t.Fatal("unable to lock the database", err.Error())
+	}
+
+	defer func(delay time.Duration) { storeRetryDelay = delay }(storeRetryDelay)
+	storeRetryDelay = 50 * time.Millisecond
+
+	// Release the lock after the first attempts have failed.
+	go func() {
+		time.Sleep(120 * time.Millisecond)
+		tx.Commit()
+	}()
+
+	data := []CryptoDataCurated{{symbol: "BTC", date: "2023-03-08", value: 45000}}
+	err = StoreData(db, data, "")
+	if err != nil {
+		t.Fatal("StoreData should have succeeded once the lock was released:", err.Error())
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices").Scan(&count)
+	if count != 2 {
+		t.Log("Expected 2 rows in the table, found", count)
+		t.Fail()
+	}
+}
+
+// Tests that StoreData returns a DbError when the database stays locked.
+func TestStoreDataLockedDatabaseGivesUp(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "crypto.sqlite") + "?_busy_timeout=0"
+	c := Collector{DbFilePath: dsn}
+
+	db, err := c.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	other, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal("unable to open a second connection", err.Error())
+	}
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal("unable to begin the locking transaction", err.Error())
+	}
+	defer tx.Rollback()
+	tx.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES (?, ?, ?)", "ETH", "2023-03-09", 3000)
+
+	defer func(delay time.Duration) { storeRetryDelay = delay }(storeRetryDelay)
+	storeRetryDelay = time.Millisecond
+
+	data := []CryptoDataCurated{{symbol: "BTC", date: "2023-03-08", value: 45000}}
+	err = StoreData(db, data, "")
+	if _, ok := err.(DbError); !ok {
+		t.Log("Expected a DbError, got", err)
+		t.Fail()
+	}
+}
+
+// Tests that NewCollector accepts a supported market and mode, and rejects unknown ones.
+func TestNewCollectorMarketAndMode(t *testing.T) {
+	c, err := NewCollector("../crypto.sqlite", "../apikey.txt", ApiUrlTemplate(DefaultMode, "USD"), "../digital_currency_list.csv", false, "index_test.txt", "USD", DefaultMode)
+	if err != nil {
+		t.Log("A valid market and mode should be accepted:", err.Error())
+		t.Fail()
+	}
+	if url := c.GetURLFromSymbol("BTC"); !strings.Contains(url, "market=USD") || !strings.Contains(url, "function="+DefaultMode) {
+		t.Log("The URL does not contain the market and mode:", url)
+		t.Fail()
+	}
+
+	_, err = NewCollector("../crypto.sqlite", "../apikey.txt", "", "../digital_currency_list.csv", false, "index_test.txt", "EURR", DefaultMode)
+	if _, ok := err.(DataError); !ok || !strings.Contains(err.Error(), "EURR") || !strings.Contains(err.Error(), "EUR, GBP") {
+		t.Log("Expected a DataError naming the invalid market and listing the valid ones, got", err)
+		t.Fail()
+	}
+
+	_, err = NewCollector("../crypto.sqlite", "../apikey.txt", "", "../digital_currency_list.csv", false, "index_test.txt", DefaultMarket, "DIGITAL_CURRENCY_WEEKY")
+	if _, ok := err.(DataError); !ok || !strings.Contains(err.Error(), "DIGITAL_CURRENCY_WEEKY") || !strings.Contains(err.Error(), DefaultMode) {
+		t.Log("Expected a DataError naming the invalid mode and listing the valid ones, got", err)
+		t.Fail()
+	}
+}
+
+// Tests that NewCollectorWithOptions applies the defaults to the options left empty.
+func TestNewCollectorWithOptions(t *testing.T) {
+	c, err := NewCollectorWithOptions(CollectorOptions{
+		DbFilePath:     filepath.Join(t.TempDir(), "crypto.sqlite"),
+		ApiKeyFilePath: "../apikey.txt",
+		Market:         "USD",
+	})
+	if err != nil {
+		t.Fatal("unable to create the collector from options", err.Error())
+	}
+
+	if c.Market != "USD" || c.Mode != DefaultMode {
+		t.Log("Unexpected market and mode", c.Market, c.Mode)
+		t.Fail()
+	}
+	if c.ApiUrl != ApiUrlTemplate(DefaultMode, "USD") {
+		t.Log("Expected the URL of the market and the default mode, got", c.ApiUrl)
+		t.Fail()
+	}
+	if c.CurrencyListFilePath != DefaultCurrencyListFilePath || c.getIndexPath() != DefaultIndexPath || c.isProduction() {
+		t.Log("Unexpected defaults", c.CurrencyListFilePath, c.getIndexPath(), c.isProduction())
+		t.Fail()
+	}
+
+	if _, err := NewCollectorWithOptions(CollectorOptions{ApiKeyFilePath: "../apikey.txt", Mode: "DIGITAL_CURRENCY_WEEKY"}); err == nil {
+		t.Log("Expected an error for an unknown mode")
+		t.Fail()
+	}
+}
+
+// Tests that the close value is found for markets other than EUR.
+func TestGetRawValuesFromResponseMarket(t *testing.T) {
+	response := []byte(`{
+		"Meta Data": {"4. Market Code": "USD", "6. Last Refreshed": "2023-07-08 00:00:00"},
+		"Time Series (Digital Currency Weekly)": {
+			"2023-07-02": {"4a. close (USD)": "30317.99000000", "4b. close (USD)": "30317.99000000"}
+		}
+	}`)
+
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood {
+		t.Fatal("Expected the response to be valid, got status", status)
+	}
+	if raw.TimeSeries["2023-07-02"].Close != "30317.99000000" {
+		t.Log("The close value for the USD market was not found:", raw.TimeSeries["2023-07-02"].Close)
+		t.Fail()
+	}
+}
+
+// Tests that the close value is found whatever the market and the name of its key.
+func TestFindCloseKey(t *testing.T) {
+	cases := []struct {
+		market string
+		values map[string]string
+		key    string
+	}{
+		{"EUR", map[string]string{"1a. open (EUR)": "1", "4a. close (EUR)": "2", "4b. close (USD)": "3"}, "4a. close (EUR)"},
+		{"USD", map[string]string{"4a. close (EUR)": "2", "4b. close (USD)": "3", "5. volume": "4"}, "4b. close (USD)"},
+		{"EUR", map[string]string{"1. open": "1", "4. Close Price": "2"}, "4. Close Price"},
+		{"", map[string]string{"4. close": "2"}, "4. close"},
+		{"EUR", map[string]string{"1. open": "1"}, ""},
+	}
+	for _, tc := range cases {
+		if key := findCloseKey(tc.values, tc.market); key != tc.key {
+			t.Errorf("Expected the key %q for the market %q in %v, got %q", tc.key, tc.market, tc.values, key)
+		}
+	}
+
+	response := []byte(`{
+		"Meta Data": {"4. Market Code": "EUR", "6. Last Refreshed": "2023-07-08 00:00:00"},
+		"Time Series (Digital Currency Weekly)": {
+			"2023-07-02": {"1. open": "27000.00000000", "4. CLOSE": "27800.00000000"}
+		}
+	}`)
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood || raw.TimeSeries["2023-07-02"].Close != "27800.00000000" {
+		t.Errorf("Expected the renamed close value to be found, got status %d and %q", status, raw.TimeSeries["2023-07-02"].Close)
+	}
+}
+
+// Feeds arbitrary bytes to GetRawValuesFromResponse, which must never panic and always
+// return one of the statuses of a response. The fixtures of datatest are the seed corpus.
+func FuzzGetRawValuesFromResponse(f *testing.F) {
+	fixtures, err := filepath.Glob("datatest/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		response, err := os.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(response)
+	}
+	f.Add([]byte("null"))
+	f.Add([]byte(`{"Time Series (Digital Currency Weekly)": null}`))
+
+	f.Fuzz(func(t *testing.T, response []byte) {
+		raw, status := GetRawValuesFromResponse(response)
+		switch status {
+		case StatusAllGood:
+			if raw.TimeSeries == nil {
+				t.Errorf("Expected a time series in a valid response %q", response)
+			}
+		case StatusLimitReached, StatusMissingSymbol, StatusJsonBroken, StatusEmptyResponse, StatusDemoKey, StatusThrottled:
+		default:
+			t.Errorf("Unexpected status %d for the response %q", status, response)
+		}
+	})
+}
+
+// Tests that the errors of the API are only detected in the top-level keys of the response.
+func TestGetRawValuesFromResponseErrorKeys(t *testing.T) {
+	cases := []struct {
+		file   string
+		status int
+	}{
+		{"datatest/non_symbol_response.json", StatusMissingSymbol},
+		{"datatest/limit_achieved_response.json", StatusLimitReached},
+		{"datatest/sample_response.json", StatusAllGood},
+	}
+	for _, tc := range cases {
+		response, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal("Error while reading the json File:", err.Error())
+		}
+		if _, status := GetRawValuesFromResponse(response); status != tc.status {
+			t.Log("Expected status", tc.status, "for", tc.file, "got", status)
+			t.Fail()
+		}
+	}
+
+	// The phrases inside the data of a valid response are not errors.
+	response := []byte(`{
+		"Meta Data": {"1. Information": "Invalid API call. You have reached the 100 requests/day limit", "4. Market Code": "EUR", "6. Last Refreshed": "2023-07-08 00:00:00"},
+		"Time Series (Digital Currency Weekly)": {
+			"2023-07-02": {"4a. close (EUR)": "27800.00000000"}
+		}
+	}`)
+	if _, status := GetRawValuesFromResponse(response); status != StatusAllGood {
+		t.Log("Expected a valid response, got status", status)
+		t.Fail()
+	}
+
+	if _, status := GetRawValuesFromResponse([]byte("Invalid API call.")); status != StatusJsonBroken {
+		t.Log("Expected a response that is not JSON to be broken, got status", status)
+		t.Fail()
+	}
+}
+
+// Tests that the response of the demo API key has its own status, which counts as
+// an error of the run without blacklisting the symbol.
+func TestDemoKeyResponse(t *testing.T) {
+	response, err := os.ReadFile("datatest/demo_key_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	_, status := GetRawValuesFromResponse(response)
+	if status != StatusDemoKey {
+		t.Fatal("Expected the status of the demo API key, got", status)
+	}
+
+	db := newTestDb(t)
+	var summary RunResult
+	finished, err := storeSymbolResult(context.Background(), MockCollector{}, db, symbolResult{symbol: "ETH", status: status}, &summary)
+	if finished || err != nil {
+		t.Fatalf("Expected the run to continue, got %v and %v", finished, err)
+	}
+	if summary.Errors != 1 || len(summary.Failed) != 0 || len(summary.Blacklisted) != 0 || IsBlacklisted(db, "ETH", "") {
+		t.Errorf("Expected an error not worth retrying and no blacklisting, got %+v", summary)
+	}
+}
+
+// Tests that CountTimeSeries counts every entry of the time series, also through PreviewSymbol.
+func TestCountTimeSeries(t *testing.T) {
+	response, err := os.ReadFile("datatest/sample_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	var generic map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(response, &generic); err != nil {
+		t.Fatal("Error decoding the fixture:", err.Error())
+	}
+	expected := len(generic["Time Series (Digital Currency Weekly)"])
+
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood {
+		t.Fatal("Unexpected status reading the fixture", status)
+	}
+	if count := CountTimeSeries(raw); count != expected || count == 0 {
+		t.Log("Expected", expected, "entries in the time series, got", count)
+		t.Fail()
+	}
+
+	tc := newTestCollector(t)
+	if count, err := PreviewSymbol(tc, "BTC"); err != nil || count != expected {
+		t.Log("Expected PreviewSymbol to count", expected, "entries, got", count, err)
+		t.Fail()
+	}
+}
+
+// Tests that the run stops once the context is done, persisting the index to resume from.
+func TestRunContextMaxRuntime(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		tc := newTestCollector(t)
+		tc.Concurrency = concurrency
+		tc.delay = 50 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+		result, err := RunContext(ctx, tc, 10, false)
+		cancel()
+		processed := result.Processed
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Log("Expected the run to stop with concurrency", concurrency, "got", err)
+			t.Fail()
+		}
+		if processed == 0 || processed >= 7 {
+			t.Log("Expected the run to stop halfway with concurrency", concurrency, "processed", processed)
+			t.Fail()
+		}
+
+		index, err := readIndexFromFile(tc.getIndexPath())
+		if err != nil || index == 0 {
+			t.Log("The index should have been persisted with concurrency", concurrency, err)
+			t.Fail()
+		}
+		if concurrency == 1 && index != processed+1 {
+			t.Log("Expected to resume from", processed+1, "got", index)
+			t.Fail()
+		}
+	}
+}
+
+// Tests that a run records the completeness of every symbol in the quality table.
+func TestRunRecordsQuality(t *testing.T) {
+	tc := newTestCollector(t)
+
+	_, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	var count, expected, extracted int
+	db.QueryRow("SELECT COUNT(*) FROM quality").Scan(&count)
+	if count != 7 {
+		t.Log("Expected a quality record per symbol, got", count)
+		t.Fail()
+	}
+	db.QueryRow("SELECT expected, extracted FROM quality WHERE symbol = 'BTC'").Scan(&expected, &extracted)
+	if expected != HistoryDepth || extracted != HistoryDepth {
+		t.Log("Unexpected quality record for BTC", expected, extracted)
+		t.Fail()
+	}
+
+	// Two incomplete extractions of ETH make it the worst offender.
+	RecordQuality(db, "ETH", HistoryDepth, 10)
+	RecordQuality(db, "ETH", HistoryDepth, 12)
+	RecordQuality(db, "ADA", HistoryDepth, 3)
+
+	records, err := WorstQuality(db, 10)
+	if err != nil {
+		t.Fatal("unable to read the quality records", err.Error())
+	}
+	if len(records) != 2 || records[0].Symbol != "ETH" || records[0].IncompleteRuns != 2 || records[0].Runs != 3 || records[0].Extracted != 12 {
+		t.Log("Unexpected worst offenders", records)
+		t.Fail()
+	}
+}
+
+// Tests that Run records when the API refreshed the data of every symbol.
+func TestRunRecordsLastRefreshed(t *testing.T) {
+	tc := newTestCollector(t)
+
+	_, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	meta, err := GetSymbolMeta(db, "BTC")
+	if err != nil {
+		t.Fatal("Expected the metadata of BTC to be recorded", err)
+	}
+	if meta.LastRefreshed != "2023-07-08 00:00:00" || meta.UpdatedAt == "" {
+		t.Log("Unexpected metadata for BTC", meta)
+		t.Fail()
+	}
+
+	metas, err := ListSymbolMeta(db)
+	if err != nil || len(metas) != 7 {
+		t.Log("Expected the metadata of every symbol, got", len(metas), err)
+		t.Fail()
+	}
+
+	var dataErr DataError
+	if _, err := GetSymbolMeta(db, "NOPE"); !errors.As(err, &dataErr) {
+		t.Log("Expected a DataError for a symbol without metadata, got", err)
+		t.Fail()
+	}
+}
+
+// Tests that only the weeks within the window are extracted.
+func TestExtractDataInWindow(t *testing.T) {
+	response, err := os.ReadFile("datatest/sample_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood {
+		t.Fatal("Unexpected status reading the fixture", status)
+	}
+
+	window := ExtractWindow{
+		Start: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2023, 6, 12, 0, 0, 0, 0, time.UTC),
+	}
+	values, extracted, err := ExtractDataInWindow(raw, HistoryDepth, "BTC", window, 0, false)
+	if err != nil {
+		t.Fatal("It was not possible to extract the data. Error:", err)
+	}
+
+	expected := []string{"2023-06-11", "2023-06-04", "2023-05-28", "2023-05-21", "2023-05-14", "2023-05-07"}
+	if extracted != len(expected) || len(values) != len(expected) {
+		t.Fatal("Expected", len(expected), "values in the window, got", extracted, len(values))
+	}
+	for i, value := range values {
+		if value.date != expected[i] {
+			t.Log("Expected the date", expected[i], "got", value.date)
+			t.Fail()
+		}
+	}
+
+	// A window after the last refreshed date has nothing to extract.
+	window = ExtractWindow{Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if values, extracted, err := ExtractDataInWindow(raw, HistoryDepth, "BTC", window, 0, false); err != nil || extracted != 0 || len(values) != 0 {
+		t.Log("Expected no values after the last refreshed date, got", extracted, len(values), err)
+		t.Fail()
+	}
+}
+
+// Tests that a last refreshed date without time, e.g. "2023-07-05", is extracted from the Sunday before.
+func TestExtractDataFromValuesDateOnly(t *testing.T) {
+	response, err := os.ReadFile("datatest/date_only_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood {
+		t.Fatal("Unexpected status reading the fixture", status)
+	}
+
+	values, extracted, err := ExtractDataFromValues(raw, 3, "BTC", 0, false)
+	if err != nil {
+		t.Fatal("Unexpected error extracting the values:", err.Error())
+	}
+	if extracted != 3 || len(values) != 3 {
+		t.Fatalf("Expected 3 values, got %d and %d extracted", len(values), extracted)
+	}
+	if values[0].date != "2023-07-02" || values[0].value != 27637.87968400 {
+		t.Errorf("Expected the first value on 2023-07-02, got %+v", values[0])
+	}
+}
+
+// Tests that the extraction fails when too many values are missing, according to maxMissingRatio.
+func TestExtractDataFromValuesMaxMissingRatio(t *testing.T) {
+	response, err := os.ReadFile("datatest/half_missing_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood {
+		t.Fatal("Unexpected status reading the fixture", status)
+	}
+
+	// One every two weeks is missing in the last 10 weeks.
+	_, extracted, err := ExtractDataFromValues(raw, 10, "BTC", 0.4, false)
+	if _, ok := err.(DataError); !ok {
+		t.Log("Expected a DataError with half of the values missing and a ratio of 0.4, got", err)
+		t.Fail()
+	}
+	if extracted != 5 {
+		t.Log("Expected 5 extracted values, got", extracted)
+		t.Fail()
+	}
+
+	values, _, err := ExtractDataFromValues(raw, 10, "BTC", 0.6, false)
+	if err != nil {
+		t.Log("Unexpected error with a ratio of 0.6:", err.Error())
+		t.Fail()
+	}
+	if len(values) != 5 {
+		t.Log("Expected 5 values, got", len(values))
+		t.Fail()
+	}
+
+	_, _, err = ExtractDataFromValues(raw, 10, "BTC", 0, false)
+	if err != nil {
+		t.Log("A ratio of 0 should disable the check, got", err.Error())
+		t.Fail()
+	}
+}
+
+// Tests that a close value that isn't a number fails with a DataError naming the symbol, date and raw value.
+func TestExtractDataFromValuesMalformedClose(t *testing.T) {
+	response, err := os.ReadFile("datatest/malformed_close_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	raw, status := GetRawValuesFromResponse(response)
+	if status != StatusAllGood {
+		t.Fatal("Unexpected status reading the fixture", status)
+	}
+
+	_, _, err = ExtractDataFromValues(raw, 2, "BTC", 0, false)
+	var dataErr DataError
+	if !errors.As(err, &dataErr) {
+		t.Fatal("Expected a DataError for a non-numeric close value, got", err)
+	}
+	for _, want := range []string{"BTC", "2023-06-25", `"N/A"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %s, got %q", want, err.Error())
+		}
+	}
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Error("Expected the DataError to wrap the parsing error, got", err)
+	}
+}
+
+// Tests that the collector uses the extractor registered for its mode, and the
+// default one for the rest of modes.
+func TestRegisterExtractor(t *testing.T) {
+	t.Cleanup(func() {
+		delete(extractors, extractorKey{"TEST_MODE", DefaultAssetClass})
+		delete(supportedModes, "TEST_MODE")
+	})
+	var calls []string
+	RegisterExtractor("TEST_MODE", DefaultAssetClass, func(c Collector) ExtractDataFromValuesFunc {
+		return func(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDataCurated, int, error) {
+			calls = append(calls, symbol)
+			return []CryptoDataCurated{{symbol: symbol, date: "2023-06-25", value: 1}}, 1, nil
+		}
+	})
+	if err := validateMarketAndMode(DefaultMarket, "TEST_MODE"); err != nil {
+		t.Error("Expected the registered mode to be supported, got", err)
+	}
+
+	data, _, err := Collector{Mode: "TEST_MODE"}.GetExtractDataFromValuesFunc()(CryptoDataRaw{}, 2, "BTC")
+	if err != nil || len(data) != 1 || len(calls) != 1 || calls[0] != "BTC" {
+		t.Fatalf("Expected the registered extractor to be called for BTC, got %v, %v and the calls %v", data, err, calls)
+	}
+
+	response, err := os.ReadFile("datatest/sample_response.json")
+	if err != nil {
+		t.Fatal("Error while reading the json File:", err.Error())
+	}
+	raw, _ := GetRawValuesFromResponse(response)
+	if _, _, err := (Collector{Mode: DefaultMode}).GetExtractDataFromValuesFunc()(raw, 2, "ETH"); err != nil || len(calls) != 1 {
+		t.Errorf("Expected the default extractor for the default mode, got %v and the calls %v", err, calls)
+	}
+}
+
+// Tests that with SkipUnchanged a response identical to the last one stored is skipped.
+func TestRunSkipUnchanged(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.SkipUnchanged = true
+
+	first, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	second, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run again", err.Error())
+	}
+	if len(first.Succeeded) != 7 || second.Processed != 7 || len(second.Succeeded) != 0 {
+		t.Errorf("Expected the 7 symbols to be stored the first time only, got %v and %v", first.Succeeded, second.Succeeded)
+	}
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+	if LastContentHash(db, "BTC") == "" {
+		t.Error("Expected the content hash of BTC to be recorded")
+	}
+
+	tc.SkipUnchanged = false
+	third, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run without SkipUnchanged", err.Error())
+	}
+	if len(third.Succeeded) != 7 {
+		t.Errorf("Expected the 7 symbols to be stored without SkipUnchanged, got %v", third.Succeeded)
+	}
+}
+
+// Tests that a run refuses to start while another process holds the lock of the
+// database, unless forced, and takes over the stale locks.
+func TestRunLock(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.realData = true
+	lockPath := tc.DbFilePath + ".lock"
+	// The lock of a running process: this one.
+	if err := os.WriteFile(lockPath, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Run(tc, 10, false); !errors.Is(err, ErrLocked) {
+		t.Fatal("Expected the run to refuse to start with ErrLocked, got", err)
+	}
+	if _, err := RunGoRoutines(tc, 10, false, false); !errors.Is(err, ErrLocked) {
+		t.Fatal("Expected RunGoRoutines to refuse to start with ErrLocked, got", err)
+	}
+
+	tc.ForceLock = true
+	if _, err := Run(tc, 10, false); err != nil {
+		t.Fatal("Expected the run to take over the lock with ForceLock, got", err)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Error("Expected the lock to be released after the run, got", err)
+	}
+
+	tc.ForceLock = false
+	os.WriteFile(lockPath, []byte("not a pid"), 0644)
+	if _, err := Run(tc, 10, false); err != nil {
+		t.Fatal("Expected the run to take over a stale lock, got", err)
+	}
+}
+
+// Tests that symbols failing with a connection error are retried at the end of the run.
+func TestRunRetriesFailedSymbols(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.failures = map[string]int{"ETH": 1, "ADA": 2}
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 7 {
+		t.Log("Expected 7 processed symbols, got", result.Processed)
+		t.Fail()
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != "ADA" {
+		t.Log("Only ADA should remain as failed, got", result.Failed)
+		t.Fail()
+	}
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	var eth, ada int
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'ETH'").Scan(&eth)
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'ADA'").Scan(&ada)
+	if eth == 0 {
+		t.Log("ETH should have been stored after retrying it")
+		t.Fail()
+	}
+	if ada != 0 {
+		t.Log("ADA failed twice and should not have been stored")
+		t.Fail()
+	}
+}
+
+// Tests that an empty response is told apart from a broken one, and retried.
+func TestRunRetriesEmptyResponses(t *testing.T) {
+	for _, response := range []string{"", " \n\t"} {
+		if _, status := GetRawValuesFromResponse([]byte(response)); status != StatusEmptyResponse {
+			t.Logf("Expected an empty response for %q, got status %d", response, status)
+			t.Fail()
+		}
+	}
+	if _, status := GetRawValuesFromResponse([]byte("{")); status != StatusJsonBroken {
+		t.Log("Expected a broken response, got status", status)
+		t.Fail()
+	}
+
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.failures = map[string]int{"ETH": 1}
+	tc.empty = true
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if len(result.Failed) != 0 {
+		t.Log("ETH should have been retried after the empty response, failed", result.Failed)
+		t.Fail()
+	}
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+	var eth int
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'ETH'").Scan(&eth)
+	if eth == 0 {
+		t.Log("ETH should have been stored after retrying it")
+		t.Fail()
+	}
+}
+
+// Tests that a run is aborted once it reaches the maximum number of errors.
+func TestRunMaxErrors(t *testing.T) {
+	failures := map[string]int{}
+	for _, symbol := range []string{"BTC", "ADA", "AIR", "ETH", "SLR", "BAND", "BRD"} {
+		failures[symbol] = 100
+	}
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.failures = failures
+	tc.MaxErrors = 3
+
+	result, err := Run(tc, 10, false)
+	if !errors.Is(err, ErrTooManyErrors) {
+		t.Fatalf("Expected the run to abort with ErrTooManyErrors, got %v", err)
+	}
+	if result.Errors != 3 || result.Processed != 3 {
+		t.Errorf("Expected the run to abort after the third error, got %d errors and %d processed", result.Errors, result.Processed)
+	}
+	if failures["AIR"] != 99 || failures["ETH"] != 100 {
+		t.Errorf("Expected no request after the third symbol, got %v", failures)
+	}
+}
+
+// Tests that the summary of a run is written as JSON, with the symbols by outcome.
+func TestWriteRunSummary(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.responses = map[string]string{
+		"ADA": "datatest/half_missing_response.json",
+		"AIR": "datatest/non_symbol_response.json",
+		"SLR": "datatest/non_symbol_response.json",
+	}
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("unexpected error running the collector", err.Error())
+	}
+	summaryPath := filepath.Join(t.TempDir(), "summary.json")
+	if err := WriteRunSummary(summaryPath, result); err != nil {
+		t.Fatal("unable to write the summary", err.Error())
+	}
+
+	content, err := os.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary struct {
+		Processed       int      `json:"processed"`
+		Requests        int      `json:"requests"`
+		Succeeded       []string `json:"succeeded"`
+		Failed          []string `json:"failed"`
+		Blacklisted     []string `json:"blacklisted"`
+		Incomplete      []string `json:"incomplete"`
+		CompleteRatio   float64  `json:"complete_ratio"`
+		DurationSeconds *float64 `json:"duration_seconds"`
+	}
+	if err := json.Unmarshal(content, &summary); err != nil {
+		t.Fatalf("unable to unmarshal the summary %s: %v", content, err)
+	}
+	if summary.Processed != 7 || summary.Requests != 7 {
+		t.Errorf("Expected 7 symbols processed with 7 requests, got %d and %d", summary.Processed, summary.Requests)
+	}
+	if want := []string{"BTC", "ADA", "ETH", "BAND", "BRD"}; !reflect.DeepEqual(summary.Succeeded, want) {
+		t.Errorf("Expected the succeeded symbols %v, got %v", want, summary.Succeeded)
+	}
+	if want := []string{"ADA"}; !reflect.DeepEqual(summary.Incomplete, want) {
+		t.Errorf("Expected the incomplete symbols %v, got %v", want, summary.Incomplete)
+	}
+	if want := []string{"AIR", "SLR"}; !reflect.DeepEqual(summary.Blacklisted, want) {
+		t.Errorf("Expected the blacklisted symbols %v, got %v", want, summary.Blacklisted)
+	}
+	if summary.Failed == nil || len(summary.Failed) != 0 {
+		t.Errorf("Expected an empty list of failed symbols, got %v", summary.Failed)
+	}
+	if summary.CompleteRatio != 4.0/7 {
+		t.Errorf("Expected a complete ratio of 4/7, got %v", summary.CompleteRatio)
+	}
+	if summary.DurationSeconds == nil {
+		t.Error("Expected the duration of the run in the summary")
+	}
+}
+
+// Tests that with StrictComplete a symbol with incomplete data is blacklisted instead of stored.
+func TestRunStrictComplete(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		tc := newTestCollector(t)
+		tc.StrictComplete = strict
+		tc.realData = true
+		tc.responses = map[string]string{"ETH": "datatest/non_complete_response.json"}
+
+		result, err := Run(tc, 10, false)
+		if err != nil {
+			t.Fatal("unexpected error running the collector", err.Error())
+		}
+		db, err := tc.setUpDb("")
+		if err != nil {
+			t.Fatal("unable to setup the db", err.Error())
+		}
+		var reason sql.NullString
+		db.QueryRow("SELECT reason FROM blacklist WHERE symbol = 'ETH'").Scan(&reason)
+		db.Close()
+
+		if strict && (reason.String != "incomplete" || len(result.Blacklisted) != 1 || len(result.Succeeded) != 6) {
+			t.Errorf("Expected ETH to be blacklisted as incomplete and not stored, got the reason %q and %+v", reason.String, result)
+		}
+		if !strict && (reason.Valid || len(result.Incomplete) != 1 || len(result.Succeeded) != 7) {
+			t.Errorf("Expected ETH to be stored as incomplete without StrictComplete, got the reason %q and %+v", reason.String, result)
+		}
+	}
+}
+
+// Tests that RunEvery runs several passes, and stops cleanly once the context is done.
+func TestRunEvery(t *testing.T) {
+	tc := newTestCollector(t)
+
+	// Stop once the second pass has requested every symbol.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for tc.calls.requests() < 14 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	cycles, err := RunEvery(ctx, tc, 10*time.Millisecond, RunOptions{BatchSize: 10})
+	if err != nil {
+		t.Fatal("Expected the loop to stop without error, got", err)
+	}
+	if cycles < 2 {
+		t.Errorf("Expected at least 2 passes, got %d", cycles)
+	}
+
+	if _, err := RunEvery(context.Background(), tc, 0, RunOptions{}); err == nil {
+		t.Error("Expected an error for an interval of 0")
+	}
+}
+
+// Tests that the progress callback fires once per processed symbol, in both run modes.
+func TestRunOnProgress(t *testing.T) {
+	run := map[string]func(tc testCollector) error{
+		"sequential": func(tc testCollector) error { _, err := Run(tc, 10, false); return err },
+		"pool": func(tc testCollector) error {
+			tc.Concurrency = 3
+			_, err := Run(tc, 10, false)
+			return err
+		},
+		"goroutines": func(tc testCollector) error { _, err := RunGoRoutines(tc, 10, false, false); return err },
+	}
+	for name, runMode := range run {
+		t.Run(name, func(t *testing.T) {
+			tc := newTestCollector(t)
+			tc.realData = true
+			tc.failures = map[string]int{"ETH": 1}
+			var mu sync.Mutex
+			indices := make(map[string]int)
+			statuses := make(map[string][]ApiStatus)
+			tc.OnProgress = func(symbol string, index, total int, status ApiStatus) {
+				mu.Lock()
+				defer mu.Unlock()
+				if total != 7 {
+					t.Errorf("Expected a total of 7 symbols, got %d", total)
+				}
+				indices[symbol] = index
+				statuses[symbol] = append(statuses[symbol], status)
+			}
+
+			if err := runMode(tc); err != nil {
+				t.Fatal("there was a problem running the collector", err.Error())
+			}
+
+			want := map[string]int{"BTC": 1, "ADA": 2, "AIR": 3, "ETH": 4, "SLR": 5, "BAND": 6, "BRD": 7}
+			if !reflect.DeepEqual(indices, want) {
+				t.Errorf("Expected the indices %v, got %v", want, indices)
+			}
+			// ETH fails the first time, and is reported again when it's retried.
+			if !reflect.DeepEqual(statuses["ETH"], []ApiStatus{StatusRequestFailed, StatusAllGood}) || !reflect.DeepEqual(statuses["BTC"], []ApiStatus{StatusAllGood}) {
+				t.Errorf("Unexpected statuses: %v", statuses)
+			}
+		})
+	}
+}
+
+// Tests that symbols failing with an error not worth retrying are not retried.
+func TestRunSkipsNonRetryableFailures(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.failures = map[string]int{"ADA": 2}
+	tc.kind = ConnectionDNS
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != "ADA" {
+		t.Log("ADA should remain as failed, got", result.Failed)
+		t.Fail()
+	}
+	if tc.failures["ADA"] != 1 {
+		t.Log("ADA should have been requested only once, failures left", tc.failures["ADA"])
+		t.Fail()
+	}
+}
+
+// Tests that getData tells apart the kind of connection errors.
+func TestGetDataConnectionErrorKind(t *testing.T) {
+	// A server that is closed refuses the connections.
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	// A server that answers later than the timeout of the client.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	// A server whose certificate is not trusted.
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+
+	// Only the slow server gets a client with a short timeout, as the TLS handshake
+	// may take longer than that.
+	cases := []struct {
+		name      string
+		url       string
+		timeout   time.Duration
+		kind      ConnectionErrorKind
+		retryable bool
+	}{
+		{"refused", closed.URL, time.Minute, ConnectionRefused, true},
+		{"timeout", slow.URL, 50 * time.Millisecond, ConnectionTimeout, true},
+		{"tls", untrusted.URL, time.Minute, ConnectionTLS, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Timeout: tc.timeout}
+			_, err := getDataWith(client, http.Header{"User-Agent": {DefaultUserAgent}}, tc.url)
+			var connErr ConnectionError
+			if !errors.As(err, &connErr) {
+				t.Fatal("Expected a ConnectionError, got", err)
+			}
+			if connErr.Kind != tc.kind || connErr.Retryable() != tc.retryable {
+				t.Log("Expected kind", tc.kind, "got", connErr.Kind, "for", connErr.Msg)
+				t.Fail()
+			}
+		})
+	}
+}
+
+// Tests that the collector requests the API through the proxy, when one is set.
+func TestGetDataThroughProxy(t *testing.T) {
+	var requested []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A proxy receives the absolute URL of the resource.
+		requested = append(requested, r.URL.String())
+		fmt.Fprint(w, "from proxy")
+	}))
+	defer proxy.Close()
+
+	client, err := NewProxyClient(proxy.URL)
+	if err != nil {
+		t.Fatal("Unexpected error creating the proxy client", err)
+	}
+	c := Collector{HTTPClient: client}
+
+	data, err := c.GetGetDataFunc()("http://api.invalid/query?symbol=BTC")
+	if err != nil {
+		t.Fatal("Unexpected error requesting through the proxy", err)
+	}
+	if string(data) != "from proxy" || len(requested) != 1 || requested[0] != "http://api.invalid/query?symbol=BTC" {
+		t.Fatal("Expected the request to traverse the proxy, got", string(data), requested)
+	}
+
+	if _, err := NewProxyClient("socks5://localhost:1080"); err != nil {
+		t.Log("Expected a socks5 proxy to be valid, got", err)
+		t.Fail()
+	}
+	for _, invalid := range []string{"ftp://localhost:21", "localhost", ""} {
+		var dataErr DataError
+		if _, err := NewProxyClient(invalid); !errors.As(err, &dataErr) {
+			t.Log("Expected a DataError for the proxy", invalid, "got", err)
+			t.Fail()
+		}
+	}
+}
+
+// Tests that the requests carry the default User-Agent, or the one of the collector.
+func TestGetDataUserAgent(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("User-Agent"))
+	}))
+	defer server.Close()
+
+	for _, c := range []Collector{{}, {UserAgent: "custom-agent/2.0"}} {
+		if _, err := c.GetGetDataFunc()(server.URL); err != nil {
+			t.Fatal("Unexpected error requesting the server", err)
+		}
+	}
+	if len(received) != 2 || received[0] != DefaultUserAgent || received[1] != "custom-agent/2.0" {
+		t.Errorf("Expected the User-Agent headers %q and %q, got %q", DefaultUserAgent, "custom-agent/2.0", received)
+	}
+}
+
+// Tests that in header mode the API key is sent as a bearer token, and left out of the URL.
+func TestGetDataAuthHeader(t *testing.T) {
+	var authorization, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, query = r.Header.Get("Authorization"), r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	c := Collector{ApiUrl: server.URL + "/query?symbol=%s", ApiKey: "TESTKEY123456789", AuthMode: AuthModeHeader}
+	url := c.GetURLFromSymbol("BTC")
+	if strings.Contains(url, c.ApiKey) {
+		t.Errorf("Expected the API key to be absent from the URL, got %s", url)
+	}
+	if _, err := c.GetGetDataFunc()(url); err != nil {
+		t.Fatal("Unexpected error requesting the server", err)
+	}
+	if authorization != "Bearer TESTKEY123456789" || query != "symbol=BTC" {
+		t.Errorf("Expected the key in the Authorization header only, got %q and the query %q", authorization, query)
+	}
+
+	if err := ValidateAuthMode("cookie"); err == nil {
+		t.Error("Expected an error for an unknown auth mode")
+	}
+}
+
+// Tests that a throttled request waits as long as its Retry-After header tells,
+// and is requested again afterwards.
+func TestFetchSymbolRetryAfter(t *testing.T) {
+	sample, err := os.ReadFile("datatest/sample_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write(sample)
+	}))
+	defer server.Close()
+
+	c := Collector{ApiUrl: server.URL + "/query?symbol=%s&apikey=%s", ApiKey: "TESTKEY"}
+	start := time.Now()
+	result := fetchSymbol(context.Background(), c, nil, "BTC")
+	elapsed := time.Since(start)
+
+	if result.fetchErr != nil || result.status != StatusAllGood || requests != 2 {
+		t.Fatalf("Expected the request to succeed the second time, got %v and %d requests", result.fetchErr, requests)
+	}
+	if elapsed < 2*time.Second || elapsed > 4*time.Second {
+		t.Errorf("Expected to wait about 2s before requesting again, waited %v", elapsed)
+	}
+
+	date := time.Date(2023, 6, 25, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Sun, 25 Jun 2023 12:00:30 GMT": 30 * time.Second,
+		"Sun, 25 Jun 2023 11:00:00 GMT": 0,
+	} {
+		if wait, ok := parseRetryAfter(value, date); !ok || wait != expected {
+			t.Errorf("Expected Retry-After %q to wait %v, got %v", value, expected, wait)
+		}
+	}
+	if _, ok := parseRetryAfter("soon", date); ok {
+		t.Error("Expected an invalid Retry-After to be rejected")
+	}
+	if throttleWait(time.Hour) != MaxRetryAfter {
+		t.Errorf("Expected the wait to be capped at %v", MaxRetryAfter)
+	}
+}
+
+// Tests that the file store backends write a file per symbol instead of using the database.
+func TestRunFileStoreBackend(t *testing.T) {
+	for _, backend := range []string{StoreBackendCSV, StoreBackendJSON} {
+		tc := newTestCollector(t)
+		tc.realData = true
+		tc.StoreBackend = backend
+		tc.StoreDir = filepath.Join(t.TempDir(), "prices")
+
+		_, err := Run(tc, 10, false)
+		if err != nil {
+			t.Fatal("there was a problem running Run with the", backend, "backend", err.Error())
+		}
+
+		entries, err := os.ReadDir(tc.StoreDir)
+		if err != nil || len(entries) != 7 {
+			t.Fatal("Expected a file per symbol with the", backend, "backend, got", len(entries), err)
+		}
+
+		if backend == StoreBackendCSV {
+			file, err := os.Open(filepath.Join(tc.StoreDir, "BTC.csv"))
+			if err != nil {
+				t.Fatal("unable to open the CSV file", err.Error())
+			}
+			rows, err := csv.NewReader(file).ReadAll()
+			file.Close()
+			if err != nil || len(rows) < 2 || rows[0][0] != "symbol" || rows[1][0] != "BTC" {
+				t.Log("Unexpected content of the CSV file", rows, err)
+				t.Fail()
+			}
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(tc.StoreDir, "ETH.jsonl"))
+		if err != nil {
+			t.Fatal("unable to read the JSON file", err.Error())
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		var value storedValue
+		if err := json.Unmarshal([]byte(lines[0]), &value); err != nil || value.Symbol != "ETH" || value.Value == nil || *value.Value <= 0 {
+			t.Log("Unexpected content of the JSON file", lines[0], err)
+			t.Fail()
+		}
+
+		db, err := tc.setUpDb("")
+		if err != nil {
+			t.Fatal("unable to setup the db", err.Error())
+		}
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM crypto_prices").Scan(&count)
+		db.Close()
+		if count != 0 {
+			t.Log("Nothing should be stored in the database with the", backend, "backend")
+			t.Fail()
+		}
+	}
+}
+
+// Tests that running again with a file store backend doesn't append the values
+// already in the files.
+func TestRunFileStoreBackendRerun(t *testing.T) {
+	for backend, file := range map[string]string{StoreBackendCSV: "BTC.csv", StoreBackendJSON: "BTC.jsonl"} {
+		tc := newTestCollector(t)
+		tc.realData = true
+		tc.StoreBackend = backend
+		tc.StoreDir = filepath.Join(t.TempDir(), "prices")
+
+		if _, err := Run(tc, 10, false); err != nil {
+			t.Fatal("there was a problem running Run with the", backend, "backend", err.Error())
+		}
+		first, err := os.ReadFile(filepath.Join(tc.StoreDir, file))
+		if err != nil {
+			t.Fatal("unable to read the file of the", backend, "backend", err.Error())
+		}
+
+		if _, err := Run(tc, 10, false); err != nil {
+			t.Fatal("there was a problem running Run again with the", backend, "backend", err.Error())
+		}
+		second, err := os.ReadFile(filepath.Join(tc.StoreDir, file))
+		if err != nil {
+			t.Fatal("unable to read the file of the", backend, "backend", err.Error())
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("The file of the %s backend should not change when running again, got %d bytes from %d", backend, len(second), len(first))
+		}
+	}
+}
+
+// Tests that the year.week is stored along with the prices, when the table has the column.
+func TestStoreDataYearWeek(t *testing.T) {
+	db := newTestDb(t)
+	data := []CryptoDataCurated{{symbol: "BTC", date: "2023-01-01", value: 16500}}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+	var yearWeek string
+	db.QueryRow("SELECT year_week FROM crypto_prices WHERE symbol = 'BTC'").Scan(&yearWeek)
+	if yearWeek != "2023.52" {
+		t.Errorf("Expected the year.week 2023.52, as the exporter derives it, got %q", yearWeek)
+	}
+}
+
+// Tests that a duplicated date is stored only once, with its last value, in the
+// database and in the file backends.
+func TestStoreDataDeduplicates(t *testing.T) {
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-07-02", value: 1},
+		{symbol: "BTC", date: "2023-06-25", value: 29000},
+		{symbol: "BTC", date: "2023-07-02", value: 30000},
+	}
+
+	db := newTestDb(t)
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+	var count int
+	var value float64
+	db.QueryRow("SELECT COUNT(*), MAX(value) FROM crypto_prices WHERE symbol = 'BTC' AND timestamp = '2023-07-02'").Scan(&count, &value)
+	if count != 1 || value != 30000 {
+		t.Errorf("Expected a single value of 30000 in the database, got %d rows with %v", count, value)
+	}
+
+	dir := t.TempDir()
+	if err := NewFileStoreDataFunc(dir, StoreBackendCSV)(context.Background(), nil, data, ""); err != nil {
+		t.Fatal("It was not possible to store data in a file:", err)
+	}
+	file, err := os.Open(filepath.Join(dir, "BTC.csv"))
+	if err != nil {
+		t.Fatal("unable to open the CSV file", err.Error())
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal("unable to read the CSV file", err.Error())
+	}
+	want := [][]string{{"symbol", "timestamp", "value"}, {"BTC", "2023-07-02", "30000"}, {"BTC", "2023-06-25", "29000"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Expected %v in the CSV file, got %v", want, rows)
+	}
+}
+
+// Tests that the debug records of a run are only logged with the debug level.
+func TestSetUpLoggingDebug(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	for _, level := range []string{"debug", "info"} {
+		tc := newTestCollector(t)
+		db, err := tc.setUpDb("")
+		if err != nil {
+			t.Fatal("unable to setup the db", err.Error())
+		}
+		AddToBlacklist(db, "BTC", "")
+		db.Close()
+
+		var logs bytes.Buffer
+		if err := SetUpLogging(&logs, level); err != nil {
+			t.Fatal("unable to set up the logging", err.Error())
+		}
+		if _, err := Run(tc, 10, false); err != nil {
+			t.Fatal("there was a problem running Run", err.Error())
+		}
+
+		found := strings.Contains(logs.String(), "level=DEBUG msg=\"BTC is blacklisted. Skipping...\"")
+		if level == "debug" && !found {
+			t.Log("The debug record was not logged with the debug level:", logs.String())
+			t.Fail()
+		}
+		if level == "info" && found {
+			t.Log("The debug record should not be logged with the info level")
+			t.Fail()
+		}
+	}
+
+	if err := SetUpLogging(io.Discard, "loud"); err == nil {
+		t.Log("An invalid level should return an error")
+		t.Fail()
+	}
+}
+
+// Tests that symbols fetched within the refetch interval are not requested again.
+func TestRunSkipsRecentlyFetched(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.RefetchInterval = time.Hour
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	RecordFetch(db, "BTC", time.Now().Add(-time.Minute))
+	RecordFetch(db, "ETH", time.Now().Add(-2*time.Hour))
+	db.Close()
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 6 || tc.calls.requests() != 6 {
+		t.Log("Only BTC should have been skipped, processed", result.Processed, "requests", tc.calls.requests())
+		t.Fail()
+	}
+
+	// Now every symbol was fetched recently.
+	result, err = Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 0 {
+		t.Log("Every symbol should have been skipped, processed", result.Processed)
+		t.Fail()
+	}
+
+	// The same as with RunGoRoutines.
+	processed, err := RunGoRoutines(tc, 10, false, false)
+	if err != nil {
+		t.Fatal("there was a problem running RunGoRoutines", err.Error())
+	}
+	if processed != 0 || tc.calls.requests() != 6 {
+		t.Log("RunGoRoutines should have skipped every symbol, processed", processed, "requests", tc.calls.requests())
+		t.Fail()
+	}
+}
+
+// Tests that only the symbols stored, or missing in the API, count as fetched,
+// so the ones that failed are requested again within the refetch interval.
+func TestRunRecordsFetch(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.responses = map[string]string{"AIR": "datatest/non_symbol_response.json", "ADA": "datatest/empty_response.json"}
+	tc.limited = map[string]bool{"ETH": true}
+
+	if _, err := Run(tc, 10, false); err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+	for symbol, fetched := range map[string]bool{"BTC": true, "AIR": true, "ADA": false, "ETH": false} {
+		if FetchedWithin(db, symbol, time.Hour) != fetched {
+			t.Errorf("Expected %s to be fetched: %v", symbol, fetched)
+		}
+	}
+}
+
+// Tests that --print-url logs the URL of the symbol without the API key.
+func TestGetURLFromSymbolPrintURL(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	c := Collector{ApiUrl: ApiUrlTemplate(DefaultMode, DefaultMarket), ApiKey: "SECRETKEY1234567", PrintURL: true}
+
+	var logs bytes.Buffer
+	if err := SetUpLogging(&logs, "info"); err != nil {
+		t.Fatal("unable to set up the logging", err.Error())
+	}
+	url := c.GetURLFromSymbol("BTC")
+
+	if !strings.Contains(url, c.ApiKey) {
+		t.Log("The URL requested should still contain the key:", url)
+		t.Fail()
+	}
+	if !strings.Contains(logs.String(), "symbol=BTC") || !strings.Contains(logs.String(), "apikey=***") {
+		t.Log("The logged URL should contain the symbol and the redacted key:", logs.String())
+		t.Fail()
+	}
+	if strings.Contains(logs.String(), c.ApiKey) {
+		t.Log("The API key was leaked in the logs:", logs.String())
+		t.Fail()
+	}
+}
+
+// Tests that a directory as the database path returns a friendly FileSystemError.
+func TestSetUpDbDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Collector{DbFilePath: dir}
+	_, err := c.setUpDb("")
+	var fsErr FileSystemError
+	if !errors.As(err, &fsErr) || !strings.Contains(err.Error(), "is a directory") {
+		t.Log("Expected a FileSystemError about the directory, got", err)
+		t.Fail()
+	}
+
+	_, err = NewCollector(dir, "../apikey.txt", "", "../digital_currency_list.csv", false, "index.txt", DefaultMarket, DefaultMode)
+	if !errors.As(err, &fsErr) {
+		t.Log("NewCollector should reject a directory as the database path, got", err)
+		t.Fail()
+	}
+
+	mc, err := NewMockCollector(dir, "../apikey.txt", "", "../digital_currency_list.csv", filepath.Join(dir, "index.txt"))
+	if err != nil {
+		t.Fatal("unable to create collector", err.Error())
+	}
+	if _, err := Run(mc, 10, false); !errors.As(err, &fsErr) {
+		t.Log("Run should return the FileSystemError, got", err)
+		t.Fail()
+	}
+	if _, err := RunGoRoutines(mc, 10, false, false); !errors.As(err, &fsErr) {
+		t.Log("RunGoRoutines should return the FileSystemError, got", err)
+		t.Fail()
+	}
+	if _, err := OpenDb(dir); !errors.As(err, &fsErr) {
+		t.Log("OpenDb should reject a directory as the database path, got", err)
+		t.Fail()
+	}
+}
+
+// Tests that the summary has the ratio of symbols with complete data.
+func TestRunCompleteRatio(t *testing.T) {
+	tc := newTestCollector(t)
+
+	// Leave an even number of symbols, half of them incomplete.
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	AddToBlacklist(db, "BRD", "")
+	db.Close()
+
+	tc.realData = true
+	tc.responses = map[string]string{
+		"BTC": "datatest/non_complete_response.json",
+		"ADA": "datatest/non_complete_response.json",
+		"AIR": "datatest/non_complete_response.json",
+	}
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 6 || result.CompleteRatio != 0.5 {
+		t.Log("Expected 6 processed symbols and a ratio of 0.5, got", result.Processed, result.CompleteRatio)
+		t.Fail()
+	}
+}
+
+// Tests that symbols with the value of the current week are skipped.
+func TestRunSkipsCompleteSymbols(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2023, 7, 5, 12, 0, 0, 0, time.UTC) } // A Wednesday.
+
+	tc := newTestCollector(t)
+	tc.SkipComplete = true
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-07-02", value: 30000}, // The Sunday of the current week.
+		{symbol: "ETH", date: "2023-06-25", value: 1800},  // The previous week.
+	}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("unable to store the data", err.Error())
+	}
+	if !HasCurrentWeek(db, "BTC") || HasCurrentWeek(db, "ETH") || HasCurrentWeek(db, "ADA") {
+		t.Log("Only BTC has the value of the current week")
+		t.Fail()
+	}
+	db.Close()
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 6 || tc.calls.requests() != 6 {
+		t.Log("Only BTC should have been skipped, processed", result.Processed, "requests", tc.calls.requests())
+		t.Fail()
+	}
+}
+
+// Tests that only the symbols whose latest value predates StaleBefore are requested.
+func TestRunStaleBefore(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.StaleBefore = time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-07-02", value: 30000}, // After the date.
+		{symbol: "ETH", date: "2023-07-01", value: 1900},  // At the date.
+		{symbol: "ADA", date: "2023-06-25", value: 0.3},   // Before the date, so stale.
+	}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("unable to store the data", err.Error())
+	}
+	db.Close()
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 5 || tc.calls.requests() != 5 {
+		t.Log("Only BTC and ETH should have been skipped, processed", result.Processed, "requests", tc.calls.requests())
+		t.Fail()
+	}
+}
+
+// Tests that the symbols added to the currency list are processed after reloading it.
+func TestRunReloadsCurrencyList(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		listPath := filepath.Join(t.TempDir(), "currencies.csv")
+		if err := os.WriteFile(listPath, []byte("currency code,currency name\nBTC,Bitcoin\nETH,Ethereum\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tc := newTestCollector(t)
+		tc.CurrencyListFilePath = listPath
+		tc.Concurrency = concurrency
+		tc.reload = make(chan struct{}, 1)
+		tc.reloadedList = "currency code,currency name\nBTC,Bitcoin\nETH,Ethereum\nADA,Cardano\n"
+
+		result, err := Run(tc, 10, false)
+		if err != nil {
+			t.Fatal("there was a problem running Run", err.Error())
+		}
+		if result.Processed != 3 || tc.calls.requests() != 3 {
+			t.Log("Expected the new symbol to be processed with concurrency", concurrency, "processed", result.Processed, "requests", tc.calls.requests())
+			t.Fail()
+		}
+	}
+}
+
+// Tests that RunGoRoutines resumes from the right symbol after reaching the limit,
+// even if symbols were blacklisted before it.
+func TestRunGoRoutinesResume(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.responses = map[string]string{"AIR": "datatest/non_symbol_response.json"}
+	tc.limited = map[string]bool{"SLR": true, "BAND": true}
+
+	// Batches of 2: [BTC ADA] [AIR ETH] [SLR BAND] [BRD]. AIR gets blacklisted and
+	// the limit is reached in the third batch.
+	if _, err := RunGoRoutines(tc, 2, false, false); err != nil {
+		t.Fatal("there was a problem running RunGoRoutines", err.Error())
+	}
+	if tc.calls.fetched["BRD"] != 0 {
+		t.Fatal("The first run should have stopped before BRD")
+	}
+
+	tc.limited = map[string]bool{}
+	if _, err := RunGoRoutines(tc, 2, false, false); err != nil {
+		t.Fatal("there was a problem running RunGoRoutines", err.Error())
+	}
+
+	for _, symbol := range []string{"BTC", "ADA", "ETH", "SLR", "BAND", "BRD"} {
+		if tc.calls.fetched[symbol] != 1 {
+			t.Log("Expected", symbol, "to be fetched once, got", tc.calls.fetched[symbol])
+			t.Fail()
+		}
+	}
+}
+
+// Tests that RunGoRoutinesContext stops during the pause between batches once the
+// context is done, persisting the index of the next batch to resume from.
+func TestRunGoRoutinesContext(t *testing.T) {
+	tc := newTestCollector(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	processed, err := RunGoRoutinesContext(ctx, tc, 2, false, true)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected the deadline to stop the run, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("The run should have stopped during the pause, it took %v", elapsed)
+	}
+	if processed != 2 {
+		t.Errorf("Expected the first batch of 2 symbols to be processed, got %d", processed)
+	}
+	// The header, then BTC and ADA in the first batch.
+	if index, err := readIndexFromFile(tc.getIndexPath()); err != nil || index != 3 {
+		t.Errorf("Expected the index of the second batch to be persisted, got %d (%v)", index, err)
+	}
+}
+
+// Tests that RunGoRoutines requests every symbol once, whatever the last batch is:
+// the 7 symbols of the list fill exactly one batch of 7, leave one more for a batch
+// of 6, and one less than two batches of 4.
+func TestRunGoRoutinesBatchBoundaries(t *testing.T) {
+	for _, n := range []int{7, 6, 4, 1, 0} {
+		tc := newTestCollector(t)
+
+		processed, err := RunGoRoutines(tc, n, false, false)
+		if err != nil {
+			t.Fatal("there was a problem running RunGoRoutines with batches of", n, err.Error())
+		}
+		if processed != 7 {
+			t.Errorf("Expected 7 processed symbols with batches of %d, got %d", n, processed)
+		}
+		for _, symbol := range []string{"BTC", "ADA", "AIR", "ETH", "SLR", "BAND", "BRD"} {
+			if tc.calls.fetched[symbol] != 1 {
+				t.Errorf("Expected %s to be fetched once with batches of %d, got %d", symbol, n, tc.calls.fetched[symbol])
+			}
+		}
+		if index, err := readIndexFromFile(tc.getIndexPath()); err != nil || index != 0 {
+			t.Errorf("Expected the index to restart after batches of %d, got %d (%v)", n, index, err)
+		}
+	}
+}
+
+// Tests that RunGoRoutines keeps the same records of the symbols as Run: the fetch
+// log, the quality and the blacklist.
+func TestRunGoRoutinesRecords(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.realData = true
+	tc.responses = map[string]string{"AIR": "datatest/non_symbol_response.json", "ETH": "datatest/non_complete_response.json"}
+
+	if _, err := RunGoRoutines(tc, 3, false, false); err != nil {
+		t.Fatal("there was a problem running RunGoRoutines", err.Error())
+	}
+	db, err := tc.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	if !FetchedWithin(db, "BTC", time.Hour) || !IsBlacklisted(db, "AIR", "") {
+		t.Error("Expected BTC to be in the fetch log and AIR in the blacklist")
+	}
+	records, err := WorstQuality(db, 10)
+	if err != nil || len(records) != 1 || records[0].Symbol != "ETH" {
+		t.Errorf("Expected ETH to be recorded as incomplete, got %v (%v)", records, err)
+	}
+	if _, err := GetSymbolMeta(db, "BTC"); err != nil {
+		t.Error("Expected the metadata of BTC to be recorded, got", err)
+	}
+}
+
+// Tests that validating the currency list leaves out the symbols the API doesn't know.
+func TestValidateCurrencyList(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.responses = map[string]string{"AIR": "datatest/non_symbol_response.json", "SLR": "datatest/non_symbol_response.json"}
+	tc.limited = map[string]bool{"BRD": true}
+
+	outputPath := filepath.Join(t.TempDir(), "cleaned.csv")
+	validation, err := ValidateCurrencyList(context.Background(), tc, 10, outputPath)
+	if err != nil {
+		t.Fatal("unable to validate the currency list", err.Error())
+	}
+	if len(validation.Valid) != 4 || len(validation.Dead) != 2 || len(validation.Unchecked) != 1 {
+		t.Log("Unexpected validation", validation)
+		t.Fail()
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal("unable to open the cleaned list", err.Error())
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal("unable to read the cleaned list", err.Error())
+	}
+
+	expected := []string{"currency code", "BTC", "ADA", "ETH", "BAND", "BRD"}
+	if len(records) != len(expected) {
+		t.Fatal("Expected", len(expected), "rows in the cleaned list, got", len(records))
+	}
+	for i, symbol := range expected {
+		if records[i][0] != symbol {
+			t.Log("Expected symbol", symbol, "in row", i, "got", records[i][0])
+			t.Fail()
+		}
+	}
+}
+
+// Tests that the collector switches to the next API key when the limit is reached.
+func TestRunRotatesApiKeys(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.ApiKey = ""
+	if err := tc.AddApiKeys("FIRSTKEY12345678", "SECONDKEY1234567"); err != nil {
+		t.Fatal("unable to add the keys", err.Error())
+	}
+	tc.limited = map[string]bool{"FIRSTKEY12345678": true}
+
+	result, err := Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	if result.Processed != 7 || len(result.Failed) != 0 {
+		t.Log("Every symbol should have been requested with the second key, processed", result.Processed, "failed", result.Failed)
+		t.Fail()
+	}
+	if tc.calls.keys["FIRSTKEY12345678"] != 1 || tc.calls.keys["SECONDKEY1234567"] != 7 {
+		t.Log("Unexpected requests per key", tc.calls.keys)
+		t.Fail()
+	}
+
+	// With every key exhausted, the run finishes as with a single key.
+	tc.limited["SECONDKEY1234567"] = true
+	os.Remove(tc.getIndexPath())
+	result, err = Run(tc, 10, false)
+	if err != nil {
+		t.Fatal("there was a problem running Run", err.Error())
+	}
+	// The pool is still on the second key, and the first one is exhausted.
+	if result.Processed != 1 {
+		t.Log("The run should finish once both keys are exhausted, processed", result.Processed)
+		t.Fail()
+	}
+}
+
+// Tests that RunGoRoutines requests again the symbols that reached the limit of
+// an API key, once it switched to the next one.
+func TestRunGoRoutinesRotatesApiKeys(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.ApiKey = ""
+	if err := tc.AddApiKeys("FIRSTKEY12345678", "SECONDKEY1234567"); err != nil {
+		t.Fatal("unable to add the keys", err.Error())
+	}
+	tc.limited = map[string]bool{"FIRSTKEY12345678": true}
+
+	// BTC, and ADA if it's requested before switching keys, reach the limit of the first key.
+	if _, err := RunGoRoutines(tc, 2, false, false); err != nil {
+		t.Fatal("there was a problem running RunGoRoutines", err.Error())
+	}
+	for _, symbol := range []string{"BTC", "ADA", "AIR", "ETH", "SLR", "BAND", "BRD"} {
+		if tc.calls.fetched[symbol] != 1 {
+			t.Errorf("Expected %s to be fetched once, got %d", symbol, tc.calls.fetched[symbol])
+		}
+	}
+	if first := tc.calls.keys["FIRSTKEY12345678"]; first < 1 || first > 2 || tc.calls.keys["SECONDKEY1234567"] != 7 {
+		t.Error("Unexpected requests per key", tc.calls.keys)
+	}
+}
+
+// Tests that the retries of RunGoRoutines wait for the rate limit when the last batch
+// used it up, instead of sending more requests within the same minute.
+func TestRunGoRoutinesRetryWaits(t *testing.T) {
+	tc := newTestCollector(t)
+	tc.failures = map[string]int{"ETH": 1}
+
+	// A single batch with the 7 symbols, so the retry of ETH has to wait a minute.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := RunGoRoutinesContext(ctx, tc, 7, false, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected the retry to wait for the rate limit until the deadline, got", err)
+	}
+	if tc.calls.symbols["ETH"] != 1 {
+		t.Errorf("ETH should not have been retried within the same minute, requested %d times", tc.calls.symbols["ETH"])
+	}
+}
+
+// Tests that the missing values are stored as NULL, and filled once the API has them.
+func TestStoreDataNullForMissing(t *testing.T) {
+	c := Collector{DbFilePath: filepath.Join(t.TempDir(), "crypto.sqlite")}
+	db, err := c.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-07-02", value: 30000},
+		{symbol: "BTC", date: "2023-06-25", missing: true},
+	}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+
+	var value sql.NullFloat64
+	db.QueryRow("SELECT value FROM crypto_prices WHERE symbol = 'BTC' AND timestamp = '2023-06-25'").Scan(&value)
+	if value.Valid {
+		t.Log("The missing value should be stored as NULL, got", value.Float64)
+		t.Fail()
+	}
+
+	// A later run with the value fills the gap, but doesn't change the stored values.
+	data = []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-07-02", value: 1},
+		{symbol: "BTC", date: "2023-06-25", value: 29000},
+	}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+	var filled, kept float64
+	db.QueryRow("SELECT value FROM crypto_prices WHERE symbol = 'BTC' AND timestamp = '2023-06-25'").Scan(&filled)
+	db.QueryRow("SELECT value FROM crypto_prices WHERE symbol = 'BTC' AND timestamp = '2023-07-02'").Scan(&kept)
+	if filled != 29000 || kept != 30000 {
+		t.Log("Expected the gap to be filled and the value kept, got", filled, kept)
+		t.Fail()
+	}
+}
+
+// Tests that the NULL values of the missing weeks don't make a symbol up to date.
+func TestHasDataSinceNullForMissing(t *testing.T) {
+	db := newTestDb(t)
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-07-02", missing: true},
+		{symbol: "BTC", date: "2023-06-25", value: 30000},
+		{symbol: "BTC", date: "2023-06-18", value: 29000},
+	}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+
+	if HasDataSince(db, "BTC", time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Error("The NULL value of the current week should not count as data")
+	}
+	if !HasDataSince(db, "BTC", time.Date(2023, 6, 25, 0, 0, 0, 0, time.UTC)) {
+		t.Error("The older values should still count as data")
+	}
+}
+
+// Tests that the duplicates of a blacklist without the UNIQUE constraint are removed.
+func TestDedupeBlacklist(t *testing.T) {
+	db := newTestDb(t)
+	_, err := db.Exec("CREATE TABLE old_blacklist (id INTEGER PRIMARY KEY AUTOINCREMENT, symbol VARCHAR(255) NOT NULL)")
+	if err != nil {
+		t.Fatal("unable to create the table", err.Error())
+	}
+	for _, symbol := range []string{"SLR", "AIR", "SLR", "BRD", "SLR", "AIR"} {
+		if _, err := db.Exec("INSERT INTO old_blacklist(symbol) VALUES(?)", symbol); err != nil {
+			t.Fatal("unable to insert the symbol", err.Error())
+		}
+	}
+
+	removed, err := DedupeBlacklist(db, "old_blacklist")
+	if err != nil {
+		t.Fatal("unable to dedupe the blacklist", err.Error())
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 rows removed, got %d", removed)
+	}
+
+	rows, err := db.Query("SELECT id, symbol FROM old_blacklist ORDER BY id")
+	if err != nil {
+		t.Fatal("unable to read the table", err.Error())
+	}
+	defer rows.Close()
+	var kept []string
+	for rows.Next() {
+		var id int
+		var symbol string
+		rows.Scan(&id, &symbol)
+		kept = append(kept, fmt.Sprintf("%d:%s", id, symbol))
+	}
+	if want := []string{"1:SLR", "2:AIR", "4:BRD"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("Expected the earliest rows %v to be kept, got %v", want, kept)
+	}
+}
+
+// Tests that storing the same data twice reports the rows of the second call as duplicates,
+// and a missing value filled as new.
+func TestStoreDataCounted(t *testing.T) {
+	db := newTestDb(t)
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-06-25", value: 27000},
+		{symbol: "BTC", date: "2023-07-02", missing: true},
+		{symbol: "ETH", date: "2023-07-02", value: 1800},
+	}
+
+	count, err := StoreDataCounted(context.Background(), db, data, "")
+	if err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+	if count != (StoreCount{Attempted: 3, Inserted: 3}) || count.Duplicates() != 0 {
+		t.Errorf("Expected 3 new rows in the first store, got %+v", count)
+	}
+
+	count, err = StoreDataCounted(context.Background(), db, data, "")
+	if err != nil {
+		t.Fatal("It was not possible to store data again:", err)
+	}
+	if count.Inserted != 0 || count.Duplicates() != 3 {
+		t.Errorf("Expected 0 new rows and 3 duplicates in the second store, got %+v", count)
+	}
+
+	data[1] = CryptoDataCurated{symbol: "BTC", date: "2023-07-02", value: 28000}
+	count, err = StoreDataCounted(context.Background(), db, data, "")
+	if err != nil {
+		t.Fatal("It was not possible to store data again:", err)
+	}
+	if count != (StoreCount{Attempted: 3, Filled: 1}) || count.Duplicates() != 2 {
+		t.Errorf("Expected the missing value to be filled, got %+v", count)
+	}
+}
+
+// Tests that invalid curated entries are rejected before storing anything.
+func TestStoreDataInvalidCurated(t *testing.T) {
+	db := newTestDb(t)
+	for _, invalid := range []CryptoDataCurated{
+		{date: "2023-06-25", value: 27000},
+		{symbol: "BTC", date: "2023-06-25", value: math.NaN()},
+	} {
+		data := []CryptoDataCurated{{symbol: "ETH", date: "2023-06-25", value: 1800}, invalid}
+		err := StoreData(db, data, "")
+		var dataErr DataError
+		if !errors.As(err, &dataErr) || !strings.Contains(err.Error(), "entry 1") {
+			t.Errorf("Expected a DataError naming the entry 1 for %+v, got %v", invalid, err)
+		}
+	}
+
+	var rows int
+	db.QueryRow("SELECT COUNT(*) FROM crypto_prices").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("Expected no rows stored, got %d", rows)
+	}
+}
+
+// Tests that cancelling the context during a long store aborts it with a context error,
+// rolling back what was inserted.
+func TestStoreDataContextCancelled(t *testing.T) {
+	db := newTestDb(t)
+	data := make([]CryptoDataCurated, 200000)
+	for i := range data {
+		data[i] = CryptoDataCurated{symbol: fmt.Sprintf("SYM%d", i), date: "2023-07-02", value: float64(i)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	err := StoreDataContext(ctx, db, data, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected a context error, got", err)
+	}
+	var dbErr DbError
+	if !errors.As(err, &dbErr) {
+		t.Error("Expected a DbError, got", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crypto_prices").Scan(&count); err != nil {
+		t.Fatal("unable to count the rows", err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected the cancelled store to be rolled back, got %d rows", count)
+	}
+}
+
+// Tests that only the symbols that failed at least threshold times are blacklisted, with the reason.
+func TestBlacklistFromFailures(t *testing.T) {
+	db := newTestDb(t)
+	now := time.Now()
+	for _, symbol := range []string{"SLR", "AIR", "SLR", "SLR"} {
+		if err := RecordFailure(db, symbol, "empty response", now); err != nil {
+			t.Fatal("unable to record the failure", err.Error())
+		}
+	}
+
+	added, err := BlacklistFromFailures(db, 3)
+	if err != nil {
+		t.Fatal("unable to blacklist from the failures", err.Error())
+	}
+	if added != 1 {
+		t.Errorf("Expected 1 symbol blacklisted, got %d", added)
+	}
+	if !IsBlacklisted(db, "SLR", "") || IsBlacklisted(db, "AIR", "") {
+		t.Error("Expected only SLR to be blacklisted")
+	}
+	var reason string
+	if err := db.QueryRow("SELECT reason FROM blacklist WHERE symbol = 'SLR'").Scan(&reason); err != nil {
+		t.Fatal("unable to read the reason", err.Error())
+	}
+	if reason != "failed 3 times" {
+		t.Errorf("Expected the reason %q, got %q", "failed 3 times", reason)
+	}
+
+	if _, err := BlacklistFromFailures(db, 0); err == nil {
+		t.Error("Expected an error for a threshold of 0")
+	}
+}
+
+// Tests that the blacklist is exported in JSON and CSV.
+func TestExportBlacklist(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestDb(t)
+	AddToBlacklist(db, "SLR", "")
+	AddToBlacklist(db, "AIR", "")
+
+	jsonPath := filepath.Join(dir, "blacklist.json")
+	if err := ExportBlacklist(db, jsonPath, BlacklistFormatJSON); err != nil {
+		t.Fatal("unable to export the blacklist", err.Error())
+	}
+	content, _ := os.ReadFile(jsonPath)
+	var entries []BlacklistEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		t.Fatal("unable to read the exported JSON", err.Error())
+	}
+	if len(entries) != 2 || entries[0].Symbol != "AIR" || entries[1].Symbol != "SLR" {
+		t.Log("Unexpected JSON entries", entries)
+		t.Fail()
+	}
+
+	csvPath := filepath.Join(dir, "blacklist.csv")
+	if err := ExportBlacklist(db, csvPath, BlacklistFormatCSV); err != nil {
+		t.Fatal("unable to export the blacklist", err.Error())
+	}
+	content, _ = os.ReadFile(csvPath)
+	if string(content) != "symbol\nAIR\nSLR\n" {
+		t.Log("Unexpected CSV content", string(content))
+		t.Fail()
+	}
+
+	if err := ExportBlacklist(db, csvPath, "xml"); err == nil {
+		t.Log("An unknown format should return an error")
+		t.Fail()
+	}
+}
+
+// Tests that a store error stops the run, unless ContinueOnDbError is set.
+func TestRunStoreError(t *testing.T) {
+	for _, continueOnDbError := range []bool{false, true} {
+		tc := newTestCollector(t)
+		tc.ContinueOnDbError = continueOnDbError
+		tc.storeErr = DbError{Msg: "database disk image is malformed"}
+
+		_, err := Run(tc, 10, false)
+		var dbErr DbError
+		if !continueOnDbError && (!errors.As(err, &dbErr) || tc.calls.requests() != 1) {
+			t.Log("The run should stop with the DbError after the first symbol, got", err, "requests", tc.calls.requests())
+			t.Fail()
+		}
+		if continueOnDbError && (err != nil || tc.calls.requests() != 7) {
+			t.Log("The run should process every symbol, got", err, "requests", tc.calls.requests())
+			t.Fail()
+		}
+	}
+}
+
+// Tests that the API keys file is read once, however many times the keys are loaded.
+func TestLoadApiKeysOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikey.txt")
+	if err := os.WriteFile(path, []byte("CACHEDKEY1234567\n"), 0644); err != nil {
+		t.Fatal("unable to write the key", err.Error())
+	}
+
+	reads := 0
+	defer func() { readFile = os.ReadFile }()
+	readFile = func(name string) ([]byte, error) {
+		reads++
+		return os.ReadFile(name)
+	}
+
+	// As the root command does before running the collector.
+	if _, err := LoadApiKeys(path); err != nil {
+		t.Fatal("unable to load the keys", err.Error())
+	}
+	c, err := NewCollector(filepath.Join(t.TempDir(), "crypto.sqlite"), path, "", "../digital_currency_list.csv", false, "index.txt", DefaultMarket, DefaultMode)
+	if err != nil {
+		t.Fatal("unable to create collector", err.Error())
+	}
+
+	if reads != 1 || c.ApiKey != "CACHEDKEY1234567" {
+		t.Log("Expected the key file to be read once, got", reads, "reads and key", c.ApiKey)
+		t.Fail()
+	}
+
+	// Invalid keys are not cached, so a fixed file is read again.
+	bad := filepath.Join(t.TempDir(), "bad.txt")
+	os.WriteFile(bad, []byte("short"), 0644)
+	if _, err := LoadApiKeys(bad); err == nil {
+		t.Log("An invalid key should return an error")
+		t.Fail()
+	}
+	os.WriteFile(bad, []byte("FIXEDKEY12345678"), 0644)
+	if _, err := LoadApiKeys(bad); err != nil {
+		t.Log("The fixed key should be loaded, got", err)
+		t.Fail()
+	}
+}
+
+// Tests that the API keys are loaded again once their file changes.
+func TestLoadApiKeysChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikey.txt")
+	if err := os.WriteFile(path, []byte("FIRSTKEY12345678\n"), 0644); err != nil {
+		t.Fatal("unable to write the key", err.Error())
+	}
+	if keys, err := LoadApiKeys(path); err != nil || keys[0] != "FIRSTKEY12345678" {
+		t.Fatal("unable to load the first key", keys, err)
+	}
+
+	// The same size, only the modification time tells it changed.
+	if err := os.WriteFile(path, []byte("SECONDKEY1234567\n"), 0644); err != nil {
+		t.Fatal("unable to write the key", err.Error())
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal("unable to change the modification time", err.Error())
+	}
+	if keys, err := LoadApiKeys(path); err != nil || keys[0] != "SECONDKEY1234567" {
+		t.Error("Expected the key of the changed file, got", keys, err)
+	}
+
+	// A removed file is not served from the cache.
+	os.Remove(path)
+	if _, err := LoadApiKeys(path); err == nil {
+		t.Error("Expected an error once the file is removed")
+	}
+}
+
+// Tests that the latest price of a symbol is the most recent stored value.
+func TestLatestPrice(t *testing.T) {
+	c := Collector{DbFilePath: filepath.Join(t.TempDir(), "crypto.sqlite")}
+	db, err := c.setUpDb("")
+	if err != nil {
+		t.Fatal("unable to setup the db", err.Error())
+	}
+	defer db.Close()
+
+	data := []CryptoDataCurated{
+		{symbol: "BTC", date: "2023-06-25", value: 29000},
+		{symbol: "BTC", date: "2023-07-09", missing: true},
+		{symbol: "BTC", date: "2023-07-02", value: 30000},
+		{symbol: "ETH", date: "2023-07-09", value: 1800},
+	}
+	if err := StoreData(db, data, ""); err != nil {
+		t.Fatal("It was not possible to store data:", err)
+	}
+
+	date, value, err := LatestPrice(db, "BTC")
+	if err != nil || date != "2023-07-02" || value != 30000 {
+		t.Log("Unexpected latest price of BTC", date, value, err)
+		t.Fail()
+	}
+
+	_, _, err = LatestPrice(db, "ADA")
+	var dataErr DataError
+	if !errors.As(err, &dataErr) {
+		t.Log("Expected a DataError for a symbol without prices, got", err)
+		t.Fail()
+	}
+}
+
+// Tests that Compact shrinks a database with free pages, and refuses to run while it's in use.
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crypto.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal("unable to open the db", err.Error())
+	}
+	defer db.Close()
+	if _, err := Migrate(db); err != nil {
+		t.Fatal("unable to migrate the db", err.Error())
+	}
+
+	tx, _ := db.Begin()
+	for i := 0; i < 5000; i++ {
+		tx.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES (?, ?, ?)", fmt.Sprintf("S%d", i), "2023-07-02", float64(i))
+	}
+	tx.Commit()
+	db.Exec("DELETE FROM crypto_prices")
+
+	result, err := Compact(path)
+	if err != nil {
+		t.Fatal("unable to compact the db", err.Error())
+	}
+	if result.SizeBefore == 0 || result.SizeAfter >= result.SizeBefore {
+		t.Log("Expected the file to shrink, got", result)
+		t.Fail()
+	}
+
+	// A pending write, like the ones of a collection, keeps the database busy.
+	tx, _ = db.Begin()
+	tx.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES ('BTC', '2023-07-02', 1)")
+	defer tx.Rollback()
+	var dbErr DbError
+	if _, err := Compact(path); !errors.As(err, &dbErr) {
+		t.Log("Expected a DbError compacting a database in use, got", err)
+		t.Fail()
+	}
+
+	// The lock of a running collection keeps it from compacting the database.
+	if err := os.WriteFile(path+".lock", []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compact(path); !errors.Is(err, ErrLocked) {
+		t.Log("Expected ErrLocked compacting a locked database, got", err)
+		t.Fail()
+	}
+
+	var fsErr FileSystemError
+	if _, err := Compact(t.TempDir()); !errors.As(err, &fsErr) {
+		t.Log("Expected a FileSystemError compacting a directory, got", err)
+		t.Fail()
+	}
+}
+
+func TestProbe(t *testing.T) {
+	sample, err := os.ReadFile("datatest/sample_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, err := os.ReadFile("datatest/limit_achieved_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const perMinute = 3
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case requests <= perMinute:
+			w.Write(sample)
+		case requests == perMinute+1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case requests <= 2*perMinute+1:
+			w.Write(sample)
+		default:
+			w.Write(limit)
+		}
+	}))
+	defer server.Close()
+
+	c := Collector{ApiUrl: server.URL + "/query?symbol=%s&apikey=%s", ApiKey: "TESTKEY"}
+	result, err := Probe(context.Background(), c, "BTC", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ProbeResult{PerMinute: perMinute, PerDay: 2 * perMinute, Requests: 2*perMinute + 2}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+
+	// The probe stops at the cap of requests.
+	requests = 0
+	result, err = Probe(context.Background(), c, "BTC", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != 2 || requests != 2 || result.PerMinute != 0 || result.PerDay != 0 {
+		t.Errorf("Expected to stop after 2 requests without limits, got %+v and %d requests", result, requests)
+	}
+}
+
+// Tests that Probe tells the message of the limit per minute, answered with HTTP 200
+// as Alpha Vantage does, apart from the daily limit.
+func TestProbeThrottleMessage(t *testing.T) {
+	sample, err := os.ReadFile("datatest/sample_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttle, err := os.ReadFile("datatest/throttled_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, err := os.ReadFile("datatest/limit_achieved_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status := GetRawValuesFromResponse(throttle); status != StatusThrottled {
+		t.Fatal("Expected the throttle message to be StatusThrottled, got", status)
+	}
+
+	defer func(wait time.Duration) { throttleMessageWait = wait }(throttleMessageWait)
+	throttleMessageWait = time.Millisecond
+
+	const perMinute = 5
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case requests <= perMinute:
+			w.Write(sample)
+		case requests == perMinute+1:
+			w.Write(throttle)
+		case requests <= 2*perMinute+1:
+			w.Write(sample)
+		default:
+			w.Write(limit)
+		}
+	}))
+	defer server.Close()
+
+	c := Collector{ApiUrl: server.URL + "/query?symbol=%s&apikey=%s", ApiKey: "TESTKEY"}
+	result, err := Probe(context.Background(), c, "BTC", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ProbeResult{PerMinute: perMinute, PerDay: 2 * perMinute, Requests: 2*perMinute + 2}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+}
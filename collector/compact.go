@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+)
+
+// Size of the database file before and after compacting it, in bytes.
+type CompactResult struct {
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// Compacts the database file at path, releasing its free pages with VACUUM and
+// updating the statistics of the query planner with PRAGMA optimize.
+// It holds the lock of the database like a collection, so it fails with ErrLocked
+// while one is running. VACUUM needs the database for itself, so it fails right away
+// with a DbError instead of waiting when any other connection is using it.
+func Compact(path string) (CompactResult, error) {
+	var result CompactResult
+
+	if err := checkDbFilePath(path); err != nil {
+		return result, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return result, FileSystemError{Msg: fmt.Sprintf("Unable to access the database file %s: %s", path, err.Error())}
+	}
+	result.SizeBefore = info.Size()
+
+	release, err := acquireLock(path+".lock", false)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	db, err := OpenDb(path)
+	if err != nil {
+		return result, err
+	}
+	defer db.Close()
+	// The busy timeout is set per connection, so a single one is kept.
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{"PRAGMA busy_timeout = 0", "VACUUM", "PRAGMA optimize"} {
+		if _, err := db.Exec(statement); err != nil {
+			if isDatabaseLocked(err) {
+				return result, DbError{Msg: "The database is in use, wait until the collection finishes to compact it"}
+			}
+			return result, DbError{Msg: fmt.Sprintf("Failed to run %s: %s", statement, err.Error())}
+		}
+	}
+	if err := db.Close(); err != nil {
+		return result, DbError{Msg: "Failed to close the database: " + err.Error()}
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		return result, FileSystemError{Msg: fmt.Sprintf("Unable to access the database file %s: %s", path, err.Error())}
+	}
+	result.SizeAfter = info.Size()
+	return result, nil
+}
@@ -1,5 +1,14 @@
 package collector
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
 // Default error struct, which other erros will reuse.
 // type DefaultError struct {
 // 	Msg string
@@ -9,9 +18,20 @@ package collector
 // 	return e.Msg
 // }
 
+// Reason of a run that stopped because the daily limit of the API was reached.
+var ErrDailyLimitReached = errors.New("the daily limit of the API was reached")
+
+// Error of a run aborted because too many symbols failed, see Collector.MaxErrors.
+var ErrTooManyErrors = errors.New("too many errors during the run")
+
+// Error of a run refused because another one holds the lock of the database, see Collector.ForceLock.
+var ErrLocked = errors.New("another collector is running on the database")
+
 // Error related to a problem connecting to the API, or reading the response.
 type ConnectionError struct {
 	Msg string
+	// What kind of problem it was, ConnectionOther when unknown.
+	Kind ConnectionErrorKind
 	// DefaultError
 }
 
@@ -19,16 +39,100 @@ func (e ConnectionError) Error() string {
 	return e.Msg
 }
 
+// Tells if the request is worth repeating. DNS and TLS problems won't go
+// away by themselves, the rest of them may.
+func (e ConnectionError) Retryable() bool {
+	return e.Kind != ConnectionDNS && e.Kind != ConnectionTLS
+}
+
+// Error of a request rejected by the API for being over its rate limit (HTTP 429).
+type ThrottledError struct {
+	Msg string
+	// How long to wait before the next request, from the Retry-After header.
+	// 0 when the API didn't tell.
+	RetryAfter time.Duration
+}
+
+func (e ThrottledError) Error() string {
+	return e.Msg
+}
+
+// Category of a ConnectionError.
+type ConnectionErrorKind int
+
+const (
+	ConnectionOther ConnectionErrorKind = iota
+	ConnectionTimeout
+	ConnectionDNS
+	ConnectionRefused
+	ConnectionTLS
+)
+
+func (k ConnectionErrorKind) String() string {
+	switch k {
+	case ConnectionTimeout:
+		return "timeout"
+	case ConnectionDNS:
+		return "dns"
+	case ConnectionRefused:
+		return "refused"
+	case ConnectionTLS:
+		return "tls"
+	}
+	return "other"
+}
+
+// Tells the category of an error returned by the HTTP client.
+func classifyConnectionError(err error) ConnectionErrorKind {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ConnectionTimeout
+		}
+		return ConnectionDNS
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ConnectionRefused
+	}
+
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &certErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return ConnectionTLS
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ConnectionTimeout
+	}
+
+	return ConnectionOther
+}
+
 // Error related to the data received, like it's in wrong format or contains an error.
 type DataError struct {
 	// DefaultError
 	Msg string
+	// The underlying error, if any.
+	Err error
 }
 
 func (e DataError) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
 	return e.Msg
 }
 
+func (e DataError) Unwrap() error {
+	return e.Err
+}
+
 // Error related to the file system, like not able to find a file or read from it.
 type FileSystemError struct {
 	Msg string
@@ -41,8 +145,17 @@ func (e FileSystemError) Error() string {
 // Error related to the database.
 type DbError struct {
 	Msg string
+	// The underlying error, if any, e.g. the context of a cancelled query.
+	Err error
 }
 
 func (e DbError) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
 	return e.Msg
 }
+
+func (e DbError) Unwrap() error {
+	return e.Err
+}
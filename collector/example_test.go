@@ -0,0 +1,41 @@
+package collector_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/agviu/investrends/collector"
+)
+
+// Collects the prices of the currency list, five symbols per minute.
+func ExampleRun() {
+	c, err := collector.NewCollector("crypto.sqlite", "apikey.txt", collector.ApiUrlTemplate(collector.DefaultMode, collector.DefaultMarket),
+		"digital_currency_list.csv", false, "index.txt", collector.DefaultMarket, collector.DefaultMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	result, err := collector.Run(c, collector.DefaultBatchSize, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Processed", result.Processed, "symbols")
+}
+
+// Collects the prices in USD for at most 50 minutes, starting with an empty blacklist.
+func ExampleRunWithOptions() {
+	c, err := collector.NewCollectorWithOptions(collector.CollectorOptions{Market: "USD"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Minute)
+	defer cancel()
+	result, err := collector.RunWithOptions(ctx, c, collector.RunOptions{ClearBlacklist: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Processed", result.Processed, "symbols")
+}
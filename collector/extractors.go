@@ -0,0 +1,49 @@
+package collector
+
+// Builds the function extracting the curated data from the responses, for the
+// configuration of a collector.
+type ExtractorFactory func(c Collector) ExtractDataFromValuesFunc
+
+// Mode (API function) and asset class an extractor is registered for.
+type extractorKey struct {
+	mode       string
+	assetClass string
+}
+
+// The extractors of every mode and asset class, see RegisterExtractor.
+var extractors = map[extractorKey]ExtractorFactory{
+	{DefaultMode, DefaultAssetClass}: defaultExtractor,
+}
+
+// Extracts the weekly prices of the digital currencies, the format of DefaultMode.
+func defaultExtractor(c Collector) ExtractDataFromValuesFunc {
+	return func(cdr CryptoDataRaw, n int, symbol string) ([]CryptoDataCurated, int, error) {
+		return ExtractDataInWindow(cdr, n, symbol, c.BackfillWindow, c.MaxMissingRatio, c.StoreNullForMissing)
+	}
+}
+
+// Registers the extractor of the responses of a mode and an asset class, replacing the
+// previous one, if any. Both become supported by the collector.
+// It's meant to be called at start up, before any collector is created.
+func RegisterExtractor(mode string, assetClass string, factory ExtractorFactory) {
+	extractors[extractorKey{mode, assetClass}] = factory
+	supportedModes[mode] = true
+	supportedAssetClasses[assetClass] = true
+}
+
+// Returns the extractor registered for the mode and the asset class of the
+// collector, the default one when there is none.
+func (c Collector) extractor() ExtractDataFromValuesFunc {
+	key := extractorKey{c.Mode, c.AssetClass}
+	if key.mode == "" {
+		key.mode = DefaultMode
+	}
+	if key.assetClass == "" {
+		key.assetClass = DefaultAssetClass
+	}
+	factory, ok := extractors[key]
+	if !ok {
+		factory = defaultExtractor
+	}
+	return factory(c)
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// Records that a symbol was requested to the API at the given time.
+func RecordFetch(db *sql.DB, symbol string, fetchedAt time.Time) error {
+	// The hash of the content is kept.
+	_, err := db.Exec("INSERT INTO fetch_log (symbol, fetched_at) VALUES (?, ?) ON CONFLICT(symbol) DO UPDATE SET fetched_at = excluded.fetched_at", symbol, fetchedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return DbError{Msg: "Failed to record the fetch of " + symbol + ": " + err.Error()}
+	}
+	return nil
+}
+
+// Tells if a symbol was requested to the API within the given interval.
+// Symbols never fetched, or with an unreadable log, are not.
+func FetchedWithin(db *sql.DB, symbol string, interval time.Duration) bool {
+	var fetchedAt string
+	err := db.QueryRow("SELECT fetched_at FROM fetch_log WHERE symbol = ?", symbol).Scan(&fetchedAt)
+	if err != nil {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339, fetchedAt)
+	if err != nil {
+		return false
+	}
+	return time.Since(t) < interval
+}
+
+// Returns the hash of the body of a response, to tell if it changed.
+func contentHash(response []byte) string {
+	sum := sha256.Sum256(response)
+	return hex.EncodeToString(sum[:])
+}
+
+// Records the hash of the last response of a symbol whose data was stored.
+// The symbol must be in the fetch log already, see RecordFetch.
+func RecordContentHash(db *sql.DB, symbol string, hash string) error {
+	_, err := db.Exec("UPDATE fetch_log SET content_hash = ? WHERE symbol = ?", hash, symbol)
+	if err != nil {
+		return DbError{Msg: "Failed to record the content hash of " + symbol, Err: err}
+	}
+	return nil
+}
+
+// Returns the hash of the last response of a symbol whose data was stored, empty
+// when there is none or it can't be read.
+func LastContentHash(db *sql.DB, symbol string) string {
+	var hash sql.NullString
+	db.QueryRow("SELECT content_hash FROM fetch_log WHERE symbol = ?", symbol).Scan(&hash)
+	return hash.String
+}
+
+// Records that the request of a symbol failed at the given time, and why.
+// Unlike the fetch log, every failure is kept, see BlacklistFromFailures.
+func RecordFailure(db *sql.DB, symbol string, reason string, failedAt time.Time) error {
+	_, err := db.Exec("INSERT INTO fetch_failures (symbol, failed_at, reason) VALUES (?, ?, ?)", symbol, failedAt.UTC().Format(time.RFC3339), reason)
+	if err != nil {
+		return DbError{Msg: "Failed to record the failure of " + symbol + ": " + err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Backends where the curated data can be stored.
+const (
+	StoreBackendSqlite = "sqlite" // The crypto_prices table of the database, the default.
+	StoreBackendCSV    = "csv"    // A CSV file per symbol.
+	StoreBackendJSON   = "json"   // A JSON Lines file per symbol.
+	StoreBackendMemory = "memory" // An in-memory database, see OpenMemoryDb.
+)
+
+// Checks that the store backend is one of the supported ones.
+func ValidateStoreBackend(backend string) error {
+	switch backend {
+	case "", StoreBackendSqlite, StoreBackendCSV, StoreBackendJSON, StoreBackendMemory:
+		return nil
+	}
+	return DataError{Msg: fmt.Sprintf("invalid store backend %q, valid options are: %s, %s, %s, %s", backend, StoreBackendSqlite, StoreBackendCSV, StoreBackendJSON, StoreBackendMemory)}
+}
+
+// Returns a StoreDataFunc that appends the curated data to a file per symbol in dir,
+// instead of storing it in the database. The format is either StoreBackendCSV or
+// StoreBackendJSON. The database and the table name are ignored, although the run
+// still keeps the blacklist and the rest of its records in the database.
+// The values whose date is already in the file are not appended again, the same as
+// the stored values are kept in the database.
+func NewFileStoreDataFunc(dir string, format string) StoreDataFunc {
+	return func(ctx context.Context, db *sql.DB, data []CryptoDataCurated, tableName string) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return FileSystemError{Msg: "Unable to create the store directory: " + err.Error()}
+		}
+
+		// Group the data per symbol, keeping the order.
+		var symbols []string
+		bySymbol := make(map[string][]CryptoDataCurated)
+		for _, curated := range dedupeCuratedData(data) {
+			if _, ok := bySymbol[curated.symbol]; !ok {
+				symbols = append(symbols, curated.symbol)
+			}
+			bySymbol[curated.symbol] = append(bySymbol[curated.symbol], curated)
+		}
+
+		for _, symbol := range symbols {
+			var err error
+			if format == StoreBackendJSON {
+				err = appendJSONLines(filepath.Join(dir, filepath.Base(symbol)+".jsonl"), bySymbol[symbol])
+			} else {
+				err = appendCSV(filepath.Join(dir, filepath.Base(symbol)+".csv"), bySymbol[symbol])
+			}
+			if err != nil {
+				return FileSystemError{Msg: "Unable to store the data of " + symbol + ": " + err.Error()}
+			}
+		}
+		return nil
+	}
+}
+
+// Appends the data to a CSV file, writing the header if the file is new. The values
+// whose date is already in the file are left out.
+func appendCSV(path string, data []CryptoDataCurated) error {
+	info, err := os.Stat(path)
+	newFile := os.IsNotExist(err) || (err == nil && info.Size() == 0)
+
+	stored, err := readStoredCSVDates(path)
+	if err != nil {
+		return err
+	}
+	data = withoutDates(data, stored)
+	if len(data) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if newFile {
+		writer.Write([]string{"symbol", "timestamp", "value"})
+	}
+	for _, curated := range data {
+		value := "" // Missing values are left empty.
+		if !curated.missing {
+			value = strconv.FormatFloat(curated.value, 'f', -1, 64)
+		}
+		writer.Write([]string{curated.symbol, curated.date, value})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// A line of the JSON Lines files.
+type storedValue struct {
+	Symbol    string   `json:"symbol"`
+	Timestamp string   `json:"timestamp"`
+	Value     *float64 `json:"value"` // null for the missing values.
+}
+
+// Appends the data to a JSON Lines file, one object per value. The values whose
+// date is already in the file are left out.
+func appendJSONLines(path string, data []CryptoDataCurated) error {
+	stored, err := readStoredJSONLinesDates(path)
+	if err != nil {
+		return err
+	}
+	data = withoutDates(data, stored)
+	if len(data) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, curated := range data {
+		line := storedValue{Symbol: curated.symbol, Timestamp: curated.date}
+		if !curated.missing {
+			value := curated.value
+			line.Value = &value
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// Returns the dates of the values stored in a CSV file, none if it doesn't exist.
+func readStoredCSVDates(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]bool, len(records))
+	for i, record := range records {
+		// The first row is the header.
+		if i == 0 || len(record) < 2 {
+			continue
+		}
+		dates[record[1]] = true
+	}
+	return dates, nil
+}
+
+// Returns the dates of the values stored in a JSON Lines file, none if it doesn't exist.
+func readStoredJSONLinesDates(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dates := make(map[string]bool)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var line storedValue
+		if err := decoder.Decode(&line); err != nil {
+			return nil, err
+		}
+		dates[line.Timestamp] = true
+	}
+	return dates, nil
+}
+
+// Returns the data whose date is not among the stored ones.
+func withoutDates(data []CryptoDataCurated, stored map[string]bool) []CryptoDataCurated {
+	var filtered []CryptoDataCurated
+	for _, curated := range data {
+		if !stored[curated.date] {
+			filtered = append(filtered, curated)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Acquires the lock file in path, holding the PID of the process, so a single run
+// uses the database at a time. A lock whose process is not running anymore is stale
+// and taken over, like any lock with force.
+// It returns the function releasing the lock, which does nothing when path is empty.
+func acquireLock(path string, force bool) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		_, err = file.Write(pid)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	} else if errors.Is(err, os.ErrExist) {
+		owner, _ := readLockOwner(path)
+		if owner > 0 && processRunning(owner) && !force {
+			return nil, fmt.Errorf("%w: %s is held by the process %d", ErrLocked, path, owner)
+		}
+		slog.Warn("Taking over the lock of the database", "path", path, "pid", owner)
+		err = os.WriteFile(path, pid, 0644)
+	}
+	if err != nil {
+		return nil, FileSystemError{Msg: "Error creating the lock file " + path + ": " + err.Error()}
+	}
+
+	return func() {
+		if err := os.Remove(path); err != nil {
+			slog.Error("unable to remove the lock file", "path", path, "err", err.Error())
+		}
+	}, nil
+}
+
+// Returns the PID stored in a lock file.
+func readLockOwner(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// Tells if a process is running, by sending it the signal 0.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	// Processes of other users can't be signaled, but they are running.
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,33 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Converts the name of a log level (debug, info, warn or error) to a slog.Level.
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, DataError{Msg: fmt.Sprintf("invalid log level %q, valid options are: debug, info, warn, error", name)}
+}
+
+// Makes the default logger write the records of the given level and above to w.
+func SetUpLogging(w io.Writer, level string) error {
+	lvl, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: lvl})))
+	return nil
+}
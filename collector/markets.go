@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Default values for the market, the mode (the API function) and the asset class of the collector.
+const (
+	DefaultMarket     = "EUR"
+	DefaultMode       = "DIGITAL_CURRENCY_WEEKLY"
+	DefaultAssetClass = "crypto"
+)
+
+// The API functions the collector knows how to process.
+var supportedModes = map[string]bool{
+	"DIGITAL_CURRENCY_WEEKLY": true,
+}
+
+// The markets (physical currencies) Alpha Vantage can convert the prices to.
+var supportedMarkets = map[string]bool{
+	"AED": true, "ARS": true, "AUD": true, "BRL": true, "CAD": true, "CHF": true,
+	"CLP": true, "CNY": true, "CZK": true, "DKK": true, "EUR": true, "GBP": true,
+	"HKD": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "JPY": true,
+	"KRW": true, "MXN": true, "MYR": true, "NOK": true, "NZD": true, "PHP": true,
+	"PLN": true, "RUB": true, "SAR": true, "SEK": true, "SGD": true, "THB": true,
+	"TRY": true, "TWD": true, "USD": true, "ZAR": true,
+}
+
+// The asset classes the collector knows about, the category of the exported data.
+var supportedAssetClasses = map[string]bool{
+	DefaultAssetClass: true,
+}
+
+// Returns the supported modes (API functions), sorted.
+func SupportedModes() []string {
+	return allowedValues(supportedModes)
+}
+
+// Returns the supported markets, sorted.
+func SupportedMarkets() []string {
+	return allowedValues(supportedMarkets)
+}
+
+// Returns the supported asset classes, sorted.
+func SupportedAssetClasses() []string {
+	return allowedValues(supportedAssetClasses)
+}
+
+// Returns the keys of an allowlist, sorted.
+func allowedValues(allowlist map[string]bool) []string {
+	values := make([]string, 0, len(allowlist))
+	for value := range allowlist {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Checks that both the market and the mode are supported.
+func validateMarketAndMode(market string, mode string) error {
+	if !supportedMarkets[market] {
+		return DataError{Msg: fmt.Sprintf("invalid market %q, valid options are: %s", market, strings.Join(allowedValues(supportedMarkets), ", "))}
+	}
+	if !supportedModes[mode] {
+		return DataError{Msg: fmt.Sprintf("invalid mode %q, valid options are: %s", mode, strings.Join(allowedValues(supportedModes), ", "))}
+	}
+	return nil
+}
+
+// Returns the Alpha Vantage URL template for a mode and a market.
+// The symbol and the API key are left as placeholders, as expected by GetURLFromSymbol.
+func ApiUrlTemplate(mode string, market string) string {
+	return fmt.Sprintf("https://www.alphavantage.co/query?function=%s&symbol=%%s&market=%s&apikey=%%s", mode, market)
+}
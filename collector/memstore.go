@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"database/sql"
+)
+
+// Opens an in-memory database with the schema up to date, for the memory store
+// backend. Set it as the Db of the collector, and the data it stores can be read
+// from it in the same process, e.g. to export it, without writing to disk.
+// The data is gone once it's closed.
+func OpenMemoryDb() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, DbError{Msg: "Failed to open the in-memory database: " + err.Error()}
+	}
+	// Every connection to an in-memory database gets its own one, so a single
+	// connection is kept, and never closed.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if _, err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
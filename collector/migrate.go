@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// A migration takes the schema from one version to the next one.
+// Migrations must be idempotent, as a database created before the schema was
+// versioned already contains some of the tables.
+type migration func(tx *sql.Tx) error
+
+// The list of migrations. The schema version is the number of migrations applied,
+// so new migrations must always be appended at the end.
+var migrations = []migration{
+	createBaseTables,
+	addOHLCVColumns,
+	createQualityTable,
+	createFetchLogTable,
+	createSymbolMetaTable,
+	addYearWeekColumn,
+	createFetchFailuresTable,
+	addContentHashColumn,
+}
+
+// Version 1: the tables for the prices and the blacklist.
+func createBaseTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS crypto_prices (
+			id INTEGER PRIMARY KEY,
+			symbol TEXT,
+			timestamp TEXT,
+			value REAL,
+			UNIQUE(symbol, timestamp)
+		);
+		CREATE TABLE IF NOT EXISTS blacklist (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			symbol VARCHAR(255) UNIQUE NOT NULL
+		);
+	`)
+	return err
+}
+
+// Version 2: open, high, low and volume columns for the prices.
+func addOHLCVColumns(tx *sql.Tx) error {
+	for _, column := range []string{"open", "high", "low", "volume"} {
+		if err := addColumnIfMissing(tx, "crypto_prices", column, "REAL"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 3: the completeness of the data extracted for every symbol.
+func createQualityTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS quality (
+			symbol TEXT PRIMARY KEY,
+			expected INTEGER NOT NULL,
+			extracted INTEGER NOT NULL,
+			runs INTEGER NOT NULL DEFAULT 0,
+			incomplete_runs INTEGER NOT NULL DEFAULT 0,
+			last_seen TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+// Version 4: when every symbol was requested to the API for the last time.
+func createFetchLogTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS fetch_log (
+			symbol TEXT PRIMARY KEY,
+			fetched_at TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+// Version 5: when the API refreshed the data of every symbol for the last time.
+func createSymbolMetaTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS symbol_meta (
+			symbol TEXT PRIMARY KEY,
+			last_refreshed TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+// Version 6: the year.week of every price, so the exporter doesn't derive it from the timestamp.
+// The existing rows are left NULL, see BackfillYearWeek.
+func addYearWeekColumn(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "crypto_prices", "year_week", "TEXT")
+}
+
+// Version 7: the requests that failed, and why a symbol was blacklisted.
+func createFetchFailuresTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS fetch_failures (
+			id INTEGER PRIMARY KEY,
+			symbol TEXT NOT NULL,
+			failed_at TEXT NOT NULL,
+			reason TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	return addColumnIfMissing(tx, "blacklist", "reason", "TEXT")
+}
+
+// Version 8: the hash of the last response of every symbol, see RecordContentHash.
+func addContentHashColumn(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "fetch_log", "content_hash", "TEXT")
+}
+
+// SQLite does not support "ADD COLUMN IF NOT EXISTS", so the columns of the
+// table are checked before altering it.
+func addColumnIfMissing(tx *sql.Tx, table string, column string, columnType string) error {
+	columns, err := TableColumns(context.Background(), tx, table)
+	if err != nil {
+		return err
+	}
+	if columns[column] {
+		return nil
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	return err
+}
+
+// Queryer runs queries, it's implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// TableColumns returns the set of column names of a table, from PRAGMA table_info.
+// It's how both the collector and the exporter read the schema of the database.
+func TableColumns(ctx context.Context, q Queryer, table string) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// Returns the schema version stored in the meta table, creating the table if needed.
+// A database without version is at version 0.
+func schemaVersion(db *sql.DB) (int, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT
+	)`)
+	if err != nil {
+		return 0, err
+	}
+
+	var value string
+	err = db.QueryRow("SELECT value FROM meta WHERE key = 'schema_version'").Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// Migrate brings the database schema up to date, applying every migration newer
+// than the version stored in the meta table. Each migration runs in its own
+// transaction, together with the update of the version.
+// It returns the schema version of the database after the migration.
+func Migrate(db *sql.DB) (int, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return 0, DbError{Msg: "Failed to read the schema version: " + err.Error()}
+	}
+
+	for version < len(migrations) {
+		tx, err := db.Begin()
+		if err != nil {
+			return version, DbError{Msg: "Failed to begin the migration: " + err.Error()}
+		}
+
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return version, DbError{Msg: fmt.Sprintf("Failed to migrate to version %d: %s", version+1, err.Error())}
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES ('schema_version', ?)", strconv.Itoa(version+1))
+		if err != nil {
+			tx.Rollback()
+			return version, DbError{Msg: "Failed to store the schema version: " + err.Error()}
+		}
+		if err := tx.Commit(); err != nil {
+			return version, DbError{Msg: "Failed to commit the migration: " + err.Error()}
+		}
+
+		version++
+		slog.Info("Migrated the database", "version", version)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// Opens a new database in a temporary directory.
+func openTempDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "crypto.sqlite"))
+	if err != nil {
+		t.Fatal("unable to open the database", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Checks that the prices table has all the columns of the latest schema.
+func checkLatestSchema(t *testing.T, db *sql.DB) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal("unable to begin a transaction", err.Error())
+	}
+	defer tx.Rollback()
+
+	columns, err := TableColumns(context.Background(), tx, "crypto_prices")
+	if err != nil {
+		t.Fatal("unable to read the columns", err.Error())
+	}
+	for _, column := range []string{"symbol", "timestamp", "value", "open", "high", "low", "volume"} {
+		if !columns[column] {
+			t.Log("The column", column, "is missing after the migration")
+			t.Fail()
+		}
+	}
+}
+
+// Tests that a fresh database gets the whole schema, and that migrating again does nothing.
+func TestMigrateFreshDb(t *testing.T) {
+	db := openTempDb(t)
+
+	version, err := Migrate(db)
+	if err != nil {
+		t.Fatal("unable to migrate a fresh database", err.Error())
+	}
+	if version != len(migrations) {
+		t.Log("Expected version", len(migrations), "got", version)
+		t.Fail()
+	}
+	checkLatestSchema(t, db)
+
+	version, err = Migrate(db)
+	if err != nil {
+		t.Log("Migrating twice should not fail", err.Error())
+		t.Fail()
+	}
+	if version != len(migrations) {
+		t.Log("Expected version", len(migrations), "after migrating twice, got", version)
+		t.Fail()
+	}
+}
+
+// Tests that a database created before the schema was versioned is updated, keeping its data.
+func TestMigrateOlderSchemaDb(t *testing.T) {
+	db := openTempDb(t)
+
+	_, err := db.Exec(`
+		CREATE TABLE crypto_prices (
+			id INTEGER PRIMARY KEY,
+			symbol TEXT,
+			timestamp TEXT,
+			value REAL,
+			UNIQUE(symbol, timestamp)
+		);
+		CREATE TABLE blacklist (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			symbol VARCHAR(255) UNIQUE NOT NULL
+		);
+		INSERT INTO crypto_prices (symbol, timestamp, value) VALUES ('BTC', '2023-07-02', 28000.5);
+	`)
+	if err != nil {
+		t.Fatal("unable to create the old schema", err.Error())
+	}
+
+	version, err := Migrate(db)
+	if err != nil {
+		t.Fatal("unable to migrate the old database", err.Error())
+	}
+	if version != len(migrations) {
+		t.Log("Expected version", len(migrations), "got", version)
+		t.Fail()
+	}
+	checkLatestSchema(t, db)
+
+	var value float64
+	err = db.QueryRow("SELECT value FROM crypto_prices WHERE symbol = 'BTC'").Scan(&value)
+	if err != nil || value != 28000.5 {
+		t.Log("The existing data was not kept after the migration", err)
+		t.Fail()
+	}
+}
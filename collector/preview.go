@@ -0,0 +1,29 @@
+package collector
+
+import "fmt"
+
+// Returns the number of entries in the time series of the raw data, which is
+// how many values the API has for the symbol.
+func CountTimeSeries(raw CryptoDataRaw) int {
+	return len(raw.TimeSeries)
+}
+
+// Requests the data of a single symbol and returns how many values the API has
+// for it, without storing anything. Useful to estimate the coverage before a run.
+func PreviewSymbol(c CollectorInterface, symbol string) (int, error) {
+	response, err := c.GetGetDataFunc()(c.GetURLFromSymbol(symbol))
+	if err != nil {
+		return 0, err
+	}
+
+	raw, status := GetRawValuesFromResponse(response)
+	switch status {
+	case StatusAllGood:
+		return CountTimeSeries(raw), nil
+	case StatusLimitReached:
+		return 0, ErrDailyLimitReached
+	case StatusMissingSymbol:
+		return 0, DataError{Msg: fmt.Sprintf("the API doesn't know the symbol %s", symbol)}
+	}
+	return 0, DataError{Msg: fmt.Sprintf("unable to read the response for %s", symbol)}
+}
@@ -0,0 +1,26 @@
+package collector
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Returns the date and value of the most recent price stored for the symbol.
+// The weeks stored as NULL are ignored. If there is no price for the symbol,
+// the error is a DataError.
+func LatestPrice(db *sql.DB, symbol string) (date string, value float64, err error) {
+	err = db.QueryRow(`
+		SELECT timestamp, value
+		FROM crypto_prices
+		WHERE symbol = ? AND value IS NOT NULL
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`, symbol).Scan(&date, &value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, DataError{Msg: "There is no price stored for " + symbol}
+	}
+	if err != nil {
+		return "", 0, DbError{Msg: "Failed to query the price of " + symbol + ": " + err.Error()}
+	}
+	return date, value, nil
+}
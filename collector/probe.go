@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Default number of requests of Probe, so it doesn't use up the daily limit of the API.
+const DefaultProbeRequests = 30
+
+// Time waited after the message of the limit of requests per minute, which has no
+// Retry-After header. Replaced in the tests.
+var throttleMessageWait = time.Minute
+
+// Limits of the API key found by Probe.
+type ProbeResult struct {
+	// Requests answered before the API throttled them for the first time (HTTP 429, or
+	// the message of the limit per minute), the requests per minute. 0 when they were
+	// never throttled.
+	PerMinute int
+	// Requests answered before the daily limit was reached, the requests per day.
+	// 0 when it wasn't reached.
+	PerDay int
+	// Number of requests sent, the throttled ones included.
+	Requests int
+}
+
+// Finds the rate limits of the API key, requesting the data of a symbol as fast as
+// possible. When the API throttles the requests, it waits as long as it tells and
+// goes on, until the daily limit is reached, maxRequests were sent (DefaultProbeRequests
+// when 0 or less) or ctx is done.
+// Every request but the throttled ones counts for the daily limit.
+func Probe(ctx context.Context, c CollectorInterface, symbol string, maxRequests int) (ProbeResult, error) {
+	if maxRequests < 1 {
+		maxRequests = DefaultProbeRequests
+	}
+
+	var result ProbeResult
+	answered := 0
+	url := c.GetURLFromSymbol(symbol)
+	for result.Requests < maxRequests {
+		result.Requests++
+		response, err := c.GetGetDataFunc()(url)
+		var status ApiStatus
+		if err == nil {
+			_, status = GetRawValuesFromResponse(response)
+		}
+		var throttled ThrottledError
+		if errors.As(err, &throttled) || status == StatusThrottled {
+			if result.PerMinute == 0 {
+				result.PerMinute = answered
+			}
+			wait := throttleWait(throttled.RetryAfter)
+			if status == StatusThrottled {
+				wait = throttleMessageWait
+			}
+			slog.Info("The API throttled the requests, waiting", "answered", answered, "wait", wait)
+			if err := sleepContext(ctx, wait); err != nil {
+				return result, nil
+			}
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if status == StatusLimitReached {
+			result.PerDay = answered
+			return result, nil
+		}
+		answered++
+	}
+
+	slog.Info("Stopped probing before the daily limit", "requests", result.Requests)
+	return result, nil
+}
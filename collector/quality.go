@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Completeness of the data extracted for a symbol, across all the runs.
+type QualityRecord struct {
+	Symbol string
+	// Values expected and extracted in the last run.
+	Expected  int
+	Extracted int
+	// Number of runs that extracted data for the symbol, and how many of them were incomplete.
+	Runs           int
+	IncompleteRuns int
+	// When the symbol was extracted for the last time, in RFC 3339 format.
+	LastSeen string
+}
+
+// Records how many values were extracted for a symbol out of the expected ones.
+func RecordQuality(db *sql.DB, symbol string, expected int, extracted int) error {
+	incomplete := 0
+	if extracted < expected {
+		incomplete = 1
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO quality (symbol, expected, extracted, runs, incomplete_runs, last_seen)
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT(symbol) DO UPDATE SET
+			expected = excluded.expected,
+			extracted = excluded.extracted,
+			runs = runs + 1,
+			incomplete_runs = incomplete_runs + excluded.incomplete_runs,
+			last_seen = excluded.last_seen
+	`, symbol, expected, extracted, incomplete, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return DbError{Msg: "Failed to record the quality of " + symbol + ": " + err.Error()}
+	}
+	return nil
+}
+
+// Returns up to limit symbols that returned incomplete data, the ones that did
+// it more often first.
+func WorstQuality(db *sql.DB, limit int) ([]QualityRecord, error) {
+	rows, err := db.Query(`
+		SELECT symbol, expected, extracted, runs, incomplete_runs, last_seen
+		FROM quality
+		WHERE incomplete_runs > 0
+		ORDER BY incomplete_runs DESC, extracted ASC, symbol ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, DbError{Msg: "Failed to query the quality table: " + err.Error()}
+	}
+	defer rows.Close()
+
+	var records []QualityRecord
+	for rows.Next() {
+		var r QualityRecord
+		if err := rows.Scan(&r.Symbol, &r.Expected, &r.Extracted, &r.Runs, &r.IncompleteRuns, &r.LastSeen); err != nil {
+			return nil, DbError{Msg: "Failed to read the quality table: " + err.Error()}
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// runSummaryJSON is the JSON form of a RunResult, for the orchestration tools.
+type runSummaryJSON struct {
+	Processed       int      `json:"processed"`
+	Requests        int      `json:"requests"`
+	Errors          int      `json:"errors"`
+	Succeeded       []string `json:"succeeded"`
+	Failed          []string `json:"failed"`
+	Blacklisted     []string `json:"blacklisted"`
+	Incomplete      []string `json:"incomplete"`
+	CompleteRatio   float64  `json:"complete_ratio"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	StopReason      string   `json:"stop_reason,omitempty"`
+}
+
+// MarshalJSON encodes the result with snake_case keys, the lists of symbols as
+// arrays even when empty, and the duration in seconds.
+func (r RunResult) MarshalJSON() ([]byte, error) {
+	summary := runSummaryJSON{
+		Processed:       r.Processed,
+		Requests:        r.Requests,
+		Errors:          r.Errors,
+		Succeeded:       nonNil(r.Succeeded),
+		Failed:          nonNil(r.Failed),
+		Blacklisted:     nonNil(r.Blacklisted),
+		Incomplete:      nonNil(r.Incomplete),
+		CompleteRatio:   r.CompleteRatio,
+		DurationSeconds: r.Duration.Seconds(),
+	}
+	if r.StopReason != nil {
+		summary.StopReason = r.StopReason.Error()
+	}
+	return json.Marshal(summary)
+}
+
+// Returns the symbols, or an empty slice when there are none, so they're encoded as [].
+func nonNil(symbols []string) []string {
+	if symbols == nil {
+		return []string{}
+	}
+	return symbols
+}
+
+// Writes the result of a run to the file in path, as indented JSON.
+func WriteRunSummary(path string, result RunResult) error {
+	content, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return DataError{Msg: "Error encoding the summary of the run", Err: err}
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0o644); err != nil {
+		return FileSystemError{Msg: "Error writing the summary of the run to " + path + ": " + err.Error()}
+	}
+	return nil
+}
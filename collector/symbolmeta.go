@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Metadata of a symbol as reported by the API.
+type SymbolMeta struct {
+	Symbol string
+	// When the API refreshed the data of the symbol for the last time, as it comes in the response.
+	LastRefreshed string
+	// When it was recorded, in RFC 3339 format.
+	UpdatedAt string
+}
+
+// Records when the API refreshed the data of a symbol for the last time.
+func RecordLastRefreshed(db *sql.DB, symbol string, lastRefreshed string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO symbol_meta (symbol, last_refreshed, updated_at) VALUES (?, ?, ?)",
+		symbol, lastRefreshed, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return DbError{Msg: "Failed to record the last refreshed time of " + symbol + ": " + err.Error()}
+	}
+	return nil
+}
+
+// Returns the metadata of a symbol. It's a DataError if it was never recorded.
+func GetSymbolMeta(db *sql.DB, symbol string) (SymbolMeta, error) {
+	meta := SymbolMeta{Symbol: symbol}
+	err := db.QueryRow("SELECT last_refreshed, updated_at FROM symbol_meta WHERE symbol = ?", symbol).Scan(&meta.LastRefreshed, &meta.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return meta, DataError{Msg: "There is no metadata for " + symbol}
+	}
+	if err != nil {
+		return meta, DbError{Msg: "Failed to query the symbol_meta table: " + err.Error()}
+	}
+	return meta, nil
+}
+
+// Returns the metadata of every symbol, sorted by symbol.
+func ListSymbolMeta(db *sql.DB) ([]SymbolMeta, error) {
+	rows, err := db.Query("SELECT symbol, last_refreshed, updated_at FROM symbol_meta ORDER BY symbol")
+	if err != nil {
+		return nil, DbError{Msg: "Failed to query the symbol_meta table: " + err.Error()}
+	}
+	defer rows.Close()
+
+	var metas []SymbolMeta
+	for rows.Next() {
+		var meta SymbolMeta
+		if err := rows.Scan(&meta.Symbol, &meta.LastRefreshed, &meta.UpdatedAt); err != nil {
+			return nil, DbError{Msg: "Failed to read the symbol_meta table: " + err.Error()}
+		}
+		metas = append(metas, meta)
+	}
+	return metas, rows.Err()
+}
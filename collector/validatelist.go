@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Outcome of probing every symbol of the currency list against the API.
+type ListValidation struct {
+	// Symbols that returned data.
+	Valid []string
+	// Symbols the API doesn't know, left out of the cleaned list.
+	Dead []string
+	// Symbols that could not be probed, because of a connection error or
+	// because the limit was reached. They are kept in the cleaned list.
+	Unchecked []string
+}
+
+// Requests every symbol of the currency list once, respecting the limit of n
+// requests per minute, and writes to outputPath a CSV with the rows of the list
+// except the dead symbols. Once the daily limit is reached the remaining symbols
+// are not probed, and they are kept in the list.
+func ValidateCurrencyList(ctx context.Context, c CollectorInterface, n int, outputPath string) (ListValidation, error) {
+	var validation ListValidation
+
+	records, err := c.ReadCurrencyList()
+	if err != nil {
+		return validation, err
+	}
+
+	limiter := newRateLimiter(n, time.Minute)
+	cleaned := [][]string{records[0]}
+	stopped := false
+	for _, row := range records[1:] {
+		symbol := row[0]
+		if stopped {
+			validation.Unchecked = append(validation.Unchecked, symbol)
+			cleaned = append(cleaned, row)
+			continue
+		}
+
+		if err := limiter.wait(ctx); err != nil {
+			return validation, err
+		}
+
+		status, err := probeSymbol(c, symbol)
+		switch {
+		case err != nil:
+			validation.Unchecked = append(validation.Unchecked, symbol)
+		case status == StatusAllGood:
+			validation.Valid = append(validation.Valid, symbol)
+		case status == StatusMissingSymbol:
+			slog.Info(symbol + " is not available in the API")
+			validation.Dead = append(validation.Dead, symbol)
+			continue
+		case status == StatusLimitReached:
+			slog.Info("Reached the limit for today, the rest of the symbols are not probed")
+			stopped = true
+			validation.Unchecked = append(validation.Unchecked, symbol)
+		default:
+			validation.Unchecked = append(validation.Unchecked, symbol)
+		}
+		cleaned = append(cleaned, row)
+	}
+
+	return validation, writeCurrencyList(outputPath, cleaned)
+}
+
+// Requests the data of a symbol and returns the status of the response.
+func probeSymbol(c CollectorInterface, symbol string) (int, error) {
+	url := c.GetURLFromSymbol(symbol)
+	response, err := c.GetGetDataFunc()(url)
+	if err != nil {
+		slog.Warn(symbol+" could not be requested", "err", err.Error())
+		return 0, err
+	}
+
+	_, status := GetRawValuesFromResponse(response)
+	return status, nil
+}
+
+// Writes the rows of a currency list to a CSV file.
+func writeCurrencyList(path string, records [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return FileSystemError{Msg: "Error creating the currency list file " + path}
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(records); err != nil {
+		return FileSystemError{Msg: "Error writing the currency list file " + path + ": " + err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Returns the ISO week of a "YYYY-MM-DD" date, in "YYYY.WW" format.
+func YearWeek(date string) (string, error) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", err
+	}
+	_, week := t.ISOWeek()
+	return fmt.Sprintf("%d.%02d", t.Year(), week), nil
+}
+
+// Returns the year.week of the date to store it, NULL when it can't be parsed.
+func nullableYearWeek(date string) interface{} {
+	yearWeek, err := YearWeek(date)
+	if err != nil {
+		return nil
+	}
+	return yearWeek
+}
+
+// Stores the year.week of the prices stored before the year_week column existed.
+// The rows whose timestamp can't be parsed are left NULL.
+// It returns the number of rows updated.
+func BackfillYearWeek(db *sql.DB) (int64, error) {
+	rows, err := db.Query("SELECT id, timestamp FROM crypto_prices WHERE year_week IS NULL")
+	if err != nil {
+		return 0, DbError{Msg: "Failed to read the prices without year.week: " + err.Error()}
+	}
+	yearWeeks := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var timestamp sql.NullString
+		if err := rows.Scan(&id, &timestamp); err != nil {
+			rows.Close()
+			return 0, DbError{Msg: "Failed to read the prices without year.week: " + err.Error()}
+		}
+		yearWeek, err := YearWeek(timestamp.String)
+		if err != nil {
+			slog.Warn("Unable to compute the year.week of a price", "id", id, "timestamp", timestamp.String)
+			continue
+		}
+		yearWeeks[id] = yearWeek
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, DbError{Msg: "Failed to read the prices without year.week: " + err.Error()}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, DbError{Msg: "Failed to begin the transaction: " + err.Error()}
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("UPDATE crypto_prices SET year_week = ? WHERE id = ?")
+	if err != nil {
+		return 0, DbError{Msg: "Failed to prepare the update: " + err.Error()}
+	}
+	defer stmt.Close()
+
+	var updated int64
+	for id, yearWeek := range yearWeeks {
+		if _, err := stmt.Exec(yearWeek, id); err != nil {
+			return 0, DbError{Msg: "Failed to store the year.week: " + err.Error()}
+		}
+		updated++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, DbError{Msg: "Failed to commit the year.week: " + err.Error()}
+	}
+	return updated, nil
+}
@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// csvHeader is the first row of the CSV export, unless it's left out.
+var csvHeader = []string{"symbol", "year_week", "value"}
+
+// csvISODateHeader is the header of the CSV export with the dates of the prices.
+var csvISODateHeader = []string{"symbol", "date", "value"}
+
+// encodeCSV writes a row per price to w, sorted by symbol, from the table of TidyRows.
+// Missing values are empty. The header row is only written if header is set.
+// With isoDate, the rows have the date of the price instead of the week.
+func encodeCSV(w io.Writer, data map[string]*CryptoOutput, header bool, isoDate bool) error {
+	rows := outputRows(data)
+	table := TidyRows(rows)
+	if isoDate {
+		table[0] = csvISODateHeader
+	} else {
+		table[0] = csvHeader
+		for i, row := range rows {
+			table[i+1][1] = row.YearWeek
+		}
+	}
+	if !header {
+		table = table[1:]
+	}
+
+	writer := csv.NewWriter(w)
+	writer.WriteAll(table)
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Verifies that the CSV export has the header row unless NoHeader is set.
+func TestExportCSVHeader(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"ETH", "2023-07-02", 1800.5},
+		{"BTC", "2023-07-02", nil},
+	})
+	rows := "BTC,2023.26,\nETH,2023.26,1800.5\n"
+
+	cases := []struct {
+		noHeader bool
+		expected string
+	}{
+		{false, "symbol,year_week,value\n" + rows},
+		{true, rows},
+	}
+	for _, tc := range cases {
+		outputPath := filepath.Join(t.TempDir(), "output.csv")
+		if _, err := Export(dbPath, outputPath, Options{Format: FormatCSV, NoHeader: tc.noHeader}); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+
+		content, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		if string(content) != tc.expected {
+			t.Errorf("Expected the CSV %q with NoHeader %v, got %q", tc.expected, tc.noHeader, content)
+		}
+	}
+
+	if _, err := Export(dbPath, filepath.Join(t.TempDir(), "output.csv"), Options{Format: "xml"}); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
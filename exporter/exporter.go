@@ -1,19 +1,170 @@
 package exporter
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/agviu/investrends/collector"
 	_ "github.com/mattn/go-sqlite3" // Import the SQLite driver anonymously to enable database/sql to use it without directly interacting with it.
 )
 
+// Shapes of the exported JSON.
+const (
+	ShapeObjects = "objects" // An array of CryptoOutput objects, the default.
+	ShapeTuples  = "tuples"  // An object mapping each symbol to its [timestamp, value] pairs.
+)
+
+// Formats of the export file.
+const (
+	FormatJSON = "json" // JSON in the configured shape, the default.
+	FormatCSV  = "csv"  // A symbol,year_week,value row per price.
+	// Columnar symbol, timestamp (DATE) and value columns, for analytics tools.
+	FormatParquet = "parquet"
+	// INSERT statements into crypto_prices, to load the rows into another database.
+	FormatSQL = "sql"
+)
+
+// Profiles of the JSON field names, for the objects shape.
+const (
+	ProfileDefault = "default" // The tags of CryptoOutput and PriceEntry, e.g. "year.week".
+	ProfileSnake   = "snake"   // Valid identifiers in snake case, e.g. "year_week".
+
+	// The profile of DateFormatISO, with a "date" field instead of "year.week".
+	// It's chosen by the date format, not by Options.Profile.
+	profileISODate = "iso-date"
+)
+
+// Date formats of the prices, for the objects shape and the CSV.
+const (
+	DateFormatYearWeek = "year.week" // The week of the year, "YYYY.WW", the default.
+	DateFormatISO      = "iso-date"  // The date stored in the database, "YYYY-MM-DD".
+)
+
+// Category and mode of every exported CryptoOutput.
+const (
+	outputCategory = "crypto"
+	outputMode     = "year.week"
+)
+
+// Options configures the export.
+type Options struct {
+	Shape  string // The shape of the JSON, ShapeObjects when empty.
+	DryRun bool   // Fetch the data and compute the stats, without writing the file.
+	Force  bool   // Overwrite the output file if it exists. Off by default, so a good export is not lost by accident.
+	// The field names of the objects shape, ProfileDefault when empty.
+	Profile string
+	// Fail on the first row that can't be read, e.g. with an unparseable timestamp, instead of skipping it.
+	Strict bool
+	// The symbols and dates exported, all of them when empty.
+	Filter RowFilter
+	// Split the objects shape in numbered files (out-001.json, out-002.json...) of
+	// at most Chunk symbols each. 0 writes a single file.
+	Chunk int
+
+	// Write a <symbol>.json file per symbol in OutDir instead of a single file.
+	// Only the objects shape is supported, each file holding a single CryptoOutput.
+	SplitBySymbol bool
+	OutDir        string
+	// Number of files of SplitBySymbol written at the same time. 0 writes them one by one.
+	Parallel int
+
+	// The format of the file, FormatJSON when empty. Shape, Profile, Chunk and
+	// SplitBySymbol only apply to the JSON. Fill and Weights don't apply to the SQL.
+	Format string
+	// Leave out the header row of the CSV, e.g. to append the file to a previous one.
+	NoHeader bool
+	// Permissions of the files written, DefaultFileMode when 0. See ParseFileMode.
+	FileMode os.FileMode
+	// The date format of the prices, DateFormatYearWeek when empty. The tuples
+	// shape always has Unix timestamps.
+	DateFormat string
+	// Only the symbols in these currency lists are exported, leaving out the ones
+	// not tracked anymore. All of them when empty.
+	CurrencyLists []string
+	// How the weeks without value are filled, FillNone when empty. See fillForward.
+	Fill string
+	// Exports a single index with the code IndexCode instead of the symbols, the sum
+	// of the values of these symbols times their weight. See weightedIndex.
+	Weights map[string]float64
+	// Writes the single output to Writer instead of the output file, e.g. to upload
+	// it. It can't be used with Chunk or SplitBySymbol. The writer is flushed if it
+	// has a Flush method, but it's not closed.
+	Writer io.Writer
+	// The weeks of the prices are the ones of their dates in this location, e.g. to
+	// align them to a locale. The dates stored are in UTC when nil.
+	Location *time.Location
+}
+
+// DefaultFileMode is the mode of the exported files, unless Options has one.
+const DefaultFileMode os.FileMode = 0644
+
+// ParseFileMode parses the permissions of the exported files from an octal string, e.g. "0640".
+func ParseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q, it must be octal permissions like 0644", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ExportStats summarizes the data of an export.
+type ExportStats struct {
+	Symbols int // The number of symbols exported.
+	Entries int // The total number of price entries across all symbols.
+	Skipped int // The rows skipped because they could not be read or converted.
+	// Why every skipped row was skipped.
+	RowErrors []RowError
+}
+
 // PriceEntry represents a single price entry with its associated week and value.
 type PriceEntry struct {
-	YearWeek string  `json:"year.week"` // The week of the year in "YYYY.WW" format.
-	Value    float64 `json:"value"`     // The price value.
+	YearWeek string    `json:"year.week"` // The week of the year in "YYYY.WW" format.
+	Value    float64   `json:"value"`     // The price value.
+	Missing  bool      `json:"-"`         // The API had no value for the week, Value is encoded as null.
+	date     time.Time // The date of the price, as stored in the database.
+	// The week had no value, it's carried forward from the previous one. See fillForward.
+	Filled bool `json:"filled,omitempty"`
+}
+
+// priceEntryJSON is the JSON form of a PriceEntry, where a missing value is null.
+type priceEntryJSON struct {
+	YearWeek string   `json:"year.week"`
+	Value    *float64 `json:"value"`
+	Filled   bool     `json:"filled,omitempty"`
+}
+
+// MarshalJSON encodes the value of a missing entry as null.
+func (p PriceEntry) MarshalJSON() ([]byte, error) {
+	entry := priceEntryJSON{YearWeek: p.YearWeek, Filled: p.Filled}
+	if !p.Missing {
+		entry.Value = &p.Value
+	}
+	return json.Marshal(entry)
+}
+
+// UnmarshalJSON decodes a null value as a missing entry.
+func (p *PriceEntry) UnmarshalJSON(data []byte) error {
+	var entry priceEntryJSON
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	*p = PriceEntry{YearWeek: entry.YearWeek, Missing: entry.Value == nil, Filled: entry.Filled}
+	if entry.Value != nil {
+		p.Value = *entry.Value
+	}
+	return nil
 }
 
 // CryptoOutput aggregates all prices for a single cryptocurrency symbol.
@@ -24,37 +175,59 @@ type CryptoOutput struct {
 	Mode     string       `json:"mode"`     // The mode of aggregation, e.g., "year.week".
 }
 
-// timestampToYearWeek converts a timestamp string to a "year.week" format.
+// timestampToYearWeek converts a timestamp string to a "year.week" format,
+// the same the collector stores.
 func timestampToYearWeek(ts string) (string, error) {
-	t, err := time.Parse("2006-01-02", ts) // Parse the timestamp.
-	if err != nil {
-		return "", err // Return an error if parsing fails.
+	return collector.YearWeek(ts)
+}
+
+// yearWeekIn returns the "year.week" of a date of the database, taken as midnight UTC,
+// at that time in loc. West of UTC, a Monday is still the Sunday before, in the
+// previous week. A nil loc keeps the date as it is, like timestampToYearWeek.
+func yearWeekIn(date time.Time, loc *time.Location) string {
+	if loc != nil {
+		date = date.In(loc)
 	}
-	_, week := t.ISOWeek()                             // Get the ISO week number.
-	return fmt.Sprintf("%d.%02d", t.Year(), week), nil // Return formatted "year.week" string.
+	_, week := date.ISOWeek()
+	return fmt.Sprintf("%d.%02d", date.Year(), week)
 }
 
-// fetchData queries the database for price data and organizes it into a map of CryptoOutput structs.
-func fetchData(db *sql.DB) (map[string]*CryptoOutput, error) {
-	query := "SELECT symbol, timestamp, value FROM crypto_prices" // SQL query to fetch data.
-	rows, err := db.Query(query)
+// fetchData queries the database for the price data selected by the filter and organizes it
+// into a map of CryptoOutput structs. The rows read are returned as well, for the formats
+// built from them.
+// Rows that can't be read, or with an unparseable timestamp, are logged and skipped, and
+// returned along with the data, unless strict is set, in which case the first of them is an error.
+// With loc, the weeks are the ones of the dates in it, see yearWeekIn.
+func fetchData(ctx context.Context, db *sql.DB, filter RowFilter, strict bool, loc *time.Location) (map[string]*CryptoOutput, []Row, []RowError, error) {
+	rows, rowErrors, err := queryRows(ctx, db, filter)
 	if err != nil {
-		return nil, fmt.Errorf("error querying database: %w", err)
+		return nil, nil, nil, err
+	}
+	if strict && len(rowErrors) > 0 {
+		return nil, nil, nil, fmt.Errorf("error scanning row: %w", rowErrors[0])
+	}
+	for _, rowError := range rowErrors {
+		slog.Warn("Skipping a row that can't be read", "symbol", rowError.Symbol, "timestamp", rowError.Timestamp, "err", rowError.Err)
 	}
-	defer rows.Close()
 
 	results := make(map[string]*CryptoOutput) // Map to hold the results, keyed by symbol.
 
-	for rows.Next() {
-		var symbol, timestamp string
-		var value float64
-		if err := rows.Scan(&symbol, &timestamp, &value); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-
-		yearWeek, err := timestampToYearWeek(timestamp) // Convert timestamp to "year.week".
+	for _, row := range rows {
+		symbol, timestamp := row.Symbol, row.Timestamp
+		date, err := time.Parse("2006-01-02", timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("error converting timestamp: %w", err)
+			if strict {
+				return nil, nil, nil, fmt.Errorf("error converting timestamp: %w", err)
+			}
+			slog.Warn("Skipping a row with an unparseable timestamp", "symbol", symbol, "timestamp", timestamp)
+			rowErrors = append(rowErrors, RowError{Symbol: symbol, Timestamp: timestamp, Err: err})
+			continue
+		}
+		// The year.week stored by the collector, or derived from the date for older rows.
+		yearWeek := row.YearWeek
+		if yearWeek == "" || loc != nil {
+			// The one stored is the one of the date in UTC.
+			yearWeek = yearWeekIn(date, loc)
 		}
 
 		// Initialize a new CryptoOutput for the symbol if it doesn't already exist.
@@ -62,62 +235,507 @@ func fetchData(db *sql.DB) (map[string]*CryptoOutput, error) {
 			results[symbol] = &CryptoOutput{
 				Code:     symbol,
 				Prices:   []PriceEntry{},
-				Category: "crypto",
-				Mode:     "year.week",
+				Category: outputCategory,
+				Mode:     outputMode,
 			}
 		}
 
 		// Append the new price entry to the symbol's prices.
-		results[symbol].Prices = append(results[symbol].Prices, PriceEntry{YearWeek: yearWeek, Value: value})
+		results[symbol].Prices = append(results[symbol].Prices, PriceEntry{YearWeek: yearWeek, Value: row.Value, Missing: row.Missing, date: date})
 	}
 
-	return results, nil // Return the organized data.
+	return results, rows, rowErrors, nil // Return the organized data.
 }
 
-// writeJSON takes the organized data and writes it to a JSON file specified by filePath.
-func writeJSON(data map[string]*CryptoOutput, filePath string) error {
-	// Open or create the file for writing, truncating it if it already exists.
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("error opening JSON file: %w", err)
+// snakeCryptoOutput is a CryptoOutput with the field names of ProfileSnake.
+type snakeCryptoOutput struct {
+	Code     string            `json:"code"`
+	Prices   []snakePriceEntry `json:"prices"`
+	Category string            `json:"category"`
+	Mode     string            `json:"mode"`
+}
+
+// snakePriceEntry is a PriceEntry with the field names of ProfileSnake.
+type snakePriceEntry struct {
+	YearWeek string   `json:"year_week"`
+	Value    *float64 `json:"value"` // null for the missing weeks.
+	Filled   bool     `json:"filled,omitempty"`
+}
+
+// isoCryptoOutput is a CryptoOutput with the field names of profileISODate.
+type isoCryptoOutput struct {
+	Code     string          `json:"code"`
+	Prices   []isoPriceEntry `json:"prices"`
+	Category string          `json:"category"`
+	Mode     string          `json:"mode"`
+}
+
+// isoPriceEntry is a PriceEntry with the date of the price instead of the week.
+type isoPriceEntry struct {
+	Date   string   `json:"date"`
+	Value  *float64 `json:"value"` // null for the missing weeks.
+	Filled bool     `json:"filled,omitempty"`
+}
+
+// withProfile returns the output as it's encoded in the given profile.
+func withProfile(output CryptoOutput, profile string) interface{} {
+	if profile == profileISODate {
+		iso := isoCryptoOutput{Code: output.Code, Prices: make([]isoPriceEntry, 0, len(output.Prices)), Category: output.Category, Mode: DateFormatISO}
+		for _, price := range output.Prices {
+			entry := isoPriceEntry{Date: price.date.Format("2006-01-02"), Filled: price.Filled}
+			if !price.Missing {
+				value := price.Value
+				entry.Value = &value
+			}
+			iso.Prices = append(iso.Prices, entry)
+		}
+		return iso
+	}
+	if profile != ProfileSnake {
+		return output
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "    ") // Set indentation for pretty JSON formatting.
+	snake := snakeCryptoOutput{Code: output.Code, Prices: make([]snakePriceEntry, 0, len(output.Prices)), Category: output.Category, Mode: output.Mode}
+	for _, price := range output.Prices {
+		entry := snakePriceEntry{YearWeek: price.YearWeek, Filled: price.Filled}
+		if !price.Missing {
+			value := price.Value
+			entry.Value = &value
+		}
+		snake.Prices = append(snake.Prices, entry)
+	}
+	return snake
+}
 
-	// Convert the map to a slice for a more natural JSON array format.
-	var outputs []CryptoOutput
+// sortedOutputs converts the map to a slice sorted by symbol, so the output is deterministic.
+func sortedOutputs(data map[string]*CryptoOutput) []CryptoOutput {
+	outputs := make([]CryptoOutput, 0, len(data))
 	for _, output := range data {
 		outputs = append(outputs, *output)
 	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Code < outputs[j].Code })
+	return outputs
+}
+
+// encodeJSON takes the organized data and writes it to w as JSON, with the field
+// names of the given profile.
+func encodeJSON(w io.Writer, data map[string]*CryptoOutput, profile string) error {
+	return encodeIndented(w, profiledOutputs(sortedOutputs(data), profile))
+}
+
+// encodeOutputs writes the outputs as a JSON array, with the field names of the given profile.
+func encodeOutputs(outputs []CryptoOutput, filePath string, profile string, mode os.FileMode) error {
+	return encodeJSONFile(profiledOutputs(outputs, profile), filePath, mode)
+}
+
+// profiledOutputs returns the outputs with the field names of the given profile.
+func profiledOutputs(outputs []CryptoOutput, profile string) []interface{} {
+	profiled := make([]interface{}, 0, len(outputs))
+	for _, output := range outputs {
+		profiled = append(profiled, withProfile(output, profile))
+	}
+	return profiled
+}
+
+// chunkPath returns the path of the numbered chunk of filePath, e.g. out-001.json for out.json.
+func chunkPath(filePath string, number int) string {
+	ext := filepath.Ext(filePath)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(filePath, ext), number, ext)
+}
+
+// writeChunkedJSON writes the sorted outputs in numbered files of at most size symbols each.
+// Existing files are only overwritten with force.
+func writeChunkedJSON(data map[string]*CryptoOutput, filePath string, size int, force bool, profile string, mode os.FileMode) error {
+	outputs := sortedOutputs(data)
+	for start, number := 0, 1; start < len(outputs); start, number = start+size, number+1 {
+		path := chunkPath(filePath, number)
+		if err := checkOverwrite(path, force); err != nil {
+			return err
+		}
+		if err := encodeOutputs(outputs[start:min(start+size, len(outputs))], path, profile, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeTuplesJSON writes the data to w as an object mapping each symbol to its
+// [timestamp, value] pairs, sorted chronologically. Timestamps are Unix milliseconds.
+func encodeTuplesJSON(w io.Writer, data map[string]*CryptoOutput) error {
+	tuples := make(map[string][][2]interface{}, len(data))
+	for symbol, output := range data {
+		prices := make([]PriceEntry, len(output.Prices))
+		copy(prices, output.Prices)
+		sort.Slice(prices, func(i, j int) bool { return prices[i].date.Before(prices[j].date) })
+
+		pairs := make([][2]interface{}, 0, len(prices))
+		for _, price := range prices {
+			var value interface{} = price.Value
+			if price.Missing {
+				value = nil // Encoded as null.
+			}
+			pairs = append(pairs, [2]interface{}{price.date.UnixMilli(), value})
+		}
+		tuples[symbol] = pairs
+	}
+
+	return encodeIndented(w, tuples)
+}
+
+// writeSplitJSON writes each CryptoOutput to its own <symbol>.json file in dir,
+// creating the directory if needed. Existing files are only overwritten with force.
+// Up to parallel files are written at the same time, 1 (or less) writes them one by one.
+func writeSplitJSON(data map[string]*CryptoOutput, dir string, force bool, profile string, parallel int, mode os.FileMode) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+
+	// Every file is checked before writing any of them.
+	for symbol := range data {
+		// The symbol becomes a file name, it must not escape the directory.
+		if symbol == "" || symbol == "." || symbol == ".." || strings.ContainsAny(symbol, `/\`) {
+			return fmt.Errorf("symbol %q can't be used as a file name", symbol)
+		}
+		if err := checkOverwrite(filepath.Join(dir, symbol+".json"), force); err != nil {
+			return err
+		}
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	semaphore := make(chan struct{}, parallel)
+	errs := make(chan error, len(data))
+	var wg sync.WaitGroup
+	for symbol, output := range data {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(filePath string, output CryptoOutput) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if err := encodeJSONFile(withProfile(output, profile), filePath, mode); err != nil {
+				errs <- err
+			}
+		}(filepath.Join(dir, symbol+".json"), *output)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs // The first error, if any.
+}
+
+// rename moves the finished temporary file into place, a variable so tests can make it fail.
+var rename = os.Rename
+
+// outputFile is the temporary file an export is written to.
+type outputFile interface {
+	io.WriteCloser
+	Name() string
+	Chmod(mode os.FileMode) error
+}
 
-	// Encode the data as JSON and write it to the file.
-	if err := encoder.Encode(outputs); err != nil {
+// createTemp creates the temporary file of an export, like os.CreateTemp. A variable
+// so tests can make writing it fail.
+var createTemp = func(dir, pattern string) (outputFile, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+// encodeJSONFile writes v as indented JSON to the file specified by filePath, with the given mode.
+func encodeJSONFile(v interface{}, filePath string, mode os.FileMode) error {
+	return writeFileAtomically(filePath, mode, func(w io.Writer) error {
+		return encodeIndented(w, v)
+	})
+}
+
+// encodeIndented writes v to w as indented JSON.
+func encodeIndented(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ") // Set indentation for pretty JSON formatting.
+
+	// Encode the data as JSON and write it.
+	if err := encoder.Encode(v); err != nil {
 		return fmt.Errorf("error encoding data to JSON: %w", err)
 	}
+	return nil
+}
+
+// writeFileAtomically writes the file specified by filePath with write, and gives it the mode.
+// The content is written to a temporary file in the same directory, which is renamed
+// to filePath once complete, so filePath never holds a partial export.
+// The writes are buffered, the buffer is flushed and the file closed before the rename,
+// and an error doing so fails the export.
+func writeFileAtomically(filePath string, mode os.FileMode, write func(w io.Writer) error) error {
+	file, err := createTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error opening output file: %w", err)
+	}
+	defer os.Remove(file.Name()) // Nothing to remove once renamed.
+	defer file.Close()           // Only on the errors before the Close below.
 
+	buffered := bufio.NewWriter(file)
+	if err := write(buffered); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	if err := file.Chmod(mode); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+
+	if err := rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("error moving output file into place: %w", err)
+	}
 	return nil // Return nil on success.
 }
 
+// computeStats counts the symbols and price entries of the organized data.
+func computeStats(data map[string]*CryptoOutput) ExportStats {
+	stats := ExportStats{Symbols: len(data)}
+	for _, output := range data {
+		stats.Entries += len(output.Prices)
+	}
+	return stats
+}
+
+// checkOverwrite returns a FileSystemError if the file at filePath exists and force is not set.
+func checkOverwrite(filePath string, force bool) error {
+	if force {
+		return nil
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		return collector.FileSystemError{Msg: fmt.Sprintf("the file %s already exists, use --force to overwrite it", filePath)}
+	}
+	return nil
+}
+
+// trackedSymbols returns the symbols of the currency lists, read like the collector does.
+// When symbols is not empty, only the ones in both are returned.
+func trackedSymbols(currencyLists []string, symbols []string) ([]string, error) {
+	c := collector.Collector{CurrencyListFilePath: currencyLists[0], ExtraCurrencyListFilePaths: currencyLists[1:]}
+	records, err := c.ReadCurrencyList()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		wanted[symbol] = true
+	}
+	var tracked []string
+	for _, record := range records[1:] { // The first row is the header.
+		if len(symbols) == 0 || wanted[record[0]] {
+			tracked = append(tracked, record[0])
+		}
+	}
+	if len(tracked) == 0 {
+		// An empty list of symbols would export all of them.
+		return nil, fmt.Errorf("none of the symbols %v is in the currency lists", symbols)
+	}
+	return tracked, nil
+}
+
 // ExportToJSON orchestrates the data export process: fetching from the database and writing to JSON.
+// An existing output file is not overwritten.
 func ExportToJSON(dbPath, outputPath string) error {
+	_, err := Export(dbPath, outputPath, Options{})
+	return err
+}
+
+// Export works like ExportToJSON, with the output configured by opts.
+// It returns the stats of the exported data, which in dry-run mode is all it does.
+// Unless opts.Force is set, it refuses to overwrite an existing output file.
+func Export(dbPath, outputPath string, opts Options) (ExportStats, error) {
+	return ExportContext(context.Background(), dbPath, outputPath, opts)
+}
+
+// ExportContext works like Export, aborting the queries to the database once ctx is done.
+func ExportContext(ctx context.Context, dbPath, outputPath string, opts Options) (ExportStats, error) {
 	db, err := sql.Open("sqlite3", dbPath) // Open the SQLite database.
 	if err != nil {
-		return fmt.Errorf("error opening database: %w", err)
+		return ExportStats{}, fmt.Errorf("error opening database: %w", err)
 	}
 	defer db.Close() // Ensure the database is closed when done.
 
-	data, err := fetchData(db) // Fetch data from the database.
+	return ExportDb(ctx, db, outputPath, opts)
+}
+
+// ExportDb works like ExportContext, reading from a database that is already open, e.g. the
+// in-memory one of the collector (see collector.OpenMemoryDb). The database is left open.
+func ExportDb(ctx context.Context, db *sql.DB, outputPath string, opts Options) (ExportStats, error) {
+	switch opts.Profile {
+	case "", ProfileDefault, ProfileSnake:
+	default:
+		return ExportStats{}, fmt.Errorf("unknown profile %q, valid profiles are %q and %q", opts.Profile, ProfileDefault, ProfileSnake)
+	}
+	profile := opts.Profile
+	switch opts.DateFormat {
+	case "", DateFormatYearWeek:
+	case DateFormatISO:
+		if opts.Shape == ShapeTuples {
+			return ExportStats{}, fmt.Errorf("the %q shape has Unix timestamps, it has no date format", ShapeTuples)
+		}
+		// Both profiles name the field of the date the same.
+		profile = profileISODate
+	default:
+		return ExportStats{}, fmt.Errorf("unknown date format %q, valid formats are %q and %q", opts.DateFormat, DateFormatYearWeek, DateFormatISO)
+	}
+
+	var err error
+	filter := opts.Filter
+
+	mode := opts.FileMode
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+	if mode&^os.ModePerm != 0 {
+		return ExportStats{}, fmt.Errorf("invalid file mode %v, only the permission bits can be set", mode)
+	}
+
+	// The rows read from the database, once they're fetched.
+	var rows []Row
+	encode := func(w io.Writer, data map[string]*CryptoOutput) error {
+		return encodeJSON(w, data, profile)
+	}
+	switch opts.Shape {
+	case "", ShapeObjects:
+	case ShapeTuples:
+		encode = encodeTuplesJSON
+	default:
+		return ExportStats{}, fmt.Errorf("unknown shape %q, valid shapes are %q and %q", opts.Shape, ShapeObjects, ShapeTuples)
+	}
+	switch opts.Format {
+	case "", FormatJSON:
+	case FormatCSV:
+		if opts.Shape == ShapeTuples || opts.Chunk > 0 || opts.SplitBySymbol {
+			return ExportStats{}, fmt.Errorf("the %q format is a single file without shape", FormatCSV)
+		}
+		encode = func(w io.Writer, data map[string]*CryptoOutput) error {
+			return encodeCSV(w, data, !opts.NoHeader, opts.DateFormat == DateFormatISO)
+		}
+	case FormatParquet:
+		if opts.Shape == ShapeTuples || opts.Chunk > 0 || opts.SplitBySymbol {
+			return ExportStats{}, fmt.Errorf("the %q format is a single file without shape", FormatParquet)
+		}
+		encode = encodeParquet
+	case FormatSQL:
+		if opts.Shape == ShapeTuples || opts.Chunk > 0 || opts.SplitBySymbol {
+			return ExportStats{}, fmt.Errorf("the %q format is a single file without shape", FormatSQL)
+		}
+		if opts.Fill == FillForward || len(opts.Weights) > 0 {
+			return ExportStats{}, fmt.Errorf("the %q format has the stored rows, they can't be filled or weighted", FormatSQL)
+		}
+		encode = func(w io.Writer, _ map[string]*CryptoOutput) error {
+			// The same rows the stats come from. The ones that can't be read are
+			// already in the stats, or an error with Strict.
+			return encodeSQL(w, rows)
+		}
+	default:
+		return ExportStats{}, fmt.Errorf("unknown format %q, valid formats are %q, %q, %q and %q", opts.Format, FormatJSON, FormatCSV, FormatParquet, FormatSQL)
+	}
+	switch opts.Fill {
+	case "", FillNone, FillForward:
+	default:
+		return ExportStats{}, fmt.Errorf("unknown fill %q, valid options are %q and %q", opts.Fill, FillNone, FillForward)
+	}
+	if opts.Chunk < 0 {
+		return ExportStats{}, fmt.Errorf("invalid chunk size %d", opts.Chunk)
+	}
+	if opts.Chunk > 0 && (opts.Shape == ShapeTuples || opts.SplitBySymbol) {
+		return ExportStats{}, fmt.Errorf("only the %q shape in a single file can be chunked", ShapeObjects)
+	}
+	if opts.Writer != nil && (opts.Chunk > 0 || opts.SplitBySymbol) {
+		return ExportStats{}, fmt.Errorf("a writer only takes a single output, it can't be chunked or split by symbol")
+	}
+	if opts.SplitBySymbol {
+		if opts.Shape == ShapeTuples {
+			return ExportStats{}, fmt.Errorf("the %q shape can't be split by symbol", ShapeTuples)
+		}
+		if opts.OutDir == "" {
+			return ExportStats{}, fmt.Errorf("an output directory is needed to split by symbol")
+		}
+	}
+
+	if len(opts.Weights) > 0 && len(filter.Symbols) == 0 {
+		// Only the symbols of the index are needed.
+		for symbol := range opts.Weights {
+			filter.Symbols = append(filter.Symbols, symbol)
+		}
+		sort.Strings(filter.Symbols)
+	}
+	if len(opts.CurrencyLists) > 0 {
+		filter.Symbols, err = trackedSymbols(opts.CurrencyLists, filter.Symbols)
+		if err != nil {
+			return ExportStats{}, err
+		}
+	}
+
+	data, rows, rowErrors, err := fetchData(ctx, db, filter, opts.Strict, opts.Location) // Fetch data from the database.
 	if err != nil {
-		return err // Return early if there's an error.
+		return ExportStats{}, err // Return early if there's an error.
+	}
+
+	if opts.Fill == FillForward {
+		fillForward(data, opts.Location)
+	}
+	if len(opts.Weights) > 0 {
+		data, err = weightedIndex(data, opts.Weights)
+		if err != nil {
+			return ExportStats{}, err
+		}
+	}
+
+	stats := computeStats(data)
+	stats.Skipped, stats.RowErrors = len(rowErrors), rowErrors
+	if opts.DryRun {
+		return stats, nil // Nothing is written in dry-run mode.
+	}
+
+	if opts.SplitBySymbol {
+		if err := writeSplitJSON(data, opts.OutDir, opts.Force, profile, opts.Parallel, mode); err != nil {
+			return stats, err
+		}
+		fmt.Println("Data exported successfully to", opts.OutDir)
+		return stats, nil
+	}
+
+	if opts.Chunk > 0 {
+		if err := writeChunkedJSON(data, outputPath, opts.Chunk, opts.Force, profile, mode); err != nil {
+			return stats, err
+		}
+		fmt.Println("Data exported successfully to", chunkPath(outputPath, 1), "and the following chunks")
+		return stats, nil
+	}
+
+	if opts.Writer != nil {
+		// The output goes to the writer, outputPath is not used.
+		if err := encode(opts.Writer, data); err != nil {
+			return stats, err
+		}
+		// Buffered writers, like the ones of bufio or gzip, are flushed.
+		if flusher, ok := opts.Writer.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				return stats, fmt.Errorf("error flushing the output: %w", err)
+			}
+		}
+		return stats, nil
+	}
+
+	if err := checkOverwrite(outputPath, opts.Force); err != nil {
+		return stats, err
 	}
 
 	// Write the fetched data to the specified JSON file.
-	if err := writeJSON(data, outputPath); err != nil {
-		return err // Return early if there's an error.
+	err = writeFileAtomically(outputPath, mode, func(w io.Writer) error {
+		return encode(w, data)
+	})
+	if err != nil {
+		return stats, err // Return early if there's an error.
 	}
 
 	fmt.Println("Data exported successfully to", outputPath) // Indicate success.
-	return nil                                               // Return nil on success.
+	return stats, nil                                        // Return the stats on success.
 }
@@ -1,9 +1,19 @@
 package exporter
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/agviu/investrends/collector"
 )
 
 // Assuming ExportToJSON, timestampToYearWeek, and other necessary functions are correctly implemented
@@ -64,3 +74,649 @@ func TestExportToJSON(t *testing.T) {
 		}
 	}
 }
+
+// createTestDb creates a SQLite database in a temporary directory with the given price rows.
+func createTestDb(t *testing.T, rows [][]interface{}) string {
+	dbPath := filepath.Join(t.TempDir(), "crypto.sqlite")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE crypto_prices (id INTEGER PRIMARY KEY, symbol TEXT, timestamp TEXT, value REAL, UNIQUE(symbol, timestamp))")
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	for _, row := range rows {
+		if _, err := db.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES (?, ?, ?)", row...); err != nil {
+			t.Fatalf("Failed to insert test row: %v", err)
+		}
+	}
+	return dbPath
+}
+
+// Verifies the tuples shape: symbols mapped to chronologically sorted [timestamp, value] pairs.
+func TestExportTuplesShape(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+		{"BTC", "2023-06-18", 26000.0},
+		{"BTC", "2023-06-25", 27000.0},
+		{"ETH", "2023-07-02", 1800.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	if _, err := Export(dbPath, outputPath, Options{Shape: ShapeTuples}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	file, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var output map[string][][2]float64
+	if err := json.Unmarshal(file, &output); err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+
+	btc := output["BTC"]
+	if len(btc) != 3 {
+		t.Fatalf("Expected 3 prices for BTC, got %d", len(btc))
+	}
+	expected := []float64{26000, 27000, 28000}
+	for i, pair := range btc {
+		if pair[1] != expected[i] {
+			t.Errorf("Expected value %v at position %d, got %v", expected[i], i, pair[1])
+		}
+		if i > 0 && pair[0] <= btc[i-1][0] {
+			t.Errorf("Expected timestamps in chronological order, got %v after %v", pair[0], btc[i-1][0])
+		}
+	}
+	if first := time.UnixMilli(int64(btc[0][0])).UTC().Format("2006-01-02"); first != "2023-06-18" {
+		t.Errorf("Expected the first timestamp to be 2023-06-18, got %s", first)
+	}
+	if len(output["ETH"]) != 1 {
+		t.Errorf("Expected 1 price for ETH, got %d", len(output["ETH"]))
+	}
+
+	if _, err := Export(dbPath, outputPath, Options{Shape: "squares"}); err == nil {
+		t.Errorf("Expected an error for an unknown shape")
+	}
+}
+
+// Verifies that the dry-run mode reports the stats without writing the output file.
+func TestExportDryRun(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "2023-07-02", 28000.0},
+		{"ETH", "2023-07-02", 1800.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	stats, err := Export(dbPath, outputPath, Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Symbols != 2 || stats.Entries != 3 {
+		t.Errorf("Expected 2 symbols and 3 entries, got %+v", stats)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file in dry-run mode, got %v", err)
+	}
+}
+
+// Verifies that a failed export leaves the previous file untouched and no temporary file behind.
+func TestExportAtomicWrite(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+	})
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "output.json")
+	if err := os.WriteFile(outputPath, []byte("previous export"), 0644); err != nil {
+		t.Fatalf("Failed to write the previous export: %v", err)
+	}
+
+	defer func() { rename = os.Rename }()
+	rename = func(oldpath, newpath string) error {
+		return errors.New("interrupted")
+	}
+	if _, err := Export(dbPath, outputPath, Options{Force: true}); err == nil {
+		t.Fatalf("Expected the export to fail before the rename")
+	}
+
+	file, err := os.ReadFile(outputPath)
+	if err != nil || string(file) != "previous export" {
+		t.Errorf("Expected the previous export to be untouched, got %q, %v", file, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the previous export in the directory, got %d files", len(entries))
+	}
+
+	rename = os.Rename
+	if _, err := Export(dbPath, outputPath, Options{Force: true}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected the new export in place with mode 0644, got %v", err)
+	}
+}
+
+// failingFile is an output file whose writes or close fail.
+type failingFile struct {
+	*os.File
+	writeErr, closeErr error
+}
+
+func (f failingFile) Write(p []byte) (int, error) {
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	return f.File.Write(p)
+}
+
+func (f failingFile) Close() error {
+	f.File.Close()
+	return f.closeErr
+}
+
+// failingFlusher is a buffered writer whose flush fails.
+type failingFlusher struct {
+	bytes.Buffer
+}
+
+func (f *failingFlusher) Flush() error {
+	return errors.New("disk full")
+}
+
+// Verifies that an error flushing or closing the output fails the export.
+func TestExportFlushAndCloseErrors(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+	})
+	defer func() {
+		createTemp = func(dir, pattern string) (outputFile, error) { return os.CreateTemp(dir, pattern) }
+	}()
+
+	for _, fail := range []failingFile{{writeErr: errors.New("disk full")}, {closeErr: errors.New("disk full")}} {
+		createTemp = func(dir, pattern string) (outputFile, error) {
+			file, err := os.CreateTemp(dir, pattern)
+			fail.File = file
+			return fail, err
+		}
+		outputPath := filepath.Join(t.TempDir(), "output.json")
+		if err := ExportToJSON(dbPath, outputPath); err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Errorf("Expected the error of the output file to be returned, got %v", err)
+		}
+		if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+			t.Errorf("Expected no output file after the error, got %v", err)
+		}
+	}
+
+	if _, err := Export(dbPath, "", Options{Writer: &failingFlusher{}}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Expected the error flushing the writer to be returned, got %v", err)
+	}
+}
+
+// Verifies that the exported files get the file mode of the options.
+func TestExportFileMode(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+	})
+
+	mode, err := ParseFileMode("0600")
+	if err != nil {
+		t.Fatalf("Failed to parse the file mode: %v", err)
+	}
+	for _, format := range []string{FormatJSON, FormatCSV} {
+		outputPath := filepath.Join(t.TempDir(), "output."+format)
+		if _, err := Export(dbPath, outputPath, Options{Format: format, FileMode: mode}); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to stat the output file: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the %s export with mode 0600, got %v", format, info.Mode().Perm())
+		}
+	}
+
+	for _, invalid := range []string{"rw-r--r--", "0999", "10644", ""} {
+		if _, err := ParseFileMode(invalid); err == nil {
+			t.Errorf("Expected an error parsing the file mode %q", invalid)
+		}
+	}
+}
+
+// Verifies that rows with an unparseable timestamp are skipped, unless in strict mode.
+func TestExportSkipsBadTimestamps(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "not a date", 1.0},
+		{"BTC", "2023-07-02", 28000.0},
+		{"ETH", "2023-07-02", 1800.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	stats, err := Export(dbPath, outputPath, Options{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Symbols != 2 || stats.Entries != 3 || stats.Skipped != 1 {
+		t.Errorf("Expected 2 symbols, 3 entries and 1 skipped row, got %+v", stats)
+	}
+
+	file, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var output []CryptoOutput
+	if err := json.Unmarshal(file, &output); err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+	if len(output) != 2 || output[0].Code != "BTC" || len(output[0].Prices) != 2 {
+		t.Errorf("Expected the two valid BTC prices to be exported, got %+v", output)
+	}
+
+	if _, err := Export(dbPath, outputPath, Options{Strict: true, Force: true}); err == nil {
+		t.Errorf("Expected an error for the unparseable timestamp in strict mode")
+	}
+}
+
+// Verifies that every bad row is reported, while the good ones are exported.
+func TestExportReportsRowErrors(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "not a date", 1.0},
+		{"ETH", "2023-06-25", "not a value"},
+		{"ETH", nil, 1800.0},
+		{"ETH", "2023-07-02", 1900.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	stats, err := Export(dbPath, outputPath, Options{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Symbols != 2 || stats.Entries != 2 || stats.Skipped != 3 || len(stats.RowErrors) != 3 {
+		t.Fatalf("Expected 2 symbols, 2 entries and 3 skipped rows, got %+v", stats)
+	}
+	reported := map[string]bool{}
+	for _, rowError := range stats.RowErrors {
+		reported[rowError.Symbol+" "+rowError.Timestamp] = true
+	}
+	for _, row := range []string{"BTC not a date", "ETH 2023-06-25", "ETH "} {
+		if !reported[row] {
+			t.Errorf("Expected the row %q to be reported, got %v", row, stats.RowErrors)
+		}
+	}
+}
+
+// Verifies that an existing output file is only overwritten with Force.
+func TestExportForce(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+	if err := os.WriteFile(outputPath, []byte("previous export"), 0644); err != nil {
+		t.Fatalf("Failed to write the previous export: %v", err)
+	}
+
+	_, err := Export(dbPath, outputPath, Options{})
+	var fsErr collector.FileSystemError
+	if !errors.As(err, &fsErr) {
+		t.Fatalf("Expected a FileSystemError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected the error to suggest --force, got %q", err.Error())
+	}
+	if content, _ := os.ReadFile(outputPath); string(content) != "previous export" {
+		t.Errorf("The previous export should be kept, got %q", content)
+	}
+
+	if _, err := Export(dbPath, outputPath, Options{Force: true}); err != nil {
+		t.Fatalf("Export with Force failed: %v", err)
+	}
+	if content, _ := os.ReadFile(outputPath); !strings.Contains(string(content), "BTC") {
+		t.Errorf("Expected the export to be overwritten, got %q", content)
+	}
+}
+
+// Verifies that splitting by symbol writes a file per symbol with its own data.
+func TestExportSplitBySymbol(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "2023-07-02", 28000.0},
+		{"ETH", "2023-07-02", 1800.0},
+	})
+	outDir := filepath.Join(t.TempDir(), "symbols")
+
+	stats, err := Export(dbPath, "", Options{SplitBySymbol: true, OutDir: outDir})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Symbols != 2 || stats.Entries != 3 {
+		t.Errorf("Expected 2 symbols and 3 entries, got %+v", stats)
+	}
+
+	entries := map[string]int{"BTC": 2, "ETH": 1}
+	for symbol, count := range entries {
+		file, err := os.ReadFile(filepath.Join(outDir, symbol+".json"))
+		if err != nil {
+			t.Fatalf("Failed to read the file of %s: %v", symbol, err)
+		}
+		var output CryptoOutput
+		if err := json.Unmarshal(file, &output); err != nil {
+			t.Fatalf("Failed to unmarshal the file of %s: %v", symbol, err)
+		}
+		if output.Code != symbol || len(output.Prices) != count || output.Category != "crypto" || output.Mode != "year.week" {
+			t.Errorf("Unexpected content for %s: %+v", symbol, output)
+		}
+	}
+
+	// The files are not overwritten without Force.
+	if _, err := Export(dbPath, "", Options{SplitBySymbol: true, OutDir: outDir}); err == nil {
+		t.Error("Expected an error overwriting the files without Force")
+	}
+	if _, err := Export(dbPath, "", Options{SplitBySymbol: true, OutDir: outDir, Force: true}); err != nil {
+		t.Errorf("Export with Force failed: %v", err)
+	}
+}
+
+// Verifies that the files of a split export written in parallel are all complete.
+func TestExportSplitBySymbolParallel(t *testing.T) {
+	var rows [][]interface{}
+	for i := 0; i < 50; i++ {
+		rows = append(rows, []interface{}{fmt.Sprintf("S%02d", i), "2023-07-02", float64(i + 1)})
+	}
+	dbPath := createTestDb(t, rows)
+	outDir := filepath.Join(t.TempDir(), "split")
+
+	if _, err := Export(dbPath, "", Options{SplitBySymbol: true, OutDir: outDir, Parallel: 4}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		symbol := fmt.Sprintf("S%02d", i)
+		file, err := os.ReadFile(filepath.Join(outDir, symbol+".json"))
+		if err != nil {
+			t.Fatalf("Failed to read the file of %s: %v", symbol, err)
+		}
+		var output CryptoOutput
+		if err := json.Unmarshal(file, &output); err != nil {
+			t.Fatalf("Failed to unmarshal the file of %s: %v", symbol, err)
+		}
+		if output.Code != symbol || len(output.Prices) != 1 || output.Prices[0].Value != float64(i+1) {
+			t.Errorf("Unexpected content for %s: %+v", symbol, output)
+		}
+	}
+}
+
+// Verifies the JSON keys of the default and snake profiles.
+func TestExportProfiles(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+	})
+
+	expected := map[string][]string{
+		ProfileDefault: {"code", "prices", "category", "mode", "year.week", "value"},
+		ProfileSnake:   {"code", "prices", "category", "mode", "year_week", "value"},
+	}
+	for profile, keys := range expected {
+		outputPath := filepath.Join(t.TempDir(), "output.json")
+		if _, err := Export(dbPath, outputPath, Options{Profile: profile}); err != nil {
+			t.Fatalf("Export with profile %s failed: %v", profile, err)
+		}
+		file, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+
+		var output []map[string]interface{}
+		if err := json.Unmarshal(file, &output); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		price := output[0]["prices"].([]interface{})[0].(map[string]interface{})
+		for _, key := range keys {
+			_, inOutput := output[0][key]
+			_, inPrice := price[key]
+			if !inOutput && !inPrice {
+				t.Errorf("Profile %s: expected the key %q", profile, key)
+			}
+		}
+		if len(output[0])+len(price) != len(keys) {
+			t.Errorf("Profile %s: unexpected keys %v %v", profile, output[0], price)
+		}
+	}
+
+	if _, err := Export(dbPath, filepath.Join(t.TempDir(), "output.json"), Options{Profile: "camel"}); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+// Verifies the prices of a symbol with each date format.
+func TestExportDateFormat(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "2023-07-02", nil},
+	})
+
+	expected := map[string]string{
+		DateFormatYearWeek: `[{"code":"BTC","prices":[{"year.week":"2023.25","value":27000},{"year.week":"2023.26","value":null}],"category":"crypto","mode":"year.week"}]`,
+		DateFormatISO:      `[{"code":"BTC","prices":[{"date":"2023-06-25","value":27000},{"date":"2023-07-02","value":null}],"category":"crypto","mode":"iso-date"}]`,
+	}
+	for dateFormat, want := range expected {
+		outputPath := filepath.Join(t.TempDir(), "output.json")
+		if _, err := Export(dbPath, outputPath, Options{DateFormat: dateFormat}); err != nil {
+			t.Fatalf("Export with the date format %s failed: %v", dateFormat, err)
+		}
+		file, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, file); err != nil {
+			t.Fatalf("Failed to compact the JSON: %v", err)
+		}
+		if compact.String() != want {
+			t.Errorf("Date format %s: expected %s, got %s", dateFormat, want, compact.String())
+		}
+	}
+
+	if _, err := Export(dbPath, filepath.Join(t.TempDir(), "output.json"), Options{DateFormat: "unix"}); err == nil {
+		t.Error("Expected an error for an unknown date format")
+	}
+}
+
+// Verifies that only the symbols of the currency list are exported.
+func TestExportCurrencyLists(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+		{"ETH", "2023-07-02", 1800.0},
+		{"OLD", "2023-07-02", 1.0},
+	})
+	listPath := filepath.Join(t.TempDir(), "currencies.csv")
+	if err := os.WriteFile(listPath, []byte("currency code,currency name\nBTC,Bitcoin\nETH,Ethereum\nADA,Cardano\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+	stats, err := Export(dbPath, outputPath, Options{CurrencyLists: []string{listPath}})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	file, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var output []CryptoOutput
+	if err := json.Unmarshal(file, &output); err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+	if stats.Symbols != 2 || len(output) != 2 || output[0].Code != "BTC" || output[1].Code != "ETH" {
+		t.Errorf("Expected only BTC and ETH to be exported, got %+v", output)
+	}
+
+	opts := Options{CurrencyLists: []string{listPath}, Filter: RowFilter{Symbols: []string{"OLD"}}, DryRun: true}
+	if _, err := Export(dbPath, outputPath, opts); err == nil {
+		t.Error("Expected an error when none of the symbols is in the currency list")
+	}
+}
+
+// Verifies that chunking writes numbered files with at most the given number of symbols.
+func TestExportChunk(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+		{"ETH", "2023-07-02", 1800.0},
+		{"ADA", "2023-07-02", 0.3},
+		{"SOL", "2023-07-02", 20.0},
+		{"DOT", "2023-07-02", 5.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "out.json")
+
+	if _, err := Export(dbPath, outputPath, Options{Chunk: 2}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	expected := [][]string{{"ADA", "BTC"}, {"DOT", "ETH"}, {"SOL"}}
+	for i, codes := range expected {
+		file, err := os.ReadFile(chunkPath(outputPath, i+1))
+		if err != nil {
+			t.Fatalf("Failed to read chunk %d: %v", i+1, err)
+		}
+		var output []CryptoOutput
+		if err := json.Unmarshal(file, &output); err != nil {
+			t.Fatalf("Failed to unmarshal chunk %d: %v", i+1, err)
+		}
+		if len(output) != len(codes) {
+			t.Fatalf("Expected %d symbols in chunk %d, got %d", len(codes), i+1, len(output))
+		}
+		for j, code := range codes {
+			if output[j].Code != code {
+				t.Errorf("Expected %s in chunk %d, got %s", code, i+1, output[j].Code)
+			}
+		}
+	}
+	if _, err := os.Stat(chunkPath(outputPath, 4)); !os.IsNotExist(err) {
+		t.Errorf("Expected only 3 chunks, got %v", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no unchunked file, got %v", err)
+	}
+}
+
+// Verifies that the fill forward option carries the previous value into the missing weeks, flagging them.
+func TestExportFillForward(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+		{"BTC", "2023-06-18", 27000.0},
+		{"BTC", "2023-07-09", nil},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	stats, err := Export(dbPath, outputPath, Options{Fill: FillForward})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Entries != 4 {
+		t.Errorf("Expected 4 entries, got %d", stats.Entries)
+	}
+
+	file, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file); err != nil {
+		t.Fatalf("Failed to compact the JSON: %v", err)
+	}
+	want := `[{"code":"BTC","prices":[{"year.week":"2023.24","value":27000},{"year.week":"2023.25","value":27000,"filled":true},{"year.week":"2023.26","value":28000},{"year.week":"2023.27","value":28000,"filled":true}],"category":"crypto","mode":"year.week"}]`
+	if compact.String() != want {
+		t.Errorf("Expected %s, got %s", want, compact.String())
+	}
+
+	if _, err := Export(dbPath, filepath.Join(t.TempDir(), "output.json"), Options{Fill: "backward"}); err == nil {
+		t.Error("Expected an error for an unknown fill")
+	}
+}
+
+// Verifies that the weighted index sums the weighted values of its symbols, only in the weeks all of them have.
+func TestExportWeightedIndex(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 100.0},
+		{"BTC", "2023-07-02", 200.0},
+		{"BTC", "2023-07-09", nil},
+		{"ETH", "2023-06-25", 10.0},
+		{"ETH", "2023-07-02", 20.0},
+		{"ETH", "2023-07-09", 30.0},
+		{"SOL", "2023-06-25", 5.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	stats, err := Export(dbPath, outputPath, Options{Weights: map[string]float64{"BTC": 0.5, "ETH": 2}})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Symbols != 1 || stats.Entries != 2 {
+		t.Errorf("Expected a single symbol with 2 entries, got %d and %d", stats.Symbols, stats.Entries)
+	}
+
+	file, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file); err != nil {
+		t.Fatalf("Failed to compact the JSON: %v", err)
+	}
+	want := `[{"code":"INDEX","prices":[{"year.week":"2023.25","value":70},{"year.week":"2023.26","value":140}],"category":"crypto","mode":"year.week"}]`
+	if compact.String() != want {
+		t.Errorf("Expected %s, got %s", want, compact.String())
+	}
+
+	if _, err := Export(dbPath, filepath.Join(t.TempDir(), "output.json"), Options{Weights: map[string]float64{"BTC": 1, "ADA": 1}}); err == nil {
+		t.Error("Expected an error for a symbol of the index without prices")
+	}
+}
+
+// Verifies that with a location the weeks are the ones of the dates there, so a Monday
+// at midnight UTC is still in the week before in New York.
+func TestExportLocation(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-26", 27000.0}, // Monday, week 26 in UTC.
+		{"BTC", "2023-07-05", 28000.0}, // Wednesday, week 27 anywhere.
+	})
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	for _, tc := range []struct {
+		location *time.Location
+		weeks    []string
+	}{
+		{nil, []string{"2023.26", "2023.27"}},
+		{newYork, []string{"2023.25", "2023.27"}},
+	} {
+		outputPath := filepath.Join(t.TempDir(), "output.json")
+		if _, err := Export(dbPath, outputPath, Options{Location: tc.location}); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		content, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		var outputs []CryptoOutput
+		if err := json.Unmarshal(content, &outputs); err != nil {
+			t.Fatalf("Failed to unmarshal output: %v", err)
+		}
+		var weeks []string
+		for _, price := range outputs[0].Prices {
+			weeks = append(weeks, price.YearWeek)
+		}
+		if !reflect.DeepEqual(weeks, tc.weeks) {
+			t.Errorf("Expected the weeks %v in %v, got %v", tc.weeks, tc.location, weeks)
+		}
+	}
+}
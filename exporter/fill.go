@@ -0,0 +1,44 @@
+package exporter
+
+import (
+	"sort"
+	"time"
+)
+
+// Ways to fill the weeks without value.
+const (
+	FillNone    = "none"    // The gaps are left as they are, the default.
+	FillForward = "forward" // The previous known value is carried forward.
+)
+
+// week is the time between two prices of the collector.
+const week = 7 * 24 * time.Hour
+
+// fillForward sorts the prices of every symbol chronologically and fills the weeks
+// without value, between the first and the last price, with the previous known
+// value. The weeks not stored and the ones stored as missing are filled, and
+// marked as Filled. The weeks filled get the year.week in loc, see yearWeekIn.
+func fillForward(data map[string]*CryptoOutput, loc *time.Location) {
+	for _, output := range data {
+		prices := make([]PriceEntry, len(output.Prices))
+		copy(prices, output.Prices)
+		sort.SliceStable(prices, func(i, j int) bool { return prices[i].date.Before(prices[j].date) })
+
+		filled := make([]PriceEntry, 0, len(prices))
+		var previous *PriceEntry
+		for _, price := range prices {
+			if previous != nil {
+				// The weeks not stored between the previous price and this one.
+				for date := previous.date.Add(week); date.Before(price.date); date = date.Add(week) {
+					filled = append(filled, PriceEntry{YearWeek: yearWeekIn(date, loc), Value: previous.Value, Missing: previous.Missing, Filled: !previous.Missing, date: date})
+				}
+				if price.Missing && !previous.Missing {
+					price.Value, price.Missing, price.Filled = previous.Value, false, true
+				}
+			}
+			filled = append(filled, price)
+			previous = &filled[len(filled)-1]
+		}
+		output.Prices = filled
+	}
+}
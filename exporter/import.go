@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/agviu/investrends/collector"
+)
+
+// importEntry is a price of an exported JSON, in any of the profiles and date formats.
+type importEntry struct {
+	YearWeek      string   `json:"year.week"`
+	SnakeYearWeek string   `json:"year_week"`
+	Date          string   `json:"date"`
+	Value         *float64 `json:"value"` // null for the missing weeks.
+	Filled        bool     `json:"filled"`
+}
+
+// importOutput is a symbol of an exported JSON, in the objects shape.
+type importOutput struct {
+	Code   string        `json:"code"`
+	Prices []importEntry `json:"prices"`
+}
+
+// ImportFromJSON loads an export of the objects shape back into the database in dbPath,
+// creating it if needed, e.g. to restore a backup. The prices with a date (see
+// DateFormatISO) keep it, the rest get the Sunday of their year.week, the day of the
+// week of the prices of the API. The weeks filled on export are left out.
+// Prices already in the database are kept.
+func ImportFromJSON(jsonPath, dbPath string) error {
+	content, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return fmt.Errorf("error opening JSON file: %w", err)
+	}
+	var outputs []importOutput
+	if err := json.Unmarshal(content, &outputs); err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	var data []collector.CryptoDataCurated
+	for i, output := range outputs {
+		if output.Code == "" {
+			return fmt.Errorf("entry %d: empty code", i)
+		}
+		for _, price := range output.Prices {
+			if price.Filled {
+				continue
+			}
+			date := price.Date
+			if date == "" {
+				yearWeek := price.YearWeek
+				if yearWeek == "" {
+					yearWeek = price.SnakeYearWeek
+				}
+				date, err = yearWeekToDate(yearWeek)
+				if err != nil {
+					return fmt.Errorf("%s: %w", output.Code, err)
+				}
+			}
+			var value float64
+			if price.Value != nil {
+				value = *price.Value
+			}
+			data = append(data, collector.NewCryptoDataCurated(output.Code, date, value, price.Value == nil))
+		}
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer db.Close()
+	if _, err := collector.Migrate(db); err != nil {
+		return fmt.Errorf("error migrating the database: %w", err)
+	}
+	return collector.StoreData(db, data, "")
+}
+
+// yearWeekToDate returns the Sunday, "YYYY-MM-DD", whose year.week is the given one,
+// the reverse of timestampToYearWeek. The year is the calendar one, so the weeks 52
+// and 53 may also be the ones of the first days of January: the Sunday in December
+// is preferred for them, and the one in January for the rest.
+func yearWeekToDate(yearWeek string) (string, error) {
+	if !validYearWeek(yearWeek) {
+		return "", fmt.Errorf("invalid year.week %q", yearWeek)
+	}
+	year, _ := strconv.Atoi(yearWeek[:4])
+	week, _ := strconv.Atoi(yearWeek[5:])
+
+	// The Sundays of the year, in order.
+	var sundays []time.Time
+	sunday := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	sunday = sunday.AddDate(0, 0, (7-int(sunday.Weekday()))%7)
+	for ; sunday.Year() == year; sunday = sunday.AddDate(0, 0, 7) {
+		sundays = append(sundays, sunday)
+	}
+	if week >= 52 {
+		for i, j := 0, len(sundays)-1; i < j; i, j = i+1, j-1 {
+			sundays[i], sundays[j] = sundays[j], sundays[i]
+		}
+	}
+
+	for _, sunday := range sundays {
+		date := sunday.Format("2006-01-02")
+		if converted, _ := timestampToYearWeek(date); converted == yearWeek {
+			return date, nil
+		}
+	}
+	return "", fmt.Errorf("no Sunday has the year.week %q", yearWeek)
+}
@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Verifies that importing an export and exporting it again gives the same file.
+func TestImportFromJSONRoundTrip(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-01-01", 15000.5},
+		{"BTC", "2023-06-25", nil},
+		{"ETH", "2023-07-02", 1800.25},
+		{"ETH", "2023-12-31", 2300.75}, // Late December, with the year.week of 2023-01-01.
+	})
+
+	for _, dateFormat := range []string{DateFormatYearWeek, DateFormatISO} {
+		dir := t.TempDir()
+		exported := filepath.Join(dir, "export.json")
+		if _, err := Export(dbPath, exported, Options{DateFormat: dateFormat}); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+
+		importedDb := filepath.Join(dir, "imported.sqlite")
+		if err := ImportFromJSON(exported, importedDb); err != nil {
+			t.Fatalf("Import of the %s export failed: %v", dateFormat, err)
+		}
+		db, err := sql.Open("sqlite3", importedDb)
+		if err != nil {
+			t.Fatalf("Failed to open the imported database: %v", err)
+		}
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM crypto_prices WHERE symbol = 'ETH' AND timestamp = '2023-12-31'").Scan(&count)
+		db.Close()
+		if count != 1 {
+			t.Errorf("Expected the late December price to keep its date with the %s export", dateFormat)
+		}
+
+		reexported := filepath.Join(dir, "reexport.json")
+		if _, err := Export(importedDb, reexported, Options{DateFormat: dateFormat}); err != nil {
+			t.Fatalf("Export of the imported database failed: %v", err)
+		}
+
+		before, _ := os.ReadFile(exported)
+		after, _ := os.ReadFile(reexported)
+		if string(before) != string(after) {
+			t.Errorf("Expected the %s export to survive the round trip, got %s instead of %s", dateFormat, after, before)
+		}
+	}
+}
+
+// Verifies that every week gets the Sunday with its year.week.
+func TestYearWeekToDate(t *testing.T) {
+	cases := map[string]string{
+		"2023.25": "2023-06-25",
+		"2023.52": "2023-12-31", // The one in December, 2023-01-01 has it too.
+		"2024.01": "2024-01-07",
+		"2021.53": "2021-01-03", // Only in January.
+	}
+	for yearWeek, expected := range cases {
+		if date, err := yearWeekToDate(yearWeek); err != nil || date != expected {
+			t.Errorf("Expected %s for %s, got %s (%v)", expected, yearWeek, date, err)
+		}
+	}
+	if _, err := yearWeekToDate("2023.60"); err == nil {
+		t.Error("Expected an error for an invalid year.week")
+	}
+}
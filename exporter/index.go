@@ -0,0 +1,68 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// IndexCode is the code of the synthetic index exported with Options.Weights.
+const IndexCode = "INDEX"
+
+// ParseWeights parses the weights of the symbols of an index, e.g. {"BTC": "0.6"}.
+func ParseWeights(values map[string]string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(values))
+	for symbol, value := range values {
+		weight, err := strconv.ParseFloat(value, 64)
+		if symbol == "" || err != nil {
+			return nil, fmt.Errorf("invalid weight %s=%s, it must be a symbol and a number like BTC=0.6", symbol, value)
+		}
+		weights[symbol] = weight
+	}
+	return weights, nil
+}
+
+// weightedIndex combines the prices of the symbols in weights into a single CryptoOutput,
+// with the code IndexCode, whose value every week is the sum of the values of the
+// symbols times their weight. Only the weeks where every symbol has a value are kept.
+func weightedIndex(data map[string]*CryptoOutput, weights map[string]float64) (map[string]*CryptoOutput, error) {
+	type week struct {
+		yearWeek string
+		value    float64
+		symbols  int
+	}
+	weeks := make(map[time.Time]*week)
+	for symbol, weight := range weights {
+		output, ok := data[symbol]
+		if !ok {
+			return nil, fmt.Errorf("the symbol %s of the index has no prices", symbol)
+		}
+		for _, price := range output.Prices {
+			if price.Missing {
+				continue
+			}
+			w, ok := weeks[price.date]
+			if !ok {
+				w = &week{yearWeek: price.YearWeek}
+				weeks[price.date] = w
+			}
+			w.value += price.Value * weight
+			w.symbols++
+		}
+	}
+
+	dates := make([]time.Time, 0, len(weeks))
+	for date, w := range weeks {
+		if w.symbols == len(weights) {
+			dates = append(dates, date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	index := &CryptoOutput{Code: IndexCode, Prices: []PriceEntry{}, Category: outputCategory, Mode: outputMode}
+	for _, date := range dates {
+		index.Prices = append(index.Prices, PriceEntry{YearWeek: weeks[date].yearWeek, Value: weeks[date].value, date: date})
+	}
+	return map[string]*CryptoOutput{IndexCode: index}, nil
+}
@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is a row of the Parquet export. The timestamp is a DATE, the days since the Unix epoch.
+type parquetRow struct {
+	Symbol    string   `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Timestamp int32    `parquet:"name=timestamp, type=INT32, convertedtype=DATE"`
+	Value     *float64 `parquet:"name=value, type=DOUBLE, repetitiontype=OPTIONAL"` // null for the missing weeks.
+}
+
+// encodeParquet writes a row per price to w, sorted by symbol, with the symbol,
+// timestamp and value columns, see outputRows.
+func encodeParquet(w io.Writer, data map[string]*CryptoOutput) error {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+	if err != nil {
+		return fmt.Errorf("error creating the Parquet writer: %w", err)
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, row := range outputRows(data) {
+		date, err := time.Parse("2006-01-02", row.Timestamp)
+		if err != nil {
+			return fmt.Errorf("error converting timestamp: %w", err)
+		}
+		record := parquetRow{Symbol: row.Symbol, Timestamp: int32(date.Unix() / 86400)}
+		if !row.Missing {
+			value := row.Value
+			record.Value = &value
+		}
+		if err := pw.Write(record); err != nil {
+			return fmt.Errorf("error writing Parquet: %w", err)
+		}
+	}
+
+	if err := pw.WriteStop(); err != nil {
+		return fmt.Errorf("error writing Parquet: %w", err)
+	}
+	return nil
+}
+
+// ExportToParquet exports the prices of the database to a Parquet file, for analytics tools.
+// An existing output file is not overwritten.
+func ExportToParquet(dbPath, outputPath string) error {
+	_, err := Export(dbPath, outputPath, Options{Format: FormatParquet})
+	return err
+}
@@ -0,0 +1,52 @@
+package exporter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// Verifies that the Parquet export can be read back, with a row per price.
+func TestExportToParquet(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "2023-07-02", nil},
+		{"ETH", "2023-07-02", 1800.5},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.parquet")
+
+	if err := ExportToParquet(dbPath, outputPath); err != nil {
+		t.Fatalf("ExportToParquet failed: %v", err)
+	}
+
+	file, err := local.NewLocalFileReader(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open the Parquet file: %v", err)
+	}
+	defer file.Close()
+	pr, err := reader.NewParquetReader(file, new(parquetRow), 1)
+	if err != nil {
+		t.Fatalf("Failed to read the Parquet file: %v", err)
+	}
+	defer pr.ReadStop()
+
+	if n := pr.GetNumRows(); n != 3 {
+		t.Fatalf("Expected 3 rows, got %d", n)
+	}
+	rows := make([]parquetRow, 3)
+	if err := pr.Read(&rows); err != nil {
+		t.Fatalf("Failed to read the rows: %v", err)
+	}
+
+	eth := rows[2]
+	date := time.Unix(int64(eth.Timestamp)*86400, 0).UTC().Format("2006-01-02")
+	if eth.Symbol != "ETH" || date != "2023-07-02" || eth.Value == nil || *eth.Value != 1800.5 {
+		t.Errorf("Unexpected ETH row: %s %s %v", eth.Symbol, date, eth.Value)
+	}
+	if rows[1].Value != nil {
+		t.Errorf("Expected the missing BTC value to be null, got %v", *rows[1].Value)
+	}
+}
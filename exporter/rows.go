@@ -0,0 +1,231 @@
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/agviu/investrends/collector"
+)
+
+// Row is a single price as stored in the database.
+type Row struct {
+	Symbol    string
+	Timestamp string  // The date of the price, "YYYY-MM-DD".
+	Value     float64 // The price value, 0 when Missing.
+	Missing   bool    // The API had no value for the week, it's stored as NULL.
+	// The week of the price, "YYYY.WW", empty when the database doesn't store it.
+	YearWeek string
+	// The values of the optional columns present in the database, e.g. "volume".
+	// The NULL ones are left out.
+	Extra map[string]float64
+}
+
+// optionalColumns are the columns of crypto_prices that older databases may lack.
+// They are only selected when they exist, in this order.
+var optionalColumns = []string{"open", "high", "low", "volume"}
+
+// RowFilter selects the rows returned by FetchRows. The empty fields don't filter.
+type RowFilter struct {
+	Symbols []string // Only the rows of these symbols.
+	From    string   // Only the rows from this date, "YYYY-MM-DD", included.
+	To      string   // Only the rows until this date, "YYYY-MM-DD", included.
+	Tail    int      // Only the latest rows of every symbol, by date, among the selected ones.
+}
+
+// RowError is a row that could not be read from the database, or converted for the export.
+type RowError struct {
+	Symbol    string // The symbol of the row, when it could be read.
+	Timestamp string // The timestamp of the row, when it could be read.
+	Err       error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row of %q at %q: %v", e.Symbol, e.Timestamp, e.Err)
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// FetchRows queries the database for the prices selected by the filter, in the order
+// they were stored. Every export format reads its data with the same query.
+// The optional columns are only selected if the schema of the database has them.
+// A row that can't be read is an error.
+func FetchRows(db *sql.DB, filter RowFilter) ([]Row, error) {
+	rows, rowErrors, err := queryRows(context.Background(), db, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(rowErrors) > 0 {
+		return nil, fmt.Errorf("error scanning row: %w", rowErrors[0])
+	}
+	return rows, nil
+}
+
+// queryRows works like FetchRows, but the rows that can't be read are left out and
+// returned apart, so one bad row doesn't hide the rest of them.
+// The queries are aborted once ctx is done.
+func queryRows(ctx context.Context, db *sql.DB, filter RowFilter) ([]Row, []RowError, error) {
+	columns, err := collector.TableColumns(ctx, db, "crypto_prices")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading the columns of crypto_prices: %w", err)
+	}
+	for _, required := range []string{"symbol", "timestamp", "value"} {
+		if !columns[required] {
+			return nil, nil, fmt.Errorf("the crypto_prices table has no %s column, is it a database of the collector?", required)
+		}
+	}
+	var extraColumns []string
+	for _, column := range optionalColumns {
+		if columns[column] {
+			extraColumns = append(extraColumns, column)
+		}
+	}
+	baseColumns := []string{"symbol", "timestamp", "value"}
+	hasYearWeek := columns["year_week"]
+	if hasYearWeek {
+		baseColumns = append(baseColumns, "year_week")
+	}
+
+	var conditions []string
+	var args []interface{}
+	if len(filter.Symbols) > 0 {
+		conditions = append(conditions, "symbol IN (?"+strings.Repeat(", ?", len(filter.Symbols)-1)+")")
+		for _, symbol := range filter.Symbols {
+			args = append(args, symbol)
+		}
+	}
+	if filter.From != "" {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, filter.To)
+	}
+
+	selected := strings.Join(append(baseColumns, extraColumns...), ", ")
+	query := "SELECT " + selected + " FROM crypto_prices"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if filter.Tail > 0 {
+		// The rows are numbered per symbol from the latest one, so the database only
+		// returns the ones kept.
+		query = "SELECT " + selected + " FROM (SELECT id, " + selected +
+			", ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY timestamp DESC) AS recent" +
+			strings.TrimPrefix(query, "SELECT "+selected) + ") WHERE recent <= ?"
+		args = append(args, filter.Tail)
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying database: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Row
+	var rowErrors []RowError
+	for rows.Next() {
+		var row Row
+		var symbol, timestamp sql.NullString // Read apart, so a bad row can still be told.
+		var value sql.NullFloat64            // NULL for the weeks the API had no value.
+		var yearWeek sql.NullString          // NULL for the rows stored before the column existed.
+		extra := make([]sql.NullFloat64, len(extraColumns))
+		dest := []interface{}{&symbol, &timestamp, &value}
+		if hasYearWeek {
+			dest = append(dest, &yearWeek)
+		}
+		for i := range extra {
+			dest = append(dest, &extra[i])
+		}
+		err := rows.Scan(dest...)
+		if err == nil && (!symbol.Valid || !timestamp.Valid) {
+			err = fmt.Errorf("the symbol and the timestamp can't be NULL")
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Symbol: symbol.String, Timestamp: timestamp.String, Err: err})
+			continue
+		}
+		row.Symbol, row.Timestamp, row.YearWeek = symbol.String, timestamp.String, yearWeek.String
+		row.Value, row.Missing = value.Float64, !value.Valid
+		for i, column := range extraColumns {
+			if extra[i].Valid {
+				if row.Extra == nil {
+					row.Extra = make(map[string]float64)
+				}
+				row.Extra[column] = extra[i].Float64
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading rows: %w", err)
+	}
+	return result, rowErrors, nil
+}
+
+// TidyRows converts the rows to a table of strings with a header (symbol, timestamp, value),
+// ready for generic formats like CSV. The optional columns with a value in any row are
+// appended to the header. Missing values are empty.
+func TidyRows(rows []Row) [][]string {
+	header := []string{"symbol", "timestamp", "value"}
+	var extraColumns []string
+	for _, column := range optionalColumns {
+		for _, row := range rows {
+			if _, ok := row.Extra[column]; ok {
+				extraColumns = append(extraColumns, column)
+				break
+			}
+		}
+	}
+
+	table := make([][]string, 0, len(rows)+1)
+	table = append(table, append(header, extraColumns...))
+	for _, row := range rows {
+		value := ""
+		if !row.Missing {
+			value = formatValue(row.Value)
+		}
+		record := []string{row.Symbol, row.Timestamp, value}
+		for _, column := range extraColumns {
+			extra, ok := row.Extra[column]
+			if !ok {
+				record = append(record, "")
+				continue
+			}
+			record = append(record, formatValue(extra))
+		}
+		table = append(table, record)
+	}
+	return table
+}
+
+// outputRows converts the data organized for the export back to a row per price,
+// sorted by symbol, once it's filled or weighted, so the tabular formats are built
+// from the rows as well. The timestamp is the date of the price, and the year.week
+// the one of the export.
+func outputRows(data map[string]*CryptoOutput) []Row {
+	var rows []Row
+	for _, output := range sortedOutputs(data) {
+		for _, price := range output.Prices {
+			rows = append(rows, Row{
+				Symbol:    output.Code,
+				Timestamp: price.date.Format("2006-01-02"),
+				Value:     price.Value,
+				Missing:   price.Missing,
+				YearWeek:  price.YearWeek,
+			})
+		}
+	}
+	return rows
+}
+
+// formatValue formats a value with the digits needed, and no exponent.
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
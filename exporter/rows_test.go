@@ -0,0 +1,221 @@
+package exporter
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/agviu/investrends/collector"
+)
+
+// Verifies that FetchRows applies the filters, and TidyRows converts the result.
+func TestFetchRows(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-18", 26000.0},
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "2023-07-02", nil},
+		{"ETH", "2023-06-25", 1800.0},
+		{"ADA", "2023-06-25", 0.3},
+	})
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+
+	all, err := FetchRows(db, RowFilter{})
+	if err != nil {
+		t.Fatalf("FetchRows failed: %v", err)
+	}
+	if len(all) != 5 {
+		t.Errorf("Expected every row without filters, got %d", len(all))
+	}
+
+	rows, err := FetchRows(db, RowFilter{Symbols: []string{"BTC", "ETH"}, From: "2023-06-25", To: "2023-07-02"})
+	if err != nil {
+		t.Fatalf("FetchRows failed: %v", err)
+	}
+	expected := [][]string{
+		{"symbol", "timestamp", "value"},
+		{"BTC", "2023-06-25", "27000"},
+		{"BTC", "2023-07-02", ""},
+		{"ETH", "2023-06-25", "1800"},
+	}
+	if table := TidyRows(rows); !reflect.DeepEqual(table, expected) {
+		t.Errorf("Expected the filtered rows %v, got %v", expected, table)
+	}
+}
+
+// Verifies that only the columns in the schema of the database are selected and emitted.
+func TestFetchRowsSchemaColumns(t *testing.T) {
+	// A database created before the OHLCV columns existed.
+	minimalPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+	})
+
+	// A database with the full schema of the collector.
+	fullPath := filepath.Join(t.TempDir(), "crypto.sqlite")
+	full, err := sql.Open("sqlite3", fullPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if _, err := collector.Migrate(full); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	if _, err := full.Exec("INSERT INTO crypto_prices (symbol, timestamp, value, volume) VALUES ('BTC', '2023-07-02', 28000, 1200.5)"); err != nil {
+		t.Fatalf("Failed to insert test row: %v", err)
+	}
+	full.Close()
+
+	cases := []struct {
+		dbPath string
+		header []string
+	}{
+		{minimalPath, []string{"symbol", "timestamp", "value"}},
+		{fullPath, []string{"symbol", "timestamp", "value", "volume"}},
+	}
+	for _, tc := range cases {
+		db, err := sql.Open("sqlite3", tc.dbPath)
+		if err != nil {
+			t.Fatalf("Failed to open test database: %v", err)
+		}
+		rows, err := FetchRows(db, RowFilter{})
+		db.Close()
+		if err != nil {
+			t.Fatalf("FetchRows failed: %v", err)
+		}
+		if table := TidyRows(rows); !reflect.DeepEqual(table[0], tc.header) {
+			t.Errorf("Expected the header %v, got %v", tc.header, table[0])
+		}
+
+		if _, err := Export(tc.dbPath, filepath.Join(t.TempDir(), "output.json"), Options{}); err != nil {
+			t.Errorf("Export failed: %v", err)
+		}
+	}
+}
+
+// Verifies that Tail keeps the latest rows of every symbol by date, after the date filter.
+func TestFetchRowsTail(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 28000.0},
+		{"BTC", "2023-06-18", 26000.0},
+		{"ETH", "2023-06-25", 1800.0},
+		{"BTC", "2023-07-09", 29000.0},
+		{"BTC", "2023-06-25", 27000.0},
+		{"ADA", "2023-06-25", 0.3},
+		{"ETH", "2023-07-02", 1900.0},
+		{"ETH", "2023-06-18", 1700.0},
+	})
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := FetchRows(db, RowFilter{Tail: 2})
+	if err != nil {
+		t.Fatalf("FetchRows failed: %v", err)
+	}
+	expected := [][]string{
+		{"symbol", "timestamp", "value"},
+		{"BTC", "2023-07-02", "28000"},
+		{"ETH", "2023-06-25", "1800"},
+		{"BTC", "2023-07-09", "29000"},
+		{"ADA", "2023-06-25", "0.3"},
+		{"ETH", "2023-07-02", "1900"},
+	}
+	if table := TidyRows(rows); !reflect.DeepEqual(table, expected) {
+		t.Errorf("Expected the 2 latest rows per symbol %v, got %v", expected, table)
+	}
+
+	rows, err = FetchRows(db, RowFilter{To: "2023-06-25", Tail: 1})
+	if err != nil {
+		t.Fatalf("FetchRows failed: %v", err)
+	}
+	expected = [][]string{
+		{"symbol", "timestamp", "value"},
+		{"ETH", "2023-06-25", "1800"},
+		{"BTC", "2023-06-25", "27000"},
+		{"ADA", "2023-06-25", "0.3"},
+	}
+	if table := TidyRows(rows); !reflect.DeepEqual(table, expected) {
+		t.Errorf("Expected the latest row per symbol until the date %v, got %v", expected, table)
+	}
+}
+
+// Verifies that the year.week backfilled in the database matches the one derived on export.
+func TestFetchRowsBackfilledYearWeek(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "crypto.sqlite")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+	if _, err := collector.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	// Rows stored before the year_week column existed, across a change of year.
+	for _, timestamp := range []string{"2022-12-25", "2023-01-01", "2023-01-08", "2023-07-02"} {
+		if _, err := db.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES ('BTC', ?, 1)", timestamp); err != nil {
+			t.Fatalf("Failed to insert test row: %v", err)
+		}
+	}
+
+	updated, err := collector.BackfillYearWeek(db)
+	if err != nil {
+		t.Fatalf("BackfillYearWeek failed: %v", err)
+	}
+	if updated != 4 {
+		t.Errorf("Expected 4 rows backfilled, got %d", updated)
+	}
+
+	rows, err := FetchRows(db, RowFilter{})
+	if err != nil {
+		t.Fatalf("FetchRows failed: %v", err)
+	}
+	for _, row := range rows {
+		expected, err := timestampToYearWeek(row.Timestamp)
+		if err != nil || row.YearWeek != expected {
+			t.Errorf("Expected the year.week %s for %s, got %q (%v)", expected, row.Timestamp, row.YearWeek, err)
+		}
+	}
+}
+
+// Verifies that the CSV export is the table of TidyRows, with the date column named as in the export.
+func TestExportCSVTidyRows(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+		{"BTC", "2023-07-02", nil},
+		{"ETH", "2023-06-25", 1800.5},
+	})
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := FetchRows(db, RowFilter{})
+	if err != nil {
+		t.Fatalf("FetchRows failed: %v", err)
+	}
+	var expected strings.Builder
+	expected.WriteString("symbol,date,value\n")
+	for _, record := range TidyRows(rows)[1:] {
+		expected.WriteString(strings.Join(record, ",") + "\n")
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.csv")
+	if _, err := Export(dbPath, outputPath, Options{Format: FormatCSV, DateFormat: DateFormatISO}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if string(content) != expected.String() {
+		t.Errorf("Expected the CSV %q, got %q", expected.String(), content)
+	}
+}
@@ -0,0 +1,40 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sqlBatchSize is the number of rows of every INSERT statement of the SQL export.
+const sqlBatchSize = 500
+
+// encodeSQL writes the rows to w as INSERT statements into crypto_prices, of at most
+// sqlBatchSize rows each, to load them into another database. Missing values are NULL.
+func encodeSQL(w io.Writer, rows []Row) error {
+	for start := 0; start < len(rows); start += sqlBatchSize {
+		var statement strings.Builder
+		statement.WriteString("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES\n")
+		for i, row := range rows[start:min(start+sqlBatchSize, len(rows))] {
+			if i > 0 {
+				statement.WriteString(",\n")
+			}
+			value := "NULL"
+			if !row.Missing {
+				value = formatValue(row.Value)
+			}
+			fmt.Fprintf(&statement, "(%s, %s, %s)", sqlString(row.Symbol), sqlString(row.Timestamp), value)
+		}
+		statement.WriteString(";\n")
+
+		if _, err := io.WriteString(w, statement.String()); err != nil {
+			return fmt.Errorf("error writing SQL: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqlString quotes s as an SQL string literal, doubling its single quotes.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
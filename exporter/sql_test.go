@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Verifies that running the SQL export against a fresh database reproduces the rows.
+func TestExportSQL(t *testing.T) {
+	original := [][]interface{}{
+		{"BTC", "2023-06-25", 27000.125},
+		{"BTC", "2023-07-02", nil},
+		{"O'NE", "2023-07-02", 0.000001},
+	}
+	dbPath := createTestDb(t, original)
+	outputPath := filepath.Join(t.TempDir(), "output.sql")
+	stats, err := Export(dbPath, outputPath, Options{Format: FormatSQL})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if stats.Symbols != 2 {
+		t.Errorf("Expected the stats of the 2 symbols dumped, got %+v", stats)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", createTestDb(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(content)); err != nil {
+		t.Fatalf("Failed to run the exported SQL %q: %v", content, err)
+	}
+
+	source, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	expected, err := FetchRows(source, RowFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := FetchRows(db, RowFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("Expected the rows %+v to be reproduced, got %+v", expected, loaded)
+	}
+
+	if _, err := Export(dbPath, outputPath, Options{Format: FormatSQL, Fill: FillForward, Force: true}); err == nil {
+		t.Error("Expected an error filling the SQL export")
+	}
+}
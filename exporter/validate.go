@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// yearWeekPattern matches the "YYYY.WW" format of the price entries.
+var yearWeekPattern = regexp.MustCompile(`^\d{4}\.\d{2}$`)
+
+// ValidateExport checks that the file at path is a valid export in the objects shape:
+// every CryptoOutput has a code, the expected category and mode, and prices with
+// a valid "year.week" and a positive (or null) value. It returns the first problem found.
+func ValidateExport(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening JSON file: %w", err)
+	}
+	defer file.Close()
+
+	var outputs []CryptoOutput
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields() // Unknown fields mean the shape has drifted.
+	if err := decoder.Decode(&outputs); err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	for i, output := range outputs {
+		if output.Code == "" {
+			return fmt.Errorf("entry %d: empty code", i)
+		}
+		if output.Category != outputCategory {
+			return fmt.Errorf("%s: expected category %q, got %q", output.Code, outputCategory, output.Category)
+		}
+		if output.Mode != outputMode {
+			return fmt.Errorf("%s: expected mode %q, got %q", output.Code, outputMode, output.Mode)
+		}
+		for _, price := range output.Prices {
+			if !validYearWeek(price.YearWeek) {
+				return fmt.Errorf("%s: invalid year.week %q", output.Code, price.YearWeek)
+			}
+			if !price.Missing && price.Value <= 0 {
+				return fmt.Errorf("%s: value %v of week %s is not positive", output.Code, price.Value, price.YearWeek)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validYearWeek tells if yw is in "YYYY.WW" format with an ISO week between 1 and 53.
+func validYearWeek(yw string) bool {
+	if !yearWeekPattern.MatchString(yw) {
+		return false
+	}
+	week, _ := strconv.Atoi(yw[5:])
+	return week >= 1 && week <= 53
+}
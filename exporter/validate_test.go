@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestFile writes content to a file in a temporary directory and returns its path.
+func writeTestFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "output.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return path
+}
+
+// Verifies that a freshly exported file passes the validation.
+func TestValidateExportValid(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-07-02", 30000.0},
+		{"BTC", "2023-07-09", 31000.0},
+		{"ETH", "2023-07-09", 1800.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+	if err := ExportToJSON(dbPath, outputPath); err != nil {
+		t.Fatalf("ExportToJSON failed: %v", err)
+	}
+
+	if err := ValidateExport(outputPath); err != nil {
+		t.Errorf("Expected the export to be valid, got: %v", err)
+	}
+}
+
+// Verifies that malformed exports are rejected.
+func TestValidateExportMalformed(t *testing.T) {
+	cases := map[string]string{
+		"not json":       `{"code": `,
+		"tuples shape":   `{"BTC": [[1688256000000, 30000]]}`,
+		"empty code":     `[{"code": "", "prices": [], "category": "crypto", "mode": "year.week"}]`,
+		"wrong category": `[{"code": "BTC", "prices": [], "category": "stock", "mode": "year.week"}]`,
+		"wrong mode":     `[{"code": "BTC", "prices": [], "category": "crypto", "mode": "day"}]`,
+		"bad year.week":  `[{"code": "BTC", "prices": [{"year.week": "2023-27", "value": 1}], "category": "crypto", "mode": "year.week"}]`,
+		"week 54":        `[{"code": "BTC", "prices": [{"year.week": "2023.54", "value": 1}], "category": "crypto", "mode": "year.week"}]`,
+		"zero value":     `[{"code": "BTC", "prices": [{"year.week": "2023.27", "value": 0}], "category": "crypto", "mode": "year.week"}]`,
+		"negative value": `[{"code": "BTC", "prices": [{"year.week": "2023.27", "value": -5}], "category": "crypto", "mode": "year.week"}]`,
+		"unknown field":  `[{"code": "BTC", "prices": [], "category": "crypto", "mode": "year.week", "extra": 1}]`,
+	}
+
+	for name, content := range cases {
+		if err := ValidateExport(writeTestFile(t, content)); err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+		}
+	}
+
+	if err := ValidateExport(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file, got nil")
+	}
+}
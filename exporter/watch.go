@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// dbState returns the modification time and size of the database file, and of its
+// write-ahead log if there's one, so any write to the database changes it.
+func dbState(dbPath string) string {
+	state := ""
+	for _, path := range []string{dbPath, dbPath + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			state += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return state
+}
+
+// Watch calls export once, and again every time the database in dbPath changes, until
+// ctx is done. The database is polled every interval, and export waits until it didn't
+// change for the debounce duration, so a collection storing many symbols in a row
+// triggers a single export. The errors of export are logged, they don't stop watching.
+func Watch(ctx context.Context, dbPath string, interval, debounce time.Duration, export func() error) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid watch interval %v", interval)
+	}
+
+	last := dbState(dbPath)
+	if err := export(); err != nil {
+		slog.Error("Failed to export the data", "err", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := false
+	var changedAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if current := dbState(dbPath); current != last {
+			last, changedAt, pending = current, time.Now(), true
+		}
+		if !pending || time.Since(changedAt) < debounce {
+			continue
+		}
+
+		pending = false
+		slog.Info("The database changed, exporting again", "db", dbPath)
+		if err := export(); err != nil {
+			slog.Error("Failed to export the data", "err", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Verifies that Watch exports once when it starts, and again after the database changes.
+func TestWatch(t *testing.T) {
+	dbPath := createTestDb(t, [][]interface{}{
+		{"BTC", "2023-06-25", 27000.0},
+	})
+	outputPath := filepath.Join(t.TempDir(), "output.json")
+
+	var exports int32
+	exported := make(chan ExportStats, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, dbPath, 10*time.Millisecond, 30*time.Millisecond, func() error {
+			atomic.AddInt32(&exports, 1)
+			stats, err := Export(dbPath, outputPath, Options{Force: true})
+			exported <- stats
+			return err
+		})
+	}()
+
+	waitExport := func() ExportStats {
+		select {
+		case stats := <-exported:
+			return stats
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an export")
+		}
+		return ExportStats{}
+	}
+
+	if stats := waitExport(); stats.Entries != 1 {
+		t.Errorf("Expected 1 entry in the first export, got %d", stats.Entries)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO crypto_prices (symbol, timestamp, value) VALUES ('BTC', '2023-07-02', 28000)"); err != nil {
+		t.Fatalf("Failed to insert test row: %v", err)
+	}
+	db.Close()
+
+	if stats := waitExport(); stats.Entries != 2 {
+		t.Errorf("Expected 2 entries after the change, got %d", stats.Entries)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected Watch to stop without error, got %v", err)
+	}
+	if got := atomic.LoadInt32(&exports); got != 2 {
+		t.Errorf("Expected 2 exports, got %d", got)
+	}
+}